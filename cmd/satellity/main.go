@@ -7,13 +7,16 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path"
 	"path/filepath"
 	"satellity/internal/configs"
 	"satellity/internal/controllers"
 	"satellity/internal/durable"
 	"satellity/internal/middlewares"
+	"satellity/internal/models"
 	"strings"
+	"syscall"
 
 	"github.com/dimfeld/httptreemux"
 	"github.com/gorilla/handlers"
@@ -38,10 +41,36 @@ func startHTTP(db *sql.DB, logger *zap.Logger, port string) error {
 	return http.ListenAndServe(fmt.Sprintf(":%s", port), handler)
 }
 
+func migrate(db *sql.DB, dryRun bool) error {
+	mctx := models.WrapContext(context.Background(), durable.WrapDatabase(db))
+	pending, err := models.Migrate(mctx, dryRun)
+	for _, m := range pending {
+		if dryRun {
+			fmt.Printf("-- %d %s\n%s\n", m.Version, m.Name, strings.TrimSpace(m.SQL))
+		} else {
+			fmt.Printf("applied %d %s\n", m.Version, m.Name)
+		}
+	}
+	return err
+}
+
+// reloadOnHangup reloads the config on SIGHUP, e.g. to toggle maintenance.read_only
+func reloadOnHangup() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	for range c {
+		if err := configs.Reload(); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
 func main() {
 	var options struct {
 		Dir         string `short:"d" long:"dir" description:"Where's the config file place, default ./internal/configs/config.yaml"`
 		Environment string `short:"e" long:"environment" default:"development"`
+		Migrate     bool   `short:"m" long:"migrate" description:"Apply the pending migrations and exit"`
+		DryRun      bool   `long:"dry-run" description:"Print the pending migrations of --migrate without applying"`
 	}
 	p := flags.NewParser(&options, flags.Default)
 	if _, err := p.Parse(); err != nil {
@@ -64,7 +93,7 @@ func main() {
 		log.Panicln(err)
 	}
 
-	config := configs.AppConfig
+	config := configs.Current()
 	db := durable.OpenDatabaseClient(context.Background(), &durable.ConnectionInfo{
 		User:     config.Database.User,
 		Password: config.Database.Password,
@@ -74,6 +103,15 @@ func main() {
 	})
 	defer db.Close()
 
+	go reloadOnHangup()
+
+	if options.Migrate {
+		if err := migrate(db, options.DryRun); err != nil {
+			log.Panicln(err)
+		}
+		return
+	}
+
 	logger, err := zap.NewDevelopment()
 	if config.Environment == "production" {
 		logger, err = zap.NewProduction()
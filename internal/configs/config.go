@@ -1,8 +1,14 @@
 package configs
 
 import (
+	"fmt"
 	"io/ioutil"
+	"net/mail"
 	"path"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	yaml "gopkg.in/yaml.v2"
 )
@@ -10,6 +16,9 @@ import (
 const (
 	// BuildVersion application
 	BuildVersion = "BUILD_VERSION"
+
+	// EnvironmentProduction ignores operators_extra
+	EnvironmentProduction = "production"
 )
 
 // Option application
@@ -29,23 +38,156 @@ type Option struct {
 	Github struct {
 		ClientID     string `yaml:"client_id"`
 		ClientSecret string `yaml:"client_secret"`
+		Timeout      string `yaml:"timeout"`
 	} `yaml:"github"`
 	System struct {
 		Attachments struct {
 			Storage string `yaml:"storage"`
 			Path    string `yaml:"path"`
+			MaxSize int64  `yaml:"max_size"`
 		} `yaml:"attachments"`
+		BiographyPolicy             string            `yaml:"biography_policy"`
+		GenerateNickname            bool              `yaml:"generate_nickname"`
+		ValidateEmailMX             *bool             `yaml:"validate_email_mx"`
+		EmailRequired               *bool             `yaml:"email_required"`
+		EmailVerificationCooldown   string            `yaml:"email_verification_cooldown"`
+		ProfileUpdateCooldown       string            `yaml:"profile_update_cooldown"`
+		RejectNicknameImpersonation bool              `yaml:"reject_nickname_impersonation"`
+		RejectConfusableUsernames   bool              `yaml:"reject_confusable_usernames"`
+		BootstrapFirstAdmin         bool              `yaml:"bootstrap_first_admin"`
+		PasswordCost                int               `yaml:"password_cost"`
+		RejectEmptyProfileUpdate    bool              `yaml:"reject_empty_profile_update"`
+		UsersListMaxDepth           int               `yaml:"users_list_max_depth"`
+		Settings                    map[string]string `yaml:"settings"`
+		RateLimits                  struct {
+			TopicsPerHour       int `yaml:"topics_per_hour"`
+			CommentsPerMinute   int `yaml:"comments_per_minute"`
+			FailedLoginsPerHour int `yaml:"failed_logins_per_hour"`
+		} `yaml:"rate_limits"`
 	} `yaml:"system"`
-	Operators []string `yaml:"operators"`
+	Session struct {
+		MaxPerUser       int               `yaml:"max_per_user"`
+		CacheTTL         string            `yaml:"cache_ttl"`
+		Keys             map[string]string `yaml:"keys"`
+		CleanupBatchSize int               `yaml:"cleanup_batch_size"`
+		JWTIssuer        string            `yaml:"jwt_issuer"`
+		JWTAudience      string            `yaml:"jwt_audience"`
+		Relogin          string            `yaml:"relogin"`
+		ShortTTL         string            `yaml:"short_ttl"`
+		LongTTL          string            `yaml:"long_ttl"`
+	} `yaml:"session"`
+	Username struct {
+		MinLength       int   `yaml:"min_length"`
+		MaxLength       int   `yaml:"max_length"`
+		CaseInsensitive *bool `yaml:"case_insensitive"`
+	} `yaml:"username"`
+	Maintenance struct {
+		ReadOnly bool `yaml:"read_only"`
+	} `yaml:"maintenance"`
+	Operators      []string `yaml:"operators"`
+	OperatorsExtra []string `yaml:"operators_extra"`
 
 	Environment string
 	OperatorSet map[string]bool
+	Durations   Durations
+}
+
+// Durations are the parsed duration fields of Option, zero if blank
+type Durations struct {
+	GithubTimeout             time.Duration
+	EmailVerificationCooldown time.Duration
+	ProfileUpdateCooldown     time.Duration
+	SessionCacheTTL           time.Duration
+	SessionShortTTL           time.Duration
+	SessionLongTTL            time.Duration
+}
+
+// parseDurations parses the duration strings like "15m" into opt.Durations,
+// the error names the field of the invalid value.
+func (opt *Option) parseDurations() error {
+	fields := []struct {
+		name  string
+		value string
+		dest  *time.Duration
+	}{
+		{"github.timeout", opt.Github.Timeout, &opt.Durations.GithubTimeout},
+		{"system.email_verification_cooldown", opt.System.EmailVerificationCooldown, &opt.Durations.EmailVerificationCooldown},
+		{"system.profile_update_cooldown", opt.System.ProfileUpdateCooldown, &opt.Durations.ProfileUpdateCooldown},
+		{"session.cache_ttl", opt.Session.CacheTTL, &opt.Durations.SessionCacheTTL},
+		{"session.short_ttl", opt.Session.ShortTTL, &opt.Durations.SessionShortTTL},
+		{"session.long_ttl", opt.Session.LongTTL, &opt.Durations.SessionLongTTL},
+	}
+	for _, f := range fields {
+		if f.value == "" {
+			*f.dest = 0
+			continue
+		}
+		d, err := time.ParseDuration(f.value)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: %v", f.name, f.value, err)
+		}
+		*f.dest = d
+	}
+	return nil
+}
+
+// current is the loaded option of current environment, it's replaced as a
+// whole by Reload while requests read it
+var current atomic.Value
+
+// Current returns the loaded option of current environment, nil if not loaded
+func Current() *Option {
+	opt, _ := current.Load().(*Option)
+	return opt
+}
+
+// Set replaces the current option, e.g. by tests
+func Set(opt *Option) {
+	current.Store(opt)
+}
+
+// settingParsers validates the system.settings of known types at load
+var settingParsers = map[string]func(string) error{
+	"max_topics_per_day": func(v string) error {
+		_, err := strconv.Atoi(v)
+		return err
+	},
+}
+
+// Setting returns the value of key in system.settings
+func (opt *Option) Setting(key string) (string, bool) {
+	if opt == nil {
+		return "", false
+	}
+	v, ok := opt.System.Settings[key]
+	return v, ok
 }
 
-var appConfig *Option
+// Setting returns the value of key in system.settings of Current
+func Setting(key string) (string, bool) {
+	return Current().Setting(key)
+}
+
+var loaded struct {
+	sync.Mutex
+	dir, env string
+}
+
+// Reload reads the config file of Init again, e.g. to toggle maintenance.read_only
+func Reload() error {
+	loaded.Lock()
+	dir, env := loaded.dir, loaded.env
+	loaded.Unlock()
+	if dir == "" {
+		return fmt.Errorf("config not initialized")
+	}
+	return Init(dir, env)
+}
 
 // Init application
 func Init(dir, env string) error {
+	loaded.Lock()
+	defer loaded.Unlock()
 	data, err := ioutil.ReadFile(path.Join(dir, "./config.yaml"))
 	if err != nil {
 		return err
@@ -62,6 +204,30 @@ func Init(dir, env string) error {
 	for _, operator := range opt.Operators {
 		opt.OperatorSet[operator] = true
 	}
-	appConfig = &opt
+	if env != EnvironmentProduction {
+		for _, operator := range opt.OperatorsExtra {
+			if _, err := mail.ParseAddress(operator); err != nil {
+				return fmt.Errorf("invalid operators_extra %q: %v", operator, err)
+			}
+			opt.OperatorSet[operator] = true
+		}
+	}
+	for key, parse := range settingParsers {
+		if v, ok := opt.System.Settings[key]; ok {
+			if err := parse(v); err != nil {
+				return fmt.Errorf("invalid system.settings %s %q: %v", key, v, err)
+			}
+		}
+	}
+	switch opt.Session.Relogin {
+	case "", "new", "reuse":
+	default:
+		return fmt.Errorf("invalid session.relogin %q", opt.Session.Relogin)
+	}
+	if err := opt.parseDurations(); err != nil {
+		return err
+	}
+	Set(&opt)
+	loaded.dir, loaded.env = dir, env
 	return nil
 }
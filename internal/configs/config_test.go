@@ -0,0 +1,128 @@
+package configs
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testConfig = `
+default: &default
+  operators:
+    - hi@gmail.com
+  operators_extra:
+    - staging@gmail.com
+
+staging:
+  <<: *default
+
+production:
+  <<: *default
+`
+
+func TestOperatorsExtra(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "configs")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(path.Join(dir, "config.yaml"), []byte(testConfig), 0644)
+	assert.Nil(err)
+
+	err = Init(dir, "staging")
+	assert.Nil(err)
+	assert.True(Current().OperatorSet["hi@gmail.com"])
+	assert.True(Current().OperatorSet["staging@gmail.com"])
+	err = Init(dir, EnvironmentProduction)
+	assert.Nil(err)
+	assert.True(Current().OperatorSet["hi@gmail.com"])
+	assert.False(Current().OperatorSet["staging@gmail.com"])
+
+	err = ioutil.WriteFile(path.Join(dir, "config.yaml"), []byte("staging:\n  operators_extra:\n    - invalid\n"), 0644)
+	assert.Nil(err)
+	assert.NotNil(Init(dir, "staging"))
+}
+
+func TestSetting(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "configs")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	data := "test:\n  system:\n    settings:\n      max_topics_per_day: \"20\"\n      theme: dark\n"
+	err = ioutil.WriteFile(path.Join(dir, "config.yaml"), []byte(data), 0644)
+	assert.Nil(err)
+
+	err = Init(dir, "test")
+	assert.Nil(err)
+	v, ok := Setting("theme")
+	assert.True(ok)
+	assert.Equal("dark", v)
+	v, ok = Setting("max_topics_per_day")
+	assert.True(ok)
+	assert.Equal("20", v)
+	v, ok = Setting("absent")
+	assert.False(ok)
+	assert.Equal("", v)
+
+	data = "test:\n  system:\n    settings:\n      max_topics_per_day: many\n"
+	err = ioutil.WriteFile(path.Join(dir, "config.yaml"), []byte(data), 0644)
+	assert.Nil(err)
+	assert.NotNil(Init(dir, "test"))
+}
+
+func TestReload(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "configs")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(path.Join(dir, "config.yaml"), []byte("test:\n  maintenance:\n    read_only: false\n"), 0644)
+	assert.Nil(err)
+
+	err = Init(dir, "test")
+	assert.Nil(err)
+	assert.False(Current().Maintenance.ReadOnly)
+	err = ioutil.WriteFile(path.Join(dir, "config.yaml"), []byte("test:\n  maintenance:\n    read_only: true\n"), 0644)
+	assert.Nil(err)
+	err = Reload()
+	assert.Nil(err)
+	assert.True(Current().Maintenance.ReadOnly)
+	assert.Equal("test", Current().Environment)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			assert.Nil(Reload())
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		assert.True(Current().Maintenance.ReadOnly)
+	}
+	<-done
+}
+
+func TestParseDurations(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "configs")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	data := "test:\n  github:\n    timeout: 30s\n  session:\n    cache_ttl: 2h\n"
+	err = ioutil.WriteFile(path.Join(dir, "config.yaml"), []byte(data), 0644)
+	assert.Nil(err)
+	err = Init(dir, "test")
+	assert.Nil(err)
+	assert.Equal(30*time.Second, Current().Durations.GithubTimeout)
+	assert.Equal(2*time.Hour, Current().Durations.SessionCacheTTL)
+	assert.Equal(time.Duration(0), Current().Durations.EmailVerificationCooldown)
+
+	data = "test:\n  system:\n    email_verification_cooldown: soon\n"
+	err = ioutil.WriteFile(path.Join(dir, "config.yaml"), []byte(data), 0644)
+	assert.Nil(err)
+	err = Init(dir, "test")
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "system.email_verification_cooldown")
+}
@@ -38,8 +38,6 @@ func (impl *categoryImpl) topics(w http.ResponseWriter, r *http.Request, params
 	category, err := models.ReadCategory(mctx, params["id"])
 	if err != nil {
 		views.RenderErrorResponse(w, r, err)
-	} else if category == nil {
-		views.RenderErrorResponse(w, r, session.NotFoundError(r.Context()))
 	} else if topics, err := category.ReadTopics(mctx, offset); err != nil {
 		views.RenderErrorResponse(w, r, err)
 	} else {
@@ -8,7 +8,6 @@ import (
 	"satellity/internal/models"
 	"satellity/internal/session"
 	"satellity/internal/views"
-	"time"
 
 	"github.com/dimfeld/httptreemux"
 )
@@ -70,15 +69,12 @@ func (impl *commentImpl) destroy(w http.ResponseWriter, r *http.Request, params
 }
 
 func (impl *commentImpl) comments(w http.ResponseWriter, r *http.Request, params map[string]string) {
-	offset, _ := time.Parse(time.RFC3339Nano, r.URL.Query().Get("offset"))
 	mctx := models.WrapContext(r.Context(), impl.database)
 	if topic, err := models.ReadTopic(mctx, params["id"]); err != nil {
 		views.RenderErrorResponse(w, r, err)
-	} else if topic == nil {
-		views.RenderErrorResponse(w, r, session.NotFoundError(r.Context()))
-	} else if comments, err := topic.ReadComments(mctx, offset); err != nil {
+	} else if page, err := topic.ReadComments(mctx, r.URL.Query().Get("cursor"), r.URL.Query().Get("order")); err != nil {
 		views.RenderErrorResponse(w, r, err)
 	} else {
-		views.RenderComments(w, r, comments)
+		views.RenderCommentsPage(w, r, page)
 	}
 }
@@ -123,8 +123,6 @@ func (impl *groupImpl) show(w http.ResponseWriter, r *http.Request, params map[s
 	mctx := models.WrapContext(r.Context(), impl.database)
 	if group, err := models.ReadGroup(mctx, params["id"], middlewares.CurrentUser(r)); err != nil {
 		views.RenderErrorResponse(w, r, err)
-	} else if group == nil {
-		views.RenderErrorResponse(w, r, session.NotFoundError(r.Context()))
 	} else {
 		views.RenderGroup(w, r, group)
 	}
@@ -153,8 +151,6 @@ func (impl *groupImpl) participants(w http.ResponseWriter, r *http.Request, para
 	current := middlewares.CurrentUser(r)
 	if group, err := models.ReadGroup(mctx, params["id"], current); err != nil {
 		views.RenderErrorResponse(w, r, err)
-	} else if group == nil {
-		views.RenderErrorResponse(w, r, session.NotFoundError(r.Context()))
 	} else if users, err := group.Participants(mctx, current, offset, r.URL.Query().Get("limit")); err != nil {
 		views.RenderErrorResponse(w, r, err)
 	} else {
@@ -169,8 +165,6 @@ func (impl *groupImpl) messages(w http.ResponseWriter, r *http.Request, params m
 	group, err := models.ReadGroup(mctx, params["id"], middlewares.CurrentUser(r))
 	if err != nil {
 		views.RenderErrorResponse(w, r, err)
-	} else if group == nil {
-		views.RenderErrorResponse(w, r, session.NotFoundError(r.Context()))
 	} else if messages, err := group.ReadMessages(mctx, offset); err != nil {
 		views.RenderErrorResponse(w, r, err)
 	} else {
@@ -6,6 +6,7 @@ import (
 	"satellity/internal/configs"
 	"satellity/internal/controllers/admin"
 	"satellity/internal/durable"
+	"satellity/internal/models"
 	"satellity/internal/session"
 	"satellity/internal/views"
 
@@ -15,6 +16,7 @@ import (
 // RegisterRoutes register all routes
 func RegisterRoutes(database *durable.Database, router *httptreemux.TreeMux) {
 	router.GET("/_hc", health)
+	router.GET("/healthz", healthz(database))
 	registerUser(database, router)
 	registerCategory(database, router)
 	registerTopic(database, router)
@@ -31,6 +33,14 @@ func health(w http.ResponseWriter, r *http.Request, _ map[string]string) {
 	})
 }
 
+func healthz(database *durable.Database) httptreemux.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+		mctx := models.WrapContext(r.Context(), database)
+		health, err := models.Healthz(mctx)
+		views.RenderHealth(w, r, health, err)
+	}
+}
+
 // RegisterHanders handle global responses: MethodNotAllowedHandler, NotFoundHandler, PanicHandler
 func RegisterHanders(router *httptreemux.TreeMux) {
 	router.MethodNotAllowedHandler = func(w http.ResponseWriter, r *http.Request, _ map[string]httptreemux.HandlerFunc) {
@@ -86,8 +86,6 @@ func (impl *topicImpl) show(w http.ResponseWriter, r *http.Request, params map[s
 	mctx := models.WrapContext(r.Context(), impl.database)
 	if topic, err := models.ReadTopicWithRelation(mctx, params["id"], middlewares.CurrentUser(r)); err != nil {
 		views.RenderErrorResponse(w, r, err)
-	} else if topic == nil {
-		views.RenderErrorResponse(w, r, session.NotFoundError(r.Context()))
 	} else {
 		views.RenderTopic(w, r, topic)
 	}
@@ -123,8 +121,6 @@ func (impl *topicImpl) action(w http.ResponseWriter, r *http.Request, id, action
 	mctx := models.WrapContext(r.Context(), impl.database)
 	if topic, err := models.ReadTopic(mctx, id); err != nil {
 		views.RenderErrorResponse(w, r, err)
-	} else if topic == nil {
-		views.RenderErrorResponse(w, r, session.NotFoundError(r.Context()))
 	} else if topic, err = topic.ActiondBy(mctx, middlewares.CurrentUser(r), action, state); err != nil {
 		views.RenderErrorResponse(w, r, err)
 	} else {
@@ -22,6 +22,7 @@ type userRequest struct {
 	Code          string `json:"code"`
 	SessionSecret string `json:"session_secret"`
 	Nickname      string `json:"nickname"`
+	DisplayName   string `json:"display_name"`
 	Biography     string `json:"biography"`
 }
 
@@ -31,6 +32,7 @@ func registerUser(database *durable.Database, router *httptreemux.TreeMux) {
 	router.POST("/oauth/:provider", impl.oauth)
 	router.POST("/me", impl.update)
 	router.GET("/me", impl.current)
+	router.GET("/me/sessions", impl.sessions)
 	router.GET("/users/:id", impl.show)
 	router.GET("/users/:id/topics", impl.topics)
 	router.GET("/users/:id/groups", impl.groups)
@@ -59,7 +61,7 @@ func (impl *userImpl) update(w http.ResponseWriter, r *http.Request, _ map[strin
 	}
 	mctx := models.WrapContext(r.Context(), impl.database)
 	current := middlewares.CurrentUser(r)
-	if err := current.UpdateProfile(mctx, body.Nickname, body.Biography); err != nil {
+	if err := current.UpdateProfile(mctx, body.Nickname, body.DisplayName, body.Biography); err != nil {
 		views.RenderErrorResponse(w, r, err)
 	} else {
 		views.RenderAccount(w, r, current)
@@ -70,12 +72,20 @@ func (impl *userImpl) current(w http.ResponseWriter, r *http.Request, _ map[stri
 	views.RenderAccount(w, r, middlewares.CurrentUser(r))
 }
 
+func (impl *userImpl) sessions(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	mctx := models.WrapContext(r.Context(), impl.database)
+	current := middlewares.CurrentUser(r)
+	if sessions, err := current.Sessions(mctx); err != nil {
+		views.RenderErrorResponse(w, r, err)
+	} else {
+		views.RenderSessions(w, r, sessions, current)
+	}
+}
+
 func (impl *userImpl) show(w http.ResponseWriter, r *http.Request, params map[string]string) {
 	mctx := models.WrapContext(r.Context(), impl.database)
 	if user, err := models.ReadUser(mctx, params["id"]); err != nil {
 		views.RenderErrorResponse(w, r, err)
-	} else if user == nil {
-		views.RenderErrorResponse(w, r, session.NotFoundError(r.Context()))
 	} else {
 		views.RenderUser(w, r, user)
 	}
@@ -87,8 +97,6 @@ func (impl *userImpl) topics(w http.ResponseWriter, r *http.Request, params map[
 	user, err := models.ReadUser(mctx, params["id"])
 	if err != nil {
 		views.RenderErrorResponse(w, r, err)
-	} else if user == nil {
-		views.RenderErrorResponse(w, r, session.NotFoundError(r.Context()))
 	} else if topics, err := user.ReadTopics(mctx, offset); err != nil {
 		views.RenderErrorResponse(w, r, err)
 	} else {
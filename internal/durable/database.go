@@ -6,8 +6,13 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"math/rand"
+	"time"
+)
 
-	_ "github.com/lib/pq" //
+const (
+	maxSerializationRetries = 5
+	serializationRetryDelay = 10 * time.Millisecond
 )
 
 // ConnectionInfo database
@@ -49,6 +54,11 @@ func (d *Database) Close() error {
 	return d.db.Close()
 }
 
+// PingContext verifies the connection to the database is alive
+func (d *Database) PingContext(ctx context.Context) error {
+	return d.db.PingContext(ctx)
+}
+
 // Exec executes a prepared statement
 func (d *Database) Exec(query string, args ...interface{}) (sql.Result, error) {
 	stmt, err := d.db.Prepare(query)
@@ -121,6 +131,57 @@ func (d *Database) RunInTransaction(ctx context.Context, fn func(*sql.Tx) error)
 	if err != nil {
 		return err
 	}
+	return runInTransaction(tx, fn)
+}
+
+// RunInTransactionValue run fn in the transaction of d and return its value,
+// the value is the zero value of T when the transaction is rolled back. It's a
+// function rather than a method, methods can't have type parameters.
+func RunInTransactionValue[T any](ctx context.Context, d *Database, fn func(*sql.Tx) (T, error)) (T, error) {
+	var value T
+	err := d.RunInTransaction(ctx, func(tx *sql.Tx) error {
+		var err error
+		value, err = fn(tx)
+		return err
+	})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return value, nil
+}
+
+// RunInTransactionWithLevel run a query in the transaction with the isolation level,
+// e.g. sql.LevelSerializable for counters. The transaction is retried with a
+// jittered backoff when it fails with serialization_failure (40001), so fn
+// should be safe to run again, it stops retrying once ctx is done.
+func (d *Database) RunInTransactionWithLevel(ctx context.Context, level sql.IsolationLevel, fn func(*sql.Tx) error) error {
+	var err error
+	for i := 0; i < maxSerializationRetries; i++ {
+		if i > 0 {
+			delay := serializationRetryDelay << uint(i-1)
+			timer := time.NewTimer(delay/2 + time.Duration(rand.Int63n(int64(delay))))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+		var tx *sql.Tx
+		tx, err = d.db.BeginTx(ctx, &sql.TxOptions{Isolation: level})
+		if err != nil {
+			return err
+		}
+		err = runInTransaction(tx, fn)
+		if ClassifyError(err).Kind != ErrorSerializationFailure {
+			return err
+		}
+	}
+	return err
+}
+
+func runInTransaction(tx *sql.Tx, fn func(*sql.Tx) error) error {
 	defer func() {
 		if err := recover(); err != nil {
 			_ = tx.Rollback()
@@ -138,6 +199,12 @@ func (d *Database) RunInTransaction(ctx context.Context, fn func(*sql.Tx) error)
 
 // PrepareColumnsWithValues prepare columns and placeholder
 func PrepareColumnsWithValues(columns []string) (string, string) {
+	return PrepareColumnsWithValuesOffset(columns, 0)
+}
+
+// PrepareColumnsWithValuesOffset prepare columns and placeholder, the
+// placeholders start after the offset ones consumed, e.g. $4 for offset 3.
+func PrepareColumnsWithValuesOffset(columns []string, offset int) (string, string) {
 	if len(columns) < 1 {
 		return "", ""
 	}
@@ -148,7 +215,7 @@ func PrepareColumnsWithValues(columns []string) (string, string) {
 			params.WriteString(",")
 		}
 		cols.WriteString(column)
-		params.WriteString(fmt.Sprintf("$%d", i+1))
+		params.WriteString(fmt.Sprintf("$%d", offset+i+1))
 	}
 	return cols.String(), params.String()
 }
@@ -0,0 +1,42 @@
+package durable
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrepareColumnsWithValuesOffset(t *testing.T) {
+	assert := assert.New(t)
+
+	cols, params := PrepareColumnsWithValuesOffset([]string{"user_id", "username"}, 0)
+	assert.Equal("user_id,username", cols)
+	assert.Equal("$1,$2", params)
+	cols, params = PrepareColumnsWithValuesOffset([]string{"user_id", "username", "nickname"}, 3)
+	assert.Equal("user_id,username,nickname", cols)
+	assert.Equal("$4,$5,$6", params)
+	cols, params = PrepareColumnsWithValuesOffset(nil, 3)
+	assert.Equal("", cols)
+	assert.Equal("", params)
+	cols, params = PrepareColumnsWithValues([]string{"user_id"})
+	assert.Equal("user_id", cols)
+	assert.Equal("$1", params)
+}
+
+func TestClassifyError(t *testing.T) {
+	assert := assert.New(t)
+
+	class := ClassifyError(&pq.Error{Code: "23505", Constraint: "users_usernamex"})
+	assert.Equal(ErrorUniqueViolation, class.Kind)
+	assert.Equal("users_usernamex", class.Constraint)
+	class = ClassifyError(fmt.Errorf("wrapped: %w", &pq.Error{Code: "40001"}))
+	assert.Equal(ErrorSerializationFailure, class.Kind)
+	assert.Equal("", class.Constraint)
+	assert.Equal(ErrorForeignKeyViolation, ClassifyError(&pq.Error{Code: "23503"}).Kind)
+	assert.Equal(ErrorUnknown, ClassifyError(&pq.Error{Code: "42P01"}).Kind)
+	assert.Equal(ErrorUnknown, ClassifyError(errors.New("23505")).Kind)
+	assert.Equal(ErrorUnknown, ClassifyError(nil).Kind)
+}
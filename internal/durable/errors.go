@@ -0,0 +1,43 @@
+package durable
+
+import (
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// ErrorKind is the kind of a postgres error
+type ErrorKind int
+
+// Kinds of postgres errors the models care about
+const (
+	ErrorUnknown ErrorKind = iota
+	ErrorUniqueViolation
+	ErrorForeignKeyViolation
+	ErrorCheckViolation
+	ErrorSerializationFailure
+)
+
+var errorKinds = map[pq.ErrorCode]ErrorKind{
+	"23505": ErrorUniqueViolation,
+	"23503": ErrorForeignKeyViolation,
+	"23514": ErrorCheckViolation,
+	"40001": ErrorSerializationFailure,
+}
+
+// ErrorClass is the classification of an error, Constraint is the violated
+// constraint if any.
+type ErrorClass struct {
+	Kind       ErrorKind
+	Constraint string
+}
+
+// ClassifyError unwraps err to *pq.Error and classifies it, errors of other
+// drivers or unknown codes are ErrorUnknown.
+func ClassifyError(err error) ErrorClass {
+	var pqErr *pq.Error
+	if err == nil || !errors.As(err, &pqErr) {
+		return ErrorClass{Kind: ErrorUnknown}
+	}
+	return ErrorClass{Kind: errorKinds[pqErr.Code], Constraint: pqErr.Constraint}
+}
@@ -31,7 +31,7 @@ func UploadImage(ctx context.Context, name, data string) (string, error) {
 	}
 
 	fileName := name + "." + fmt
-	file := filepath.Join(configs.AppConfig.System.Attachments.Path, fileName)
+	file := filepath.Join(configs.Current().System.Attachments.Path, fileName)
 	err = os.MkdirAll(filepath.Dir(file), os.ModePerm)
 	if err != nil {
 		return "", session.ServerError(ctx, err)
@@ -52,5 +52,5 @@ func UploadImage(ctx context.Context, name, data string) (string, error) {
 		return "", session.ServerError(ctx, err)
 	}
 
-	return configs.AppConfig.HTTP.Host + "/attachments" + fileName, nil
+	return configs.Current().HTTP.Host + "/attachments" + fileName, nil
 }
@@ -13,6 +13,7 @@ import (
 
 var whitelist = [][2]string{
 	{"GET", "^/_hc$"},
+	{"GET", "^/healthz$"},
 	{"GET", "^/categories"},
 	{"GET", "^/topics"},
 	{"GET", "^/users"},
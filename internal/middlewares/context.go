@@ -1,6 +1,7 @@
 package middlewares
 
 import (
+	"net"
 	"net/http"
 	"satellity/internal/session"
 
@@ -11,6 +12,12 @@ import (
 func Context(handler http.Handler, r *render.Render) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		ctx := session.WithRender(req.Context(), r)
+		ip, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			ip = req.RemoteAddr
+		}
+		ctx = session.WithRemoteAddress(ctx, ip)
+		ctx = session.WithUserAgent(ctx, req.UserAgent())
 		handler.ServeHTTP(w, req.WithContext(ctx))
 	})
 }
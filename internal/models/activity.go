@@ -0,0 +1,123 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"satellity/internal/session"
+	"strings"
+	"time"
+)
+
+// ActivityItem types
+const (
+	ActivityTopic   = "topic"
+	ActivityComment = "comment"
+)
+
+// ActivityItem is a topic or comment in the activity feed of an user, Type
+// tells which one of Topic and Comment is set.
+type ActivityItem struct {
+	Type      string
+	Topic     *Topic
+	Comment   *Comment
+	CreatedAt time.Time
+}
+
+// ReadUserActivity read the published topics and comments of the user created
+// before offset, newest first, parameters: offset default now, limit
+// default and at most LIMIT. Anonymized users have no activity.
+func ReadUserActivity(mctx *Context, userID string, offset time.Time, limit int) ([]ActivityItem, error) {
+	ctx := mctx.context
+	if offset.IsZero() {
+		offset = mctx.now()
+	}
+	if limit <= 0 || limit > LIMIT {
+		limit = LIMIT
+	}
+
+	var items []ActivityItem
+	err := mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
+		user, err := findUserByID(ctx, tx, userID)
+		if err != nil {
+			return err
+		} else if user == nil {
+			return session.NotFoundError(ctx)
+		} else if user.isAnonymized() {
+			return nil
+		}
+		topics, err := readActivityTopics(ctx, tx, user, offset, limit)
+		if err != nil {
+			return err
+		}
+		comments, err := readActivityComments(ctx, tx, user, offset, limit)
+		if err != nil {
+			return err
+		}
+		items = mergeActivity(topics, comments, limit)
+		return nil
+	})
+	if err != nil {
+		if _, ok := err.(session.Error); ok {
+			return nil, err
+		}
+		return nil, session.TransactionError(ctx, err)
+	}
+	return items, nil
+}
+
+func readActivityTopics(ctx context.Context, tx *sql.Tx, user *User, offset time.Time, limit int) ([]*Topic, error) {
+	query := fmt.Sprintf("SELECT %s FROM topics WHERE user_id=$1 AND draft=false AND created_at<$2 ORDER BY created_at DESC LIMIT $3", strings.Join(topicColumns, ","))
+	rows, err := tx.QueryContext(ctx, query, user.UserID, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var topics []*Topic
+	for rows.Next() {
+		topic, err := topicFromRows(rows)
+		if err != nil {
+			return nil, err
+		}
+		topic.User = user
+		topics = append(topics, topic)
+	}
+	return topics, rows.Err()
+}
+
+func readActivityComments(ctx context.Context, tx *sql.Tx, user *User, offset time.Time, limit int) ([]*Comment, error) {
+	query := fmt.Sprintf("SELECT %s FROM comments WHERE user_id=$1 AND created_at<$2 ORDER BY created_at DESC LIMIT $3", strings.Join(commentColumns, ","))
+	rows, err := tx.QueryContext(ctx, query, user.UserID, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []*Comment
+	for rows.Next() {
+		comment, err := commentFromRows(rows)
+		if err != nil {
+			return nil, err
+		}
+		comment.User = user
+		comments = append(comments, comment)
+	}
+	return comments, rows.Err()
+}
+
+// mergeActivity merges the topics and comments, both newest first, into at
+// most limit items
+func mergeActivity(topics []*Topic, comments []*Comment, limit int) []ActivityItem {
+	items := make([]ActivityItem, 0, limit)
+	for len(items) < limit && (len(topics) > 0 || len(comments) > 0) {
+		if len(comments) == 0 || (len(topics) > 0 && !topics[0].CreatedAt.Before(comments[0].CreatedAt)) {
+			items = append(items, ActivityItem{Type: ActivityTopic, Topic: topics[0], CreatedAt: topics[0].CreatedAt})
+			topics = topics[1:]
+		} else {
+			items = append(items, ActivityItem{Type: ActivityComment, Comment: comments[0], CreatedAt: comments[0].CreatedAt})
+			comments = comments[1:]
+		}
+	}
+	return items
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadUserActivity(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	user := createTestUser(mctx, "im.yuqlee@gmail.com", "username", "password")
+	other := createTestUser(mctx, "other@gmail.com", "otheruser", "password")
+	category, _ := CreateCategory(mctx, "name", "alias", "Description", 0)
+	first, err := user.CreateTopic(mctx, "first", "body", category.CategoryID, false)
+	assert.Nil(err)
+	firstComment, err := user.CreateComment(mctx, first.TopicID, "first comment")
+	assert.Nil(err)
+	_, err = other.CreateComment(mctx, first.TopicID, "other comment")
+	assert.Nil(err)
+	second, err := user.CreateTopic(mctx, "second", "body", category.CategoryID, false)
+	assert.Nil(err)
+	secondComment, err := user.CreateComment(mctx, second.TopicID, "second comment")
+	assert.Nil(err)
+	_, err = user.CreateTopic(mctx, "draft", "body", category.CategoryID, true)
+	assert.Nil(err)
+
+	items, err := ReadUserActivity(mctx, user.UserID, time.Time{}, 0)
+	assert.Nil(err)
+	assert.Len(items, 4)
+	assert.Equal(ActivityComment, items[0].Type)
+	assert.Equal(secondComment.CommentID, items[0].Comment.CommentID)
+	assert.Equal(ActivityTopic, items[1].Type)
+	assert.Equal(second.TopicID, items[1].Topic.TopicID)
+	assert.Equal(ActivityComment, items[2].Type)
+	assert.Equal(firstComment.CommentID, items[2].Comment.CommentID)
+	assert.Equal(ActivityTopic, items[3].Type)
+	assert.Equal(first.TopicID, items[3].Topic.TopicID)
+
+	items, err = ReadUserActivity(mctx, user.UserID, time.Time{}, 3)
+	assert.Nil(err)
+	assert.Len(items, 3)
+	items, err = ReadUserActivity(mctx, user.UserID, items[2].CreatedAt, 0)
+	assert.Nil(err)
+	assert.Len(items, 1)
+	assert.Equal(first.TopicID, items[0].Topic.TopicID)
+
+	_, err = ReadUserActivity(mctx, uuid.Must(uuid.NewV4()).String(), time.Time{}, 0)
+	assert.NotNil(err)
+
+	admin := &User{UserID: uuid.Must(uuid.NewV4()).String(), AssignedRole: userRoleAdmin}
+	assert.Nil(AnonymizeUser(mctx, admin, user.UserID, ""))
+	items, err = ReadUserActivity(mctx, user.UserID, time.Time{}, 0)
+	assert.Nil(err)
+	assert.Len(items, 0)
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"satellity/internal/configs"
+	"satellity/internal/session"
+)
+
+// CreateAttachment stores the data of r as name under system.attachments.path,
+// it reads at most max_size bytes, so a streaming upload can't exceed the limit,
+// and nothing is written when it does.
+func CreateAttachment(mctx *Context, name string, r io.Reader) (string, error) {
+	ctx := mctx.context
+	if err := checkWritable(ctx); err != nil {
+		return "", err
+	}
+	config := configs.Current()
+	if config == nil {
+		return "", session.ServerError(ctx, nil)
+	}
+	max := config.System.Attachments.MaxSize
+	if max > 0 {
+		r = io.LimitReader(r, max+1)
+	}
+
+	file := filepath.Join(config.System.Attachments.Path, filepath.Clean("/"+name))
+	if err := os.MkdirAll(filepath.Dir(file), os.ModePerm); err != nil {
+		return "", session.ServerError(ctx, err)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(file), ".upload-")
+	if err != nil {
+		return "", session.ServerError(ctx, err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	n, err := io.Copy(tmp, r)
+	if err != nil {
+		return "", session.ServerError(ctx, err)
+	}
+	if max > 0 && n > max {
+		return "", session.PayloadTooLargeError(ctx)
+	}
+	if err := tmp.Sync(); err != nil {
+		return "", session.ServerError(ctx, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", session.ServerError(ctx, err)
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return "", session.ServerError(ctx, err)
+	}
+	return config.HTTP.Host + "/attachments" + filepath.Clean("/"+name), nil
+}
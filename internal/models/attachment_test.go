@@ -0,0 +1,41 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"satellity/internal/configs"
+	"satellity/internal/session"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateAttachment(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	dir, err := ioutil.TempDir("", "attachments")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	attachments := configs.Current().System.Attachments
+	defer func() { configs.Current().System.Attachments = attachments }()
+	configs.Current().System.Attachments.Path = dir
+	configs.Current().System.Attachments.MaxSize = 16
+
+	_, err = CreateAttachment(mctx, "large.txt", bytes.NewReader(make([]byte, 64)))
+	assert.True(errors.Is(err, session.PayloadTooLargeError(mctx.context)))
+	files, err := ioutil.ReadDir(dir)
+	assert.Nil(err)
+	assert.Len(files, 0)
+
+	url, err := CreateAttachment(mctx, "small.txt", bytes.NewReader(make([]byte, 16)))
+	assert.Nil(err)
+	assert.Contains(url, "/attachments/small.txt")
+	data, err := ioutil.ReadFile(dir + "/small.txt")
+	assert.Nil(err)
+	assert.Len(data, 16)
+}
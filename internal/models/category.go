@@ -51,6 +51,9 @@ func (c *Category) values() []interface{} {
 // CreateCategory create a new category.
 func CreateCategory(mctx *Context, name, alias, description string, position int64) (*Category, error) {
 	ctx := mctx.context
+	if err := checkWritable(ctx); err != nil {
+		return nil, err
+	}
 	alias, name = strings.TrimSpace(alias), strings.TrimSpace(name)
 	description = strings.TrimSpace(description)
 	if len(name) < 1 {
@@ -94,6 +97,9 @@ func CreateCategory(mctx *Context, name, alias, description string, position int
 // UpdateCategory update a category's attributes
 func UpdateCategory(mctx *Context, id, name, alias, description string, position int64) (*Category, error) {
 	ctx := mctx.context
+	if err := checkWritable(ctx); err != nil {
+		return nil, err
+	}
 	alias, name = strings.TrimSpace(alias), strings.TrimSpace(name)
 	description = strings.TrimSpace(description)
 	if len(alias) < 1 && len(name) < 1 {
@@ -144,6 +150,9 @@ func ReadCategory(mctx *Context, id string) (*Category, error) {
 	if err != nil {
 		return nil, session.TransactionError(ctx, err)
 	}
+	if category == nil {
+		return nil, session.NotFoundError(ctx)
+	}
 	return category, nil
 }
 
@@ -1,7 +1,9 @@
 package models
 
 import (
+	"errors"
 	"fmt"
+	"satellity/internal/session"
 	"strings"
 	"testing"
 
@@ -57,7 +59,7 @@ func TestCategoryCRUD(t *testing.T) {
 			assert.Nil(err)
 			assert.Len(categories, tc.position)
 			new, err = ReadCategory(ctx, uuid.Must(uuid.NewV4()).String())
-			assert.Nil(err)
+			assert.True(errors.Is(err, session.ErrNotFound))
 			assert.Nil(new)
 			new, err = UpdateCategory(ctx, uuid.Must(uuid.NewV4()).String(), "new"+category.Name, "new"+category.Alias, "new"+category.Description, 10)
 			assert.NotNil(err)
@@ -0,0 +1,311 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"satellity/internal/durable"
+	"satellity/internal/session"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+const commentsDDL = `
+CREATE TABLE IF NOT EXISTS comments (
+	comment_id            VARCHAR(36) PRIMARY KEY,
+	body                  TEXT NOT NULL,
+	topic_id              VARCHAR(36) NOT NULL REFERENCES topics ON DELETE CASCADE,
+	user_id               VARCHAR(36) NOT NULL REFERENCES users ON DELETE CASCADE,
+	score                 INTEGER NOT NULL DEFAULT 0,
+	created_at            TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	updated_at            TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS comments_topic_createdx ON comments (topic_id, created_at);
+CREATE INDEX IF NOT EXISTS comments_topic_created_commentx ON comments (topic_id, created_at, comment_id);
+CREATE INDEX IF NOT EXISTS comments_user_createdx ON comments (user_id, created_at);
+CREATE INDEX IF NOT EXISTS comments_score_createdx ON comments (score DESC, created_at);
+`
+
+// Orders of the comments in a topic
+const (
+	CommentOrderAsc  = "asc"
+	CommentOrderDesc = "desc"
+)
+
+// Comment is struct for comment of topic
+type Comment struct {
+	CommentID string
+	Body      string
+	TopicID   string
+	UserID    string
+	Score     int
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	User *User
+}
+
+var commentColumns = []string{"comment_id", "body", "topic_id", "user_id", "score", "created_at", "updated_at"}
+
+func (c *Comment) values() []interface{} {
+	return []interface{}{c.CommentID, c.Body, c.TopicID, c.UserID, c.Score, c.CreatedAt, c.UpdatedAt}
+}
+
+func commentFromRows(row durable.Row) (*Comment, error) {
+	var c Comment
+	err := row.Scan(&c.CommentID, &c.Body, &c.TopicID, &c.UserID, &c.Score, &c.CreatedAt, &c.UpdatedAt)
+	return &c, err
+}
+
+// CreateComment create a new comment
+func (user *User) CreateComment(mctx *Context, topicID, body string) (*Comment, error) {
+	ctx := mctx.context
+	if err := checkWritable(ctx); err != nil {
+		return nil, err
+	}
+	body = strings.TrimSpace(body)
+	if len(body) < 1 {
+		return nil, session.BadDataError(ctx)
+	}
+
+	t := time.Now()
+	comment := &Comment{
+		CommentID: uuid.Must(uuid.NewV4()).String(),
+		Body:      body,
+		UserID:    user.UserID,
+		CreatedAt: t,
+		UpdatedAt: t,
+	}
+	err := mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
+		if err := checkRateLimit(ctx, tx, user, "comments", commentsRateLimit(), commentsRateWindow, mctx.now()); err != nil {
+			return err
+		}
+		topic, err := findTopic(ctx, tx, topicID)
+		if err != nil {
+			return err
+		} else if topic == nil {
+			return session.BadDataError(ctx)
+		}
+		count, err := commentsCountByTopic(ctx, tx, topic.TopicID)
+		if err != nil {
+			return err
+		}
+		comment.TopicID = topic.TopicID
+		cols, params := durable.PrepareColumnsWithValues(commentColumns)
+		_, err = tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO comments(%s) VALUES (%s)", cols, params), comment.values()...)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE topics SET (comments_count, updated_at)=($1, $2) WHERE topic_id=$3", count+1, t, topic.TopicID)
+		return err
+	})
+	if err != nil {
+		if _, ok := err.(session.Error); ok {
+			return nil, err
+		}
+		return nil, session.TransactionError(ctx, err)
+	}
+	comment.User = user
+	return comment, nil
+}
+
+// UpdateComment update the comment by id
+func (user *User) UpdateComment(mctx *Context, id, body string) (*Comment, error) {
+	ctx := mctx.context
+	if err := checkWritable(ctx); err != nil {
+		return nil, err
+	}
+	body = strings.TrimSpace(body)
+	if len(body) < 1 {
+		return nil, session.BadDataError(ctx)
+	}
+
+	var comment *Comment
+	err := mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
+		var err error
+		comment, err = findComment(ctx, tx, id)
+		if err != nil {
+			return err
+		} else if comment == nil {
+			return session.NotFoundError(ctx)
+		} else if !CanAct(user, ActionEdit, comment.UserID) {
+			return session.ForbiddenError(ctx)
+		}
+		comment.Body = body
+		comment.UpdatedAt = time.Now()
+		_, err = tx.ExecContext(ctx, "UPDATE comments SET (body, updated_at)=($1, $2) WHERE comment_id=$3", comment.Body, comment.UpdatedAt, comment.CommentID)
+		return err
+	})
+	if err != nil {
+		if _, ok := err.(session.Error); ok {
+			return nil, err
+		}
+		return nil, session.TransactionError(ctx, err)
+	}
+	comment.User = user
+	return comment, nil
+}
+
+// ReadComments read comments of the topic, order is asc (default) or desc.
+// cursor is the NextCursor of the previous page, empty for the first page,
+// ties of created_at are ordered by comment_id in both orders.
+func (topic *Topic) ReadComments(mctx *Context, cursor, order string) (*Page[*Comment], error) {
+	ctx := mctx.context
+	offset, id, err := decodeCursor(cursor, mctx.now())
+	if err != nil {
+		return nil, session.InvalidCursorError(ctx)
+	}
+	query := "SELECT %s FROM comments WHERE topic_id=$1 AND (created_at,comment_id)>($2,$3) ORDER BY created_at, comment_id LIMIT $4"
+	switch order {
+	case "", CommentOrderAsc:
+	case CommentOrderDesc:
+		if offset.IsZero() {
+			offset, id = mctx.now(), ""
+		}
+		query = "SELECT %s FROM comments WHERE topic_id=$1 AND (created_at,comment_id)<($2,$3) ORDER BY created_at DESC, comment_id DESC LIMIT $4"
+	default:
+		return nil, session.BadDataError(ctx)
+	}
+
+	page := &Page[*Comment]{}
+	err = mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, fmt.Sprintf(query, strings.Join(commentColumns, ",")), topic.TopicID, offset, id, LIMIT+1)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		userIds := []string{}
+		for rows.Next() {
+			comment, err := commentFromRows(rows)
+			if err != nil {
+				return err
+			}
+			userIds = append(userIds, comment.UserID)
+			page.Items = append(page.Items, comment)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		userSet, err := readUserSet(ctx, tx, userIds)
+		if err != nil {
+			return err
+		}
+		for i, comment := range page.Items {
+			page.Items[i].User = userSet[comment.UserID]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, session.TransactionError(ctx, err)
+	}
+	if len(page.Items) > LIMIT {
+		page.Items, page.HasMore = page.Items[:LIMIT], true
+		last := page.Items[LIMIT-1]
+		page.NextCursor = encodeCursor(last.CreatedAt, last.CommentID)
+	}
+	return page, nil
+}
+
+// ReadComments read comments by user, parameters: offset default time.Now()
+func (user *User) ReadComments(mctx *Context, offset time.Time) ([]*Comment, error) {
+	ctx := mctx.context
+	if offset.IsZero() {
+		offset = time.Now()
+	}
+
+	var comments []*Comment
+	err := mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
+		query := fmt.Sprintf("SELECT %s FROM comments WHERE user_id=$1 AND created_at<$2 ORDER BY created_at DESC LIMIT $3", strings.Join(commentColumns, ","))
+		rows, err := tx.QueryContext(ctx, query, user.UserID, offset, LIMIT)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			comment, err := commentFromRows(rows)
+			if err != nil {
+				return err
+			}
+			comment.User = user
+			comments = append(comments, comment)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, session.TransactionError(ctx, err)
+	}
+	return comments, nil
+}
+
+// DeleteComment delete a comment by ID, comments_count of the topic is
+// recomputed by the queue of the context.
+func (user *User) DeleteComment(mctx *Context, id string) error {
+	ctx := mctx.context
+	if err := checkWritable(ctx); err != nil {
+		return err
+	}
+	var topicID string
+	err := mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
+		comment, err := findComment(ctx, tx, id)
+		if err != nil || comment == nil {
+			return err
+		}
+		if !CanAct(user, PermissionDeleteComment, comment.UserID) {
+			return session.ForbiddenError(ctx)
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM comments WHERE comment_id=$1", comment.CommentID)
+		topicID = comment.TopicID
+		return err
+	})
+	if err != nil {
+		if _, ok := err.(session.Error); ok {
+			return err
+		}
+		return session.TransactionError(ctx, err)
+	}
+	if topicID != "" {
+		mctx.Enqueue(RecomputeCommentsCount(topicID))
+	}
+	return nil
+}
+
+func findComment(ctx context.Context, tx *sql.Tx, id string) (*Comment, error) {
+	if _, err := uuid.FromString(id); err != nil {
+		return nil, nil
+	}
+	row := tx.QueryRowContext(ctx, fmt.Sprintf("SELECT %s FROM comments WHERE comment_id=$1", strings.Join(commentColumns, ",")), id)
+	c, err := commentFromRows(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return c, err
+}
+
+// RecomputeCommentsCount is the task to correct comments_count of the topic
+func RecomputeCommentsCount(topicID string) Task {
+	return Task{
+		Name: "recompute_comments_count",
+		Run: func(mctx *Context) error {
+			ctx := mctx.context
+			return mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
+				count, err := commentsCountByTopic(ctx, tx, topicID)
+				if err != nil {
+					return err
+				}
+				_, err = tx.ExecContext(ctx, "UPDATE topics SET comments_count=$1 WHERE topic_id=$2", count, topicID)
+				return err
+			})
+		},
+	}
+}
+
+func commentsCountByTopic(ctx context.Context, tx *sql.Tx, id string) (int64, error) {
+	var count int64
+	err := tx.QueryRowContext(ctx, "SELECT count(*) FROM comments WHERE topic_id=$1", id).Scan(&count)
+	return count, err
+}
@@ -63,10 +63,10 @@ func TestCommentCRUD(t *testing.T) {
 			assert.Nil(err)
 			assert.NotNil(new)
 			assert.Equal("new comment body", new.Body)
-			comments, err := topic.ReadComments(mctx, time.Time{})
+			page, err := topic.ReadComments(mctx, "", CommentOrderAsc)
 			assert.Nil(err)
-			assert.Len(comments, 1)
-			comments, err = user.ReadComments(mctx, time.Time{})
+			assert.Len(page.Items, 1)
+			comments, err := user.ReadComments(mctx, time.Time{})
 			assert.Nil(err)
 			assert.Len(comments, 1)
 			topic, _ = ReadTopic(mctx, topic.TopicID)
@@ -78,9 +78,9 @@ func TestCommentCRUD(t *testing.T) {
 			assert.Nil(err)
 			assert.NotNil(topic)
 			assert.Equal(int64(0), topic.CommentsCount)
-			comments, err = topic.ReadComments(mctx, time.Time{})
+			page, err = topic.ReadComments(mctx, "", CommentOrderAsc)
 			assert.Nil(err)
-			assert.Len(comments, 0)
+			assert.Len(page.Items, 0)
 			comments, err = user.ReadComments(mctx, time.Time{})
 			assert.Nil(err)
 			assert.Len(comments, 0)
@@ -91,6 +91,69 @@ func TestCommentCRUD(t *testing.T) {
 	}
 }
 
+func TestReadCommentsPagination(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	user := createTestUser(mctx, "im.yuqlee@gmail.com", "username", "password")
+	assert.NotNil(user)
+	category, _ := CreateCategory(mctx, "name", "alias", "Description", 0)
+	assert.NotNil(category)
+	topic, _ := user.CreateTopic(mctx, "title", "body", category.CategoryID, false)
+	assert.NotNil(topic)
+	for i := 0; i < 150; i++ {
+		comment, err := user.CreateComment(mctx, topic.TopicID, fmt.Sprintf("comment %d", i))
+		assert.Nil(err)
+		assert.NotNil(comment)
+	}
+
+	readAll := func(order string) []string {
+		seen := make(map[string]bool)
+		var bodies []string
+		cursor := ""
+		for {
+			page, err := topic.ReadComments(mctx, cursor, order)
+			assert.Nil(err)
+			for _, c := range page.Items {
+				assert.False(seen[c.CommentID])
+				seen[c.CommentID] = true
+				bodies = append(bodies, c.Body)
+			}
+			if !page.HasMore {
+				return bodies
+			}
+			cursor = page.NextCursor
+		}
+	}
+	for _, order := range []string{CommentOrderAsc, CommentOrderDesc} {
+		bodies := readAll(order)
+		assert.Len(bodies, 150)
+		if order == CommentOrderAsc {
+			assert.Equal("comment 0", bodies[0])
+			assert.Equal("comment 149", bodies[149])
+		} else {
+			assert.Equal("comment 149", bodies[0])
+			assert.Equal("comment 0", bodies[149])
+		}
+	}
+
+	// comments created at the same time are neither skipped nor repeated
+	_, err := mctx.database.Exec("UPDATE comments SET created_at=$1 WHERE topic_id=$2", time.Now().Add(-time.Hour), topic.TopicID)
+	assert.Nil(err)
+	for _, order := range []string{CommentOrderAsc, CommentOrderDesc} {
+		assert.Len(readAll(order), 150)
+	}
+
+	page, err := topic.ReadComments(mctx, "", "random")
+	assert.NotNil(err)
+	assert.Nil(page)
+	page, err = topic.ReadComments(mctx, "!garbage", CommentOrderAsc)
+	assert.NotNil(err)
+	assert.Nil(page)
+}
+
 func readTestComment(mctx *Context, id string) (*Comment, error) {
 	ctx := mctx.context
 	var comment *Comment
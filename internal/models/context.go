@@ -3,15 +3,100 @@ package models
 import (
 	"context"
 	"satellity/internal/durable"
+	"satellity/internal/session"
+	"time"
 )
 
+// Hooks are the side effects of models, e.g. send a welcome email on signup.
+// They run after the transaction commits, an error is logged but never fails
+// the operation.
+type Hooks interface {
+	OnUserCreated(u *User) error
+	// OnUserUpdated gets the fields changed by a profile update or lock,
+	// it's not called if nothing changed.
+	OnUserUpdated(u *User, changes []FieldChange) error
+}
+
+// FieldChange is a field of the user changed from Old to New
+type FieldChange struct {
+	Field string
+	Old   string
+	New   string
+}
+
+type noopHooks struct{}
+
+func (noopHooks) OnUserCreated(u *User) error { return nil }
+
+func (noopHooks) OnUserUpdated(u *User, changes []FieldChange) error { return nil }
+
+// DefaultHooks are the hooks of contexts created by WrapContext, replace it
+// on startup to customize.
+var DefaultHooks Hooks = noopHooks{}
+
+// Clock tells the current time of time dependent logic, e.g. cooldowns and
+// expirations, tests could replace it by WithClock instead of sleeping.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
 // Context application
 type Context struct {
 	context  context.Context
 	database *durable.Database
+	hooks    Hooks
+	clock    Clock
+	queue    Queue
 }
 
 // WrapContext application
 func WrapContext(ctx context.Context, db *durable.Database) *Context {
-	return &Context{context: ctx, database: db}
+	return &Context{context: ctx, database: db, hooks: DefaultHooks, clock: realClock{}, queue: DefaultQueue}
+}
+
+// WithClock returns a copy of the context using clock
+func (mctx *Context) WithClock(clock Clock) *Context {
+	c := *mctx
+	c.clock = clock
+	return &c
+}
+
+func (mctx *Context) now() time.Time {
+	if mctx.clock == nil {
+		return time.Now()
+	}
+	return mctx.clock.Now()
+}
+
+// WithHooks returns a copy of the context using hooks
+func (mctx *Context) WithHooks(hooks Hooks) *Context {
+	c := *mctx
+	c.hooks = hooks
+	return &c
+}
+
+func (mctx *Context) userCreated(u *User) {
+	if mctx.hooks == nil {
+		return
+	}
+	if err := mctx.hooks.OnUserCreated(u); err != nil {
+		if logger := session.Logger(mctx.context); logger != nil {
+			logger.Errorf("OnUserCreated %s: %v", u.UserID, err)
+		}
+	}
+}
+
+func (mctx *Context) userUpdated(u *User, changes []FieldChange) {
+	if mctx.hooks == nil || len(changes) == 0 {
+		return
+	}
+	if err := mctx.hooks.OnUserUpdated(u, changes); err != nil {
+		if logger := session.Logger(mctx.context); logger != nil {
+			logger.Errorf("OnUserUpdated %s: %v", u.UserID, err)
+		}
+	}
 }
@@ -2,9 +2,15 @@ package models
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"log"
 	"satellity/internal/configs"
 	"satellity/internal/durable"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
 )
 
 const (
@@ -24,10 +30,24 @@ const (
 	dropGroupInvitationsDDL = `DROP TABLE IF EXISTS group_invitations`
 	dropMessagesDDL         = `DROP TABLE IF EXISTS messages`
 	dropStatisticsDDL       = `DROP TABLE IF EXISTS statistics;`
+
+	dropEmailVerificationsDDL = `DROP TABLE IF EXISTS email_verifications;`
+	dropSchemaMigrationsDDL   = `DROP TABLE IF EXISTS schema_migrations;`
+	dropFailedLoginsDDL       = `DROP TABLE IF EXISTS failed_logins;`
+
+	dropUsernameReservationsDDL = `DROP TABLE IF EXISTS username_reservations;`
+	dropLinkedProvidersDDL      = `DROP TABLE IF EXISTS linked_providers;`
+	dropProfileAuditsDDL        = `DROP TABLE IF EXISTS profile_audits;`
 )
 
 func teardownTestContext(mctx *Context) {
 	tables := []string{
+		dropSchemaMigrationsDDL,
+		dropProfileAuditsDDL,
+		dropLinkedProvidersDDL,
+		dropUsernameReservationsDDL,
+		dropFailedLoginsDDL,
+		dropEmailVerificationsDDL,
 		dropStatisticsDDL,
 		dropMessagesDDL,
 		dropGroupInvitationsDDL,
@@ -52,7 +72,7 @@ func setupTestContext() *Context {
 	if err := configs.Init("./../configs", testEnvironment); err != nil {
 		log.Panicln(err)
 	}
-	config := configs.AppConfig
+	config := configs.Current()
 	if config.Environment != testEnvironment || config.Database.Name != testDatabase {
 		log.Panicln(config.Environment, config.Database.Name)
 	}
@@ -75,6 +95,11 @@ func setupTestContext() *Context {
 		groupInvitationsDDL,
 		messagesDDL,
 		statisticsDDL,
+		emailVerificationsDDL,
+		failedLoginsDDL,
+		usernameReservationsDDL,
+		linkedProvidersDDL,
+		profileAuditsDDL,
 	}
 	for _, q := range tables {
 		if _, err := db.Exec(q); err != nil {
@@ -84,3 +109,36 @@ func setupTestContext() *Context {
 	database := durable.WrapDatabase(db)
 	return WrapContext(context.Background(), database)
 }
+
+func TestRunInTransactionValue(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	ctx := mctx.context
+	v, err := durable.RunInTransactionValue(ctx, mctx.database, func(tx *sql.Tx) (int64, error) {
+		return usersCount(ctx, tx)
+	})
+	assert.Nil(err)
+	assert.Equal(int64(0), v)
+
+	v, err = durable.RunInTransactionValue(ctx, mctx.database, func(tx *sql.Tx) (int64, error) {
+		_, err := tx.ExecContext(ctx, "INSERT INTO users(user_id,username) VALUES ($1,$2)", uuid.Must(uuid.NewV4()).String(), "username")
+		if err != nil {
+			return 0, err
+		}
+		count, err := usersCount(ctx, tx)
+		if err != nil {
+			return 0, err
+		}
+		return count, errors.New("rollback")
+	})
+	assert.NotNil(err)
+	assert.Equal(int64(0), v)
+	v, err = durable.RunInTransactionValue(ctx, mctx.database, func(tx *sql.Tx) (int64, error) {
+		return usersCount(ctx, tx)
+	})
+	assert.Nil(err)
+	assert.Equal(int64(0), v)
+}
@@ -0,0 +1,53 @@
+package models
+
+import "strings"
+
+// maximumUserAgentSize is the size of sessions.user_agent, longer ones are cut
+const maximumUserAgentSize = 512
+
+var (
+	// the order matters, e.g. Edge and Chrome user agents contain "Safari"
+	browserTokens = []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"CriOS/", "Chrome"},
+		{"Safari/", "Safari"},
+	}
+	platformTokens = []struct{ token, name string }{
+		{"iPhone", "iPhone"},
+		{"iPad", "iPad"},
+		{"Android", "Android"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"Linux", "Linux"},
+	}
+)
+
+// DeviceName is a coarse label of the user agent for the devices list, e.g.
+// "Chrome on Windows", the parts unknown are omitted.
+func DeviceName(userAgent string) string {
+	var browser, platform string
+	for _, b := range browserTokens {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	for _, p := range platformTokens {
+		if strings.Contains(userAgent, p.token) {
+			platform = p.name
+			break
+		}
+	}
+	switch {
+	case browser != "" && platform != "":
+		return browser + " on " + platform
+	case browser != "":
+		return browser
+	case platform != "":
+		return platform
+	}
+	return "Unknown device"
+}
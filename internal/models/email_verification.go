@@ -0,0 +1,169 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"satellity/internal/configs"
+	"satellity/internal/session"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const emailVerificationsDDL = `
+CREATE TABLE IF NOT EXISTS email_verifications (
+	user_id               VARCHAR(36) PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+	code_hash             VARCHAR(128) NOT NULL,
+	created_at            TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+`
+
+// emailVerificationTTL is how long a verification code could be redeemed
+const emailVerificationTTL = 24 * time.Hour
+
+// defaultEmailVerificationCooldown is used when system.email_verification_cooldown is blank
+const defaultEmailVerificationCooldown = time.Minute
+
+func emailVerificationCooldown() time.Duration {
+	if configs.Current() == nil {
+		return defaultEmailVerificationCooldown
+	}
+	if d := configs.Current().Durations.EmailVerificationCooldown; d > 0 {
+		return d
+	}
+	return defaultEmailVerificationCooldown
+}
+
+// ResendEmailVerification issues a new verification code of the user and
+// invalidates the old one, it's refused with TooManyRequestsError when the
+// last code was issued within system.email_verification_cooldown. Only the
+// hash of the code is stored.
+func ResendEmailVerification(mctx *Context, u *User) (string, error) {
+	ctx := mctx.context
+	if err := checkWritable(ctx); err != nil {
+		return "", err
+	}
+	if u.EmailVerifiedAt.Valid || !u.Email.Valid {
+		return "", session.BadDataError(ctx)
+	}
+
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", session.ServerError(ctx, err)
+	}
+	code := hex.EncodeToString(b[:])
+	err := mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
+		var issuedAt time.Time
+		err := tx.QueryRowContext(ctx, "SELECT created_at FROM email_verifications WHERE user_id=$1 FOR UPDATE", u.UserID).Scan(&issuedAt)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		t := mctx.now()
+		if err == nil && t.Sub(issuedAt) < emailVerificationCooldown() {
+			return session.TooManyRequestsError(ctx)
+		}
+		query := "INSERT INTO email_verifications(user_id,code_hash,created_at) VALUES ($1,$2,$3) ON CONFLICT (user_id) DO UPDATE SET (code_hash,created_at)=(EXCLUDED.code_hash,EXCLUDED.created_at)"
+		_, err = tx.ExecContext(ctx, query, u.UserID, emailVerificationHash(code), t)
+		return err
+	})
+	if err != nil {
+		if _, ok := err.(session.Error); ok {
+			return "", err
+		}
+		return "", session.TransactionError(ctx, err)
+	}
+	return code, nil
+}
+
+// VerifyEmail redeems the code issued by ResendEmailVerification, the email of
+// the user is verified and the code is removed. A wrong code, or one issued
+// emailVerificationTTL ago, is BadDataError.
+func VerifyEmail(mctx *Context, u *User, code string) error {
+	ctx := mctx.context
+	if err := checkWritable(ctx); err != nil {
+		return err
+	}
+	if u.EmailVerifiedAt.Valid || !u.Email.Valid || code == "" {
+		return session.BadDataError(ctx)
+	}
+
+	t := mctx.now()
+	var updatedAt time.Time
+	err := mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
+		var hash string
+		var issuedAt time.Time
+		err := tx.QueryRowContext(ctx, "SELECT code_hash,created_at FROM email_verifications WHERE user_id=$1 FOR UPDATE", u.UserID).Scan(&hash, &issuedAt)
+		if err == sql.ErrNoRows {
+			return session.BadDataError(ctx)
+		} else if err != nil {
+			return err
+		}
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(emailVerificationHash(code))) != 1 || t.Sub(issuedAt) > emailVerificationTTL {
+			return session.BadDataError(ctx)
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE users SET email_verified_at=$1 WHERE user_id=$2", t, u.UserID); err != nil {
+			return err
+		}
+		if updatedAt, err = touchUpdatedAt(ctx, tx, "users", "user_id", u.UserID, t); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM email_verifications WHERE user_id=$1", u.UserID)
+		return err
+	})
+	if err != nil {
+		if _, ok := err.(session.Error); ok {
+			return err
+		}
+		return session.TransactionError(ctx, err)
+	}
+	u.EmailVerifiedAt = pq.NullTime{Time: t, Valid: true}
+	u.UpdatedAt = updatedAt
+	authenticatedSessions.invalidateUser(u.UserID)
+	return nil
+}
+
+// MarkEmailsVerified sets the emails of the users verified without codes, e.g.
+// users imported from a system where they were verified. Admin only, users
+// verified already keep their time, returns the count updated.
+func MarkEmailsVerified(mctx *Context, actor *User, userIDs []string) (int64, error) {
+	ctx := mctx.context
+	if err := checkWritable(ctx); err != nil {
+		return 0, err
+	}
+	if actor == nil || !actor.isAdmin() {
+		return 0, session.ForbiddenError(ctx)
+	}
+	if len(userIDs) == 0 {
+		return 0, nil
+	}
+
+	var count int64
+	err := mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
+		t := mctx.now()
+		result, err := tx.ExecContext(ctx, "UPDATE users SET email_verified_at=$1, updated_at=GREATEST(updated_at, $1) WHERE user_id=ANY($2) AND email IS NOT NULL AND email_verified_at IS NULL", t, pq.Array(userIDs))
+		if err != nil {
+			return err
+		}
+		count, err = result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM email_verifications WHERE user_id=ANY($1)", pq.Array(userIDs))
+		return err
+	})
+	if err != nil {
+		return 0, session.TransactionError(ctx, err)
+	}
+	for _, id := range userIDs {
+		authenticatedSessions.invalidateUser(id)
+	}
+	return count, nil
+}
+
+func emailVerificationHash(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,92 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResendEmailVerification(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	user := createTestUser(mctx, "im.yuqlee@gmail.com", "username", "password")
+	assert.NotNil(user)
+	code, err := ResendEmailVerification(mctx, user)
+	assert.Nil(err)
+	assert.Len(code, 32)
+	again, err := ResendEmailVerification(mctx, user)
+	assert.NotNil(err)
+	assert.Equal("", again)
+
+	_, err = mctx.database.Exec("UPDATE email_verifications SET created_at=$1 WHERE user_id=$2", time.Now().Add(-emailVerificationCooldown()-time.Second), user.UserID)
+	assert.Nil(err)
+	again, err = ResendEmailVerification(mctx, user)
+	assert.Nil(err)
+	assert.NotEqual(code, again)
+	var hash string
+	row, err := mctx.database.QueryRow("SELECT code_hash FROM email_verifications WHERE user_id=$1", user.UserID)
+	assert.Nil(err)
+	assert.Nil(row.Scan(&hash))
+	assert.Equal(emailVerificationHash(again), hash)
+}
+
+func TestMarkEmailsVerified(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	alice := createTestUser(mctx, "alice@example.com", "alice", "password")
+	bob := createTestUser(mctx, "bob@example.com", "bobby", "password")
+	carol := createTestUser(mctx, "carol@example.com", "carol", "password")
+	admin := &User{AssignedRole: userRoleAdmin}
+
+	_, err := MarkEmailsVerified(mctx, alice, []string{alice.UserID})
+	assert.NotNil(err)
+	count, err := MarkEmailsVerified(mctx, admin, []string{alice.UserID, bob.UserID})
+	assert.Nil(err)
+	assert.Equal(int64(2), count)
+	count, err = MarkEmailsVerified(mctx, admin, []string{alice.UserID, bob.UserID})
+	assert.Nil(err)
+	assert.Equal(int64(0), count)
+
+	for _, u := range []*User{alice, bob, carol} {
+		user, err := ReadUser(mctx, u.UserID)
+		assert.Nil(err)
+		assert.Equal(u != carol, user.EmailVerifiedAt.Valid)
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	user := createTestUser(mctx, "im.yuqlee@gmail.com", "username", "password")
+	assert.NotNil(user)
+	assert.NotNil(VerifyEmail(mctx, user, "code"))
+	code, err := ResendEmailVerification(mctx, user)
+	assert.Nil(err)
+	assert.NotNil(VerifyEmail(mctx, user, "wrong"))
+
+	clock := &fakeClock{now: time.Now().Add(emailVerificationTTL + time.Minute)}
+	assert.NotNil(VerifyEmail(mctx.WithClock(clock), user, code))
+	assert.False(user.EmailVerifiedAt.Valid)
+
+	assert.Nil(VerifyEmail(mctx, user, code))
+	assert.True(user.EmailVerifiedAt.Valid)
+	user, err = ReadUser(mctx, user.UserID)
+	assert.Nil(err)
+	assert.True(user.EmailVerifiedAt.Valid)
+	var count int
+	row, err := mctx.database.QueryRow("SELECT count(*) FROM email_verifications WHERE user_id=$1", user.UserID)
+	assert.Nil(err)
+	assert.Nil(row.Scan(&count))
+	assert.Equal(0, count)
+	assert.NotNil(VerifyEmail(mctx, user, code))
+}
@@ -65,6 +65,9 @@ func groupFromRow(row durable.Row) (*Group, error) {
 // CreateGroup create a group by an user TODO should add cover
 func (user *User) CreateGroup(mctx *Context, name, description, cover string) (*Group, error) {
 	ctx := mctx.context
+	if err := checkWritable(ctx); err != nil {
+		return nil, err
+	}
 	if !validateGroupFields(name) {
 		return nil, session.BadDataError(ctx)
 	}
@@ -99,6 +102,11 @@ func (user *User) CreateGroup(mctx *Context, name, description, cover string) (*
 		if err != nil {
 			return err
 		}
+		user.GroupsCount = int64(len(groups) + 1)
+		user.UpdatedAt, err = touchUpdatedAt(ctx, tx, "users", "user_id", user.UserID, mctx.now())
+		if err != nil {
+			return err
+		}
 		group.Role = ParticipantRoleOwner
 		_, err = createParticipant(ctx, tx, group, group.UserID, ParticipantSourcePayment)
 		return err
@@ -120,6 +128,9 @@ func (user *User) CreateGroup(mctx *Context, name, description, cover string) (*
 // UpdateGroup update the group by id
 func (user *User) UpdateGroup(mctx *Context, id, name, description, cover string) (*Group, error) {
 	ctx := mctx.context
+	if err := checkWritable(ctx); err != nil {
+		return nil, err
+	}
 	name, description = strings.TrimSpace(name), strings.TrimSpace(description)
 	if len(name) > 0 && len(name) < MaximumGroupNameSize {
 		return nil, session.BadDataError(ctx)
@@ -187,6 +198,9 @@ func ReadGroup(mctx *Context, id string, current *User) (*Group, error) {
 	if err != nil {
 		return nil, session.TransactionError(ctx, err)
 	}
+	if group == nil {
+		return nil, session.NotFoundError(ctx)
+	}
 	return group, nil
 }
 
@@ -60,6 +60,9 @@ func groupInvitationFromRows(row durable.Row) (*GroupInvitation, error) {
 // CreateGroupInvitation create a group invitation by email
 func (user *User) CreateGroupInvitation(mctx *Context, groupID, email string) (*GroupInvitation, error) {
 	ctx := mctx.context
+	if err := checkWritable(ctx); err != nil {
+		return nil, err
+	}
 
 	var invitation *GroupInvitation
 	err := mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
@@ -125,6 +128,9 @@ func (user *User) CreateGroupInvitation(mctx *Context, groupID, email string) (*
 // JoinGroupByInvitation join the group by invitation code
 func (user *User) JoinGroupByInvitation(mctx *Context, groupID, code string) (*Group, error) {
 	ctx := mctx.context
+	if err := checkWritable(ctx); err != nil {
+		return nil, err
+	}
 	var group *Group
 	err := mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
 		var err error
@@ -1,7 +1,10 @@
 package models
 
 import (
+	"errors"
 	"fmt"
+	"satellity/internal/session"
+	"sync"
 	"testing"
 	"time"
 
@@ -45,7 +48,7 @@ func TestGroupCRUD(t *testing.T) {
 			assert.NotNil(group)
 
 			new, err := ReadGroup(mctx, uuid.Must(uuid.NewV4()).String(), nil)
-			assert.Nil(err)
+			assert.True(errors.Is(err, session.ErrNotFound))
 			assert.Nil(new)
 			new, err = ReadGroup(mctx, group.GroupID, nil)
 			assert.Nil(err)
@@ -124,3 +127,121 @@ func TestGroupCRUD(t *testing.T) {
 		})
 	}
 }
+
+func TestJoinGroupConcurrently(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	user := createTestUser(mctx, "im.yuqlee@gmail.com", "username", "password")
+	assert.NotNil(user)
+	group, err := user.CreateGroup(mctx, "valid group", "valid group name", "")
+	assert.Nil(err)
+	assert.NotNil(group)
+
+	var members []*User
+	for i := 0; i < 5; i++ {
+		member := createTestUser(mctx, fmt.Sprintf("validfake%02d@gmail.com", i), fmt.Sprintf("usernamex%02d", i), "password")
+		assert.NotNil(member)
+		members = append(members, member)
+	}
+	var wg sync.WaitGroup
+	for _, member := range members {
+		wg.Add(1)
+		go func(member *User) {
+			defer wg.Done()
+			_, err := member.JoinGroup(mctx, group.GroupID, ParticipantRoleMember)
+			assert.Nil(err)
+		}(member)
+	}
+	wg.Wait()
+	group, err = ReadGroup(mctx, group.GroupID, nil)
+	assert.Nil(err)
+	assert.Equal(int64(len(members)+1), group.UsersCount)
+
+	for _, member := range members {
+		wg.Add(1)
+		go func(member *User) {
+			defer wg.Done()
+			_, err := member.ExitGroup(mctx, group.GroupID)
+			assert.Nil(err)
+		}(member)
+	}
+	wg.Wait()
+	group, err = ReadGroup(mctx, group.GroupID, nil)
+	assert.Nil(err)
+	assert.Equal(int64(1), group.UsersCount)
+}
+
+func TestReadGroupMembers(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	user := createTestUser(mctx, "im.yuqlee@gmail.com", "username", "password")
+	assert.NotNil(user)
+	group, err := user.CreateGroup(mctx, "valid group", "valid group name", "")
+	assert.Nil(err)
+	assert.NotNil(group)
+	for i := 0; i < 3; i++ {
+		member := createTestUser(mctx, fmt.Sprintf("validfake%02d@gmail.com", i), fmt.Sprintf("usernamex%02d", i), "password")
+		assert.NotNil(member)
+		_, err := member.JoinGroup(mctx, group.GroupID, ParticipantRoleMember)
+		assert.Nil(err)
+	}
+	outsider := createTestUser(mctx, "validfake@gmail.com", "outsider", "password")
+	assert.NotNil(outsider)
+
+	page, err := ReadGroupMembers(mctx, group.GroupID, "", 10)
+	assert.Nil(err)
+	assert.Len(page.Items, 4)
+	assert.False(page.HasMore)
+	assert.Equal(user.UserID, page.Items[0].UserID)
+	for i, u := range page.Items[1:] {
+		assert.Equal(fmt.Sprintf("usernamex%02d", i), u.Username)
+	}
+	page, err = ReadGroupMembers(mctx, group.GroupID, "", 2)
+	assert.Nil(err)
+	assert.Len(page.Items, 2)
+	assert.True(page.HasMore)
+	page, err = ReadGroupMembers(mctx, group.GroupID, page.NextCursor, 2)
+	assert.Nil(err)
+	assert.Len(page.Items, 2)
+	assert.False(page.HasMore)
+	assert.Equal("usernamex01", page.Items[0].Username)
+	assert.Equal("usernamex02", page.Items[1].Username)
+	page, err = ReadGroupMembers(mctx, group.GroupID, "!garbage", 2)
+	assert.NotNil(err)
+	assert.Nil(page)
+
+	// members joined at the same time are neither skipped nor repeated
+	_, err = mctx.database.Exec("UPDATE participants SET created_at=$1 WHERE group_id=$2", time.Now().Add(-time.Hour), group.GroupID)
+	assert.Nil(err)
+	seen := make(map[string]bool)
+	cursor := ""
+	for {
+		page, err = ReadGroupMembers(mctx, group.GroupID, cursor, 1)
+		assert.Nil(err)
+		for _, u := range page.Items {
+			assert.False(seen[u.UserID])
+			seen[u.UserID] = true
+		}
+		if !page.HasMore {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	assert.Len(seen, 4)
+
+	// anonymized users are left out
+	_, err = mctx.database.Exec("UPDATE users SET nickname=$1, email=NULL, encrypted_password=NULL, github_id=NULL WHERE username=$2", AnonymizedNickname, "usernamex01")
+	assert.Nil(err)
+	page, err = ReadGroupMembers(mctx, group.GroupID, "", 10)
+	assert.Nil(err)
+	assert.Len(page.Items, 3)
+	for _, u := range page.Items {
+		assert.NotEqual("usernamex01", u.Username)
+	}
+}
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"satellity/internal/configs"
+	"satellity/internal/session"
+)
+
+// Health is the readiness of the application, one boolean per check
+type Health struct {
+	Database  bool   `json:"database"`
+	Config    bool   `json:"config"`
+	Build     string `json:"build"`
+	GoVersion string `json:"go_version"`
+}
+
+// Healthz checks the database connection and the config, for readiness
+// probes. The health is returned with a ServerError if any check fails.
+func Healthz(mctx *Context) (*Health, error) {
+	ctx := mctx.context
+	health := &Health{
+		Config:    configs.Current() != nil,
+		Build:     configs.BuildVersion,
+		GoVersion: runtime.Version(),
+	}
+	err := mctx.database.PingContext(ctx)
+	health.Database = err == nil
+	if err != nil {
+		return health, session.ServerError(ctx, err)
+	}
+	if !health.Config {
+		return health, session.ServerError(ctx, fmt.Errorf("config not loaded"))
+	}
+	return health, nil
+}
+
+// runtimeTables are the tables ValidateRuntime requires
+var runtimeTables = []string{"users", "sessions"}
+
+// ValidateRuntime checks the loaded config against the live environment, for
+// startup: the database is reachable, the tables of schema.sql exist and the
+// local attachments path is writable. The ServerError wraps the cause, which
+// names the failed check.
+func ValidateRuntime(mctx *Context) error {
+	ctx := mctx.context
+	config := configs.Current()
+	if config == nil {
+		return session.ServerError(ctx, fmt.Errorf("config not loaded"))
+	}
+	if err := mctx.database.PingContext(ctx); err != nil {
+		return session.ServerError(ctx, fmt.Errorf("database %s unreachable: %v", config.Database.Name, err))
+	}
+	for _, table := range runtimeTables {
+		var exists bool
+		row, err := mctx.database.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_schema=current_schema() AND table_name=$1)", table)
+		if err != nil {
+			return session.ServerError(ctx, err)
+		}
+		if err := row.Scan(&exists); err != nil {
+			return session.ServerError(ctx, err)
+		}
+		if !exists {
+			return session.ServerError(ctx, fmt.Errorf("table %s is missing, apply schema.sql", table))
+		}
+	}
+	if attachments := config.System.Attachments; attachments.Storage == "local" {
+		file, err := ioutil.TempFile(attachments.Path, ".runtime-")
+		if err != nil {
+			return session.ServerError(ctx, fmt.Errorf("attachments path %s not writable: %v", attachments.Path, err))
+		}
+		file.Close()
+		os.Remove(file.Name())
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"satellity/internal/configs"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthz(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	teardownTestContext(mctx)
+
+	health, err := Healthz(mctx)
+	assert.Nil(err)
+	assert.True(health.Database)
+	assert.True(health.Config)
+	assert.Equal(configs.BuildVersion, health.Build)
+	assert.Equal(runtime.Version(), health.GoVersion)
+
+	mctx.database.Close()
+	health, err = Healthz(mctx)
+	assert.NotNil(err)
+	assert.False(health.Database)
+	assert.True(health.Config)
+}
+
+func TestValidateRuntime(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	attachments := configs.Current().System.Attachments
+	defer func() { configs.Current().System.Attachments = attachments }()
+	dir, err := ioutil.TempDir("", "attachments")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	configs.Current().System.Attachments.Storage = "local"
+	configs.Current().System.Attachments.Path = dir
+	assert.Nil(ValidateRuntime(mctx))
+
+	configs.Current().System.Attachments.Path = filepath.Join(dir, "missing")
+	err = ValidateRuntime(mctx)
+	assert.NotNil(err)
+	assert.Contains(errors.Unwrap(err).Error(), "attachments path")
+	configs.Current().System.Attachments.Path = dir
+
+	_, err = mctx.database.Exec(dropSessionsDDL)
+	assert.Nil(err)
+	err = ValidateRuntime(mctx)
+	assert.NotNil(err)
+	assert.Contains(errors.Unwrap(err).Error(), "table sessions is missing")
+}
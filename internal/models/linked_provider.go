@@ -0,0 +1,108 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"satellity/internal/session"
+	"sort"
+	"strings"
+	"time"
+)
+
+const linkedProvidersDDL = `
+CREATE TABLE IF NOT EXISTS linked_providers (
+	provider              VARCHAR(32) NOT NULL,
+	external_id           VARCHAR(1024) NOT NULL,
+	user_id               VARCHAR(36) NOT NULL REFERENCES users ON DELETE CASCADE,
+	handle                VARCHAR(255) NOT NULL DEFAULT '',
+	created_at            TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (provider, external_id)
+);
+
+CREATE INDEX IF NOT EXISTS linked_providers_userx ON linked_providers (user_id);
+`
+
+// ProviderGithub is the provider name of github oauth
+const ProviderGithub = "github"
+
+// LinkedProvider is an oauth account linked to the user, only the provider
+// name and the external handle are kept, never the tokens.
+type LinkedProvider struct {
+	Provider  string
+	Handle    string
+	CreatedAt time.Time
+}
+
+// LinkProvider links the external account of provider to the user, relinking
+// updates the handle. An account linked to another user is BadDataError.
+func (u *User) LinkProvider(mctx *Context, provider, externalID, handle string) error {
+	ctx := mctx.context
+	if err := checkWritable(ctx); err != nil {
+		return err
+	}
+	provider, externalID = strings.ToLower(strings.TrimSpace(provider)), strings.TrimSpace(externalID)
+	if provider == "" || externalID == "" || len(provider) > 32 || len(externalID) > 1024 || len(handle) > 255 {
+		return session.BadDataError(ctx)
+	}
+	err := mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
+		return linkProvider(ctx, tx, u, provider, externalID, handle, mctx.now())
+	})
+	if err != nil {
+		if _, ok := err.(session.Error); ok {
+			return err
+		}
+		return session.TransactionError(ctx, err)
+	}
+	return nil
+}
+
+func linkProvider(ctx context.Context, tx *sql.Tx, u *User, provider, externalID, handle string, now time.Time) error {
+	query := "INSERT INTO linked_providers(provider,external_id,user_id,handle,created_at) VALUES ($1,$2,$3,$4,$5) ON CONFLICT (provider,external_id) DO UPDATE SET handle=EXCLUDED.handle WHERE linked_providers.user_id=EXCLUDED.user_id"
+	result, err := tx.ExecContext(ctx, query, provider, externalID, u.UserID, handle, now)
+	if err != nil {
+		return err
+	}
+	if count, err := result.RowsAffected(); err != nil {
+		return err
+	} else if count == 0 {
+		return session.BadDataError(ctx)
+	}
+	return nil
+}
+
+// LinkedProviders returns the oauth accounts linked to the user, ordered by
+// provider. A github account linked before the handles were kept has a blank
+// handle.
+func (u *User) LinkedProviders(mctx *Context) ([]LinkedProvider, error) {
+	ctx := mctx.context
+	rows, err := mctx.database.QueryContext(ctx, "SELECT provider,handle,created_at FROM linked_providers WHERE user_id=$1 ORDER BY provider,created_at", u.UserID)
+	if err != nil {
+		return nil, session.TransactionError(ctx, err)
+	}
+	defer rows.Close()
+
+	providers := []LinkedProvider{}
+	github := false
+	for rows.Next() {
+		var p LinkedProvider
+		if err := rows.Scan(&p.Provider, &p.Handle, &p.CreatedAt); err != nil {
+			return nil, session.TransactionError(ctx, err)
+		}
+		github = github || p.Provider == ProviderGithub
+		providers = append(providers, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, session.TransactionError(ctx, err)
+	}
+	if u.GithubID.Valid && !github {
+		providers = append(providers, LinkedProvider{Provider: ProviderGithub, CreatedAt: u.CreatedAt})
+		sortLinkedProviders(providers)
+	}
+	return providers, nil
+}
+
+func sortLinkedProviders(providers []LinkedProvider) {
+	sort.SliceStable(providers, func(i, j int) bool {
+		return providers[i].Provider < providers[j].Provider
+	})
+}
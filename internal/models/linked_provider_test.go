@@ -0,0 +1,52 @@
+package models
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLinkedProviders(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	public, _ := x509.MarshalPKIXPublicKey(priv.Public())
+	user := createTestUser(mctx, "im.yuqlee@gmail.com", "username", "password")
+	assert.NotNil(user)
+	_, err := mctx.database.Exec("UPDATE users SET email_verified_at=$1 WHERE user_id=$2", time.Now(), user.UserID)
+	assert.Nil(err)
+	other := createTestUser(mctx, "validfake@gmail.com", "usernamex", "password")
+	assert.NotNil(other)
+
+	providers, err := user.LinkedProviders(mctx)
+	assert.Nil(err)
+	assert.Len(providers, 0)
+
+	github, err := resolveGithubUser(mctx, &GithubUser{Login: "octocat", NodeID: "MDQ6VXNlcjE=", Email: "im.yuqlee@gmail.com"})
+	assert.Nil(err)
+	user, err = saveGithubUser(mctx, github, hex.EncodeToString(public))
+	assert.Nil(err)
+	assert.Nil(user.LinkProvider(mctx, "Gitea", "1024", "octo"))
+	assert.NotNil(other.LinkProvider(mctx, "gitea", "1024", "octo"))
+	assert.NotNil(user.LinkProvider(mctx, "", "1024", "octo"))
+
+	providers, err = user.LinkedProviders(mctx)
+	assert.Nil(err)
+	assert.Len(providers, 2)
+	assert.Equal("gitea", providers[0].Provider)
+	assert.Equal("octo", providers[0].Handle)
+	assert.Equal(ProviderGithub, providers[1].Provider)
+	assert.Equal("octocat", providers[1].Handle)
+	providers, err = other.LinkedProviders(mctx)
+	assert.Nil(err)
+	assert.Len(providers, 0)
+}
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"satellity/internal/session"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const failedLoginsDDL = `
+CREATE TABLE IF NOT EXISTS failed_logins (
+	user_id               VARCHAR(36) NOT NULL REFERENCES users ON DELETE CASCADE,
+	created_at            TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS failed_logins_user_createdx ON failed_logins (user_id, created_at);
+`
+
+// FailedLoginCount counts the failed logins of the user since, the failures are
+// reset by a successful login.
+func (u *User) FailedLoginCount(mctx *Context, since time.Time) (int64, error) {
+	ctx := mctx.context
+	var count int64
+	row, err := mctx.database.QueryRowContext(ctx, "SELECT count(*) FROM failed_logins WHERE user_id=$1 AND created_at>$2", u.UserID, since)
+	if err != nil {
+		return 0, session.TransactionError(ctx, err)
+	}
+	if err := row.Scan(&count); err != nil {
+		return 0, session.TransactionError(ctx, err)
+	}
+	return count, nil
+}
+
+// comparePassword compares the password with the hash of the user, throttled
+// by system.rate_limits.failed_logins_per_hour, a mismatch is recorded as a
+// failed login. The user must have a password.
+func comparePassword(mctx *Context, u *User, password string) error {
+	ctx := mctx.context
+	if max := failedLoginsRateLimit(); max > 0 {
+		count, err := u.FailedLoginCount(mctx, mctx.now().Add(-failedLoginsRateWindow))
+		if err != nil {
+			return err
+		}
+		if count >= int64(max) {
+			return session.TooManyRequestsError(ctx)
+		}
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(u.EncryptedPassword.String), []byte(password)); err != nil {
+		if err := recordFailedLogin(mctx, u); err != nil {
+			return session.TransactionError(ctx, err)
+		}
+		return session.InvalidPasswordError(ctx)
+	}
+	return nil
+}
+
+func recordFailedLogin(mctx *Context, u *User) error {
+	ctx := mctx.context
+	_, err := mctx.database.ExecContext(ctx, "INSERT INTO failed_logins(user_id,created_at) VALUES ($1,$2)", u.UserID, mctx.now())
+	return err
+}
+
+func resetFailedLogins(ctx context.Context, tx *sql.Tx, u *User) error {
+	_, err := tx.ExecContext(ctx, "DELETE FROM failed_logins WHERE user_id=$1", u.UserID)
+	return err
+}
@@ -0,0 +1,16 @@
+package models
+
+import (
+	"context"
+	"satellity/internal/configs"
+	"satellity/internal/session"
+)
+
+// checkWritable returns ReadOnlyModeError when maintenance.read_only is set,
+// write functions call it first, reads keep working during maintenance.
+func checkWritable(ctx context.Context) error {
+	if config := configs.Current(); config != nil && config.Maintenance.ReadOnly {
+		return session.ReadOnlyModeError(ctx)
+	}
+	return nil
+}
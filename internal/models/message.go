@@ -55,6 +55,9 @@ func messageFromRow(row durable.Row) (*Message, error) {
 // CreateMessage create a message
 func (u *User) CreateMessage(mctx *Context, groupID, body, parentID string) (*Message, error) {
 	ctx := mctx.context
+	if err := checkWritable(ctx); err != nil {
+		return nil, err
+	}
 	body = strings.TrimSpace(body)
 	if len(body) < 1 {
 		return nil, session.BadDataError(ctx)
@@ -115,6 +118,9 @@ func ReadMessage(mctx *Context, id string) (*Message, error) {
 	if err != nil {
 		return nil, session.TransactionError(ctx, err)
 	}
+	if message == nil {
+		return nil, session.NotFoundError(ctx)
+	}
 	return message, nil
 }
 
@@ -204,6 +210,9 @@ func (g *Group) ReadMessages(mctx *Context, offset time.Time) ([]*Message, error
 // UpdateMessage update a message by id
 func (u *User) UpdateMessage(mctx *Context, id, body string) (*Message, error) {
 	ctx := mctx.context
+	if err := checkWritable(ctx); err != nil {
+		return nil, err
+	}
 	var message *Message
 	err := mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
 		var err error
@@ -238,6 +247,9 @@ func (u *User) UpdateMessage(mctx *Context, id, body string) (*Message, error) {
 // DeleteMessage delete a message by id
 func (u *User) DeleteMessage(mctx *Context, id string) error {
 	ctx := mctx.context
+	if err := checkWritable(ctx); err != nil {
+		return err
+	}
 	err := mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
 		message, err := findMessageByID(ctx, tx, id)
 		if err != nil {
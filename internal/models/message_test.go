@@ -1,7 +1,9 @@
 package models
 
 import (
+	"errors"
 	"fmt"
+	"satellity/internal/session"
 	"testing"
 	"time"
 
@@ -51,7 +53,7 @@ func TestMessageCRUD(t *testing.T) {
 			assert.NotNil(new)
 
 			new, err = ReadMessage(mctx, uuid.Must(uuid.NewV4()).String())
-			assert.Nil(err)
+			assert.True(errors.Is(err, session.ErrNotFound))
 			assert.Nil(new)
 
 			messages, err := group.ReadMessages(mctx, time.Now())
@@ -81,7 +83,7 @@ func TestMessageCRUD(t *testing.T) {
 			assert.Nil(err)
 
 			new, err = ReadMessage(mctx, message.MessageID)
-			assert.Nil(err)
+			assert.True(errors.Is(err, session.ErrNotFound))
 			assert.Nil(new)
 		})
 	}
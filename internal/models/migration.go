@@ -0,0 +1,160 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+const schemaMigrationsDDL = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version               INTEGER PRIMARY KEY,
+	name                  VARCHAR(512) NOT NULL,
+	applied_at            TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+`
+
+// Migration is a change of the schema, applied once in version order
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// migrations bring databases created by an older schema.sql up to date, append
+// only, never change an applied one.
+var migrations = []Migration{
+	{1, "add_users_email_verified_at", "ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP WITH TIME ZONE;"},
+	{2, "create_email_verifications", emailVerificationsDDL},
+	{3, "add_users_role", "ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(32) NOT NULL DEFAULT 'member';"},
+	{4, "add_sessions_secret_hash", `
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS secret_hash VARCHAR(64) NOT NULL DEFAULT '';
+UPDATE sessions SET secret_hash=encode(sha256(secret::bytea), 'hex') WHERE secret_hash='';
+CREATE INDEX IF NOT EXISTS sessions_secret_hashx ON sessions (secret_hash);`},
+	{5, "create_failed_logins", failedLoginsDDL},
+	{6, "add_users_username_patternx", "CREATE INDEX IF NOT EXISTS users_username_patternx ON users ((LOWER(username)) text_pattern_ops);"},
+	{7, "add_sessions_ip", `
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS ip VARCHAR(64) NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS sessions_ipx ON sessions (ip);`},
+	{8, "create_username_reservations", usernameReservationsDDL},
+	{9, "add_sessions_last_seen_at", `
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW();
+UPDATE sessions SET last_seen_at=created_at;
+CREATE INDEX IF NOT EXISTS sessions_last_seenx ON sessions (last_seen_at);`},
+	{10, "add_users_display_name", "ALTER TABLE users ADD COLUMN IF NOT EXISTS display_name VARCHAR(128);"},
+	{11, "add_users_profile_locked", "ALTER TABLE users ADD COLUMN IF NOT EXISTS profile_locked BOOLEAN NOT NULL DEFAULT false;"},
+	{12, "add_sessions_device", `
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS user_agent VARCHAR(512);
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS device_name VARCHAR(128);`},
+	{13, "add_users_profile_updated_at", "ALTER TABLE users ADD COLUMN IF NOT EXISTS profile_updated_at TIMESTAMP WITH TIME ZONE;"},
+	{14, "create_linked_providers", linkedProvidersDDL},
+	{15, "add_users_updatedx", "CREATE INDEX IF NOT EXISTS users_updatedx ON users (updated_at);"},
+	{16, "add_sessions_expires_at", "ALTER TABLE sessions ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP WITH TIME ZONE;"},
+	{17, "add_users_username_skeletonx", "CREATE INDEX IF NOT EXISTS users_username_skeletonx ON users ((replace(replace(translate(LOWER(username), '01i', 'oll'), 'rn', 'm'), 'vv', 'w')));"},
+	{18, "add_users_username_exactx", "CREATE UNIQUE INDEX IF NOT EXISTS users_username_exactx ON users (username);"},
+	{19, "create_profile_audits", profileAuditsDDL},
+	{20, "backfill_users_email_verified_at", "UPDATE users SET email_verified_at=created_at WHERE email_verified_at IS NULL AND email IS NOT NULL;"},
+	{21, "add_users_created_userx", "CREATE INDEX IF NOT EXISTS users_created_userx ON users (created_at, user_id);"},
+	{22, "add_username_reservations_username_exactx", "CREATE UNIQUE INDEX IF NOT EXISTS username_reservations_username_exactx ON username_reservations (username);"},
+	{23, "add_comments_topic_created_commentx", "CREATE INDEX IF NOT EXISTS comments_topic_created_commentx ON comments (topic_id, created_at, comment_id);"},
+	{24, "add_participant_group_created_userx", "CREATE INDEX IF NOT EXISTS participant_group_created_userx ON participants (group_id,created_at,user_id);"},
+	{25, "add_users_username_c_userx", `CREATE INDEX IF NOT EXISTS users_username_c_userx ON users ((LOWER(username) COLLATE "C"), user_id);`},
+	{26, "add_users_updated_userx", "CREATE INDEX IF NOT EXISTS users_updated_userx ON users (updated_at, user_id);"},
+	{27, "drop_users_updatedx", "DROP INDEX IF EXISTS users_updatedx;"},
+}
+
+// Migrate applies the pending migrations and returns them, with dryRun the
+// pending migrations are returned without being applied, and nothing is written,
+// even schema_migrations isn't created. Only a dry run is allowed in
+// maintenance.read_only.
+func Migrate(mctx *Context, dryRun bool) ([]Migration, error) {
+	ctx := mctx.context
+	if !dryRun {
+		if err := checkWritable(ctx); err != nil {
+			return nil, err
+		}
+	}
+	var exist bool
+	row, err := mctx.database.QueryRowContext(ctx, "SELECT to_regclass('schema_migrations') IS NOT NULL")
+	if err != nil {
+		return nil, err
+	}
+	if err := row.Scan(&exist); err != nil {
+		return nil, err
+	}
+	if !exist && !dryRun {
+		if _, err := mctx.database.ExecContext(ctx, schemaMigrationsDDL); err != nil {
+			return nil, err
+		}
+		exist = true
+	}
+
+	applied := make(map[int]bool)
+	if exist {
+		rows, err := mctx.database.QueryContext(ctx, "SELECT version FROM schema_migrations")
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var version int
+			if err := rows.Scan(&version); err != nil {
+				return nil, err
+			}
+			applied[version] = true
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	var pending []Migration
+	for _, m := range migrations {
+		if applied[m.Version] {
+			continue
+		}
+		pending = append(pending, m)
+		if dryRun {
+			continue
+		}
+		err := mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, m.SQL); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations(version,name,applied_at) VALUES ($1,$2,$3)", m.Version, m.Name, time.Now())
+			return err
+		})
+		if err != nil {
+			return pending[:len(pending)-1], err
+		}
+	}
+	if dryRun {
+		return pending, nil
+	}
+	return pending, ApplyUsernameCaseMode(mctx)
+}
+
+// ApplyUsernameCaseMode makes users_usernamex and username_reservations_usernamex
+// follow username.case_insensitive, they're dropped in the case sensitive mode
+// and the exact indexes keep the usernames unique. Switching back fails if
+// "Bob" and "bob" both exist, resolve them by FindCaseConflictingUsernames first.
+func ApplyUsernameCaseMode(mctx *Context) error {
+	ctx := mctx.context
+	if err := checkWritable(ctx); err != nil {
+		return err
+	}
+	queries := []string{"DROP INDEX IF EXISTS users_usernamex", "DROP INDEX IF EXISTS username_reservations_usernamex"}
+	if usernameCaseInsensitive() {
+		queries = []string{
+			"CREATE UNIQUE INDEX IF NOT EXISTS users_usernamex ON users ((LOWER(username)))",
+			"CREATE UNIQUE INDEX IF NOT EXISTS username_reservations_usernamex ON username_reservations ((LOWER(username)))",
+		}
+	}
+	return mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
+		for _, query := range queries {
+			if _, err := tx.ExecContext(ctx, query); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateDryRun(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	pending, err := Migrate(mctx, true)
+	assert.Nil(err)
+	assert.Len(pending, len(migrations))
+	assert.Equal(migrations[0].SQL, pending[0].SQL)
+	var exist bool
+	row, err := mctx.database.QueryRow("SELECT to_regclass('schema_migrations') IS NOT NULL")
+	assert.Nil(err)
+	assert.Nil(row.Scan(&exist))
+	assert.False(exist)
+
+	applied, err := Migrate(mctx, false)
+	assert.Nil(err)
+	assert.Len(applied, len(migrations))
+	pending, err = Migrate(mctx, true)
+	assert.Nil(err)
+	assert.Len(pending, 0)
+
+	_, err = mctx.database.Exec("DELETE FROM schema_migrations WHERE version=$1", migrations[len(migrations)-1].Version)
+	assert.Nil(err)
+	pending, err = Migrate(mctx, true)
+	assert.Nil(err)
+	assert.Len(pending, 1)
+	var count int
+	row, err = mctx.database.QueryRow("SELECT count(*) FROM schema_migrations")
+	assert.Nil(err)
+	assert.Nil(row.Scan(&count))
+	assert.Equal(len(migrations)-1, count)
+}
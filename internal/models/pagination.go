@@ -0,0 +1,94 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Page is a page of items, NextCursor is empty when there is no more
+type Page[T any] struct {
+	Items      []T
+	NextCursor string
+	HasMore    bool
+}
+
+// safeOrderBy maps the client sort token to an ORDER BY clause, allowed maps
+// the token to the column, a leading "-" means descending, e.g. "-created_at"
+// to "created_at DESC". Unknown tokens are rejected, so the result is safe to
+// be put into the query.
+func safeOrderBy(field string, allowed map[string]string) (string, error) {
+	direction := "ASC"
+	if strings.HasPrefix(field, "-") {
+		field, direction = field[1:], "DESC"
+	}
+	column, ok := allowed[field]
+	if !ok {
+		return "", fmt.Errorf("invalid order field %q", field)
+	}
+	return column + " " + direction, nil
+}
+
+// encodeCursor encodes the created_at and the id of the last item as an
+// opaque cursor, the id breaks ties of items created at the same time.
+func encodeCursor(t time.Time, id string) string {
+	return encodeKeyCursor(t.UTC().Format(time.RFC3339Nano), id)
+}
+
+// encodeKeyCursor encodes the sort key and the id of the last item as an
+// opaque cursor, for pages not ordered by time.
+func encodeKeyCursor(key, id string) string {
+	data, _ := json.Marshal([]string{key, id})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// cursorEpoch is the earliest time a cursor could carry, before any row
+var cursorEpoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// cursorClockSkew is how far in the future a cursor time is tolerated
+const cursorClockSkew = time.Minute
+
+// decodeKeyCursor decodes the cursor of encodeKeyCursor, an empty cursor is
+// two empty strings, which sort before any key. Only the exact encoding of
+// encodeKeyCursor is accepted.
+func decodeKeyCursor(cursor string) (string, string, error) {
+	if cursor == "" {
+		return "", "", nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", "", err
+	}
+	var values []string
+	if err := json.Unmarshal(data, &values); err != nil {
+		return "", "", err
+	}
+	if len(values) != 2 || encodeKeyCursor(values[0], values[1]) != cursor {
+		return "", "", fmt.Errorf("invalid cursor %q", cursor)
+	}
+	return values[0], values[1], nil
+}
+
+// decodeCursor decodes the cursor strictly, an empty cursor is the zero time
+// and an empty id. Only the exact encoding of encodeCursor is accepted, and
+// the time must be between cursorEpoch and now, a cursor is the time of an
+// existing row.
+func decodeCursor(cursor string, now time.Time) (time.Time, string, error) {
+	key, id, err := decodeKeyCursor(cursor)
+	if err != nil || cursor == "" {
+		return time.Time{}, "", err
+	}
+	t, err := time.Parse(time.RFC3339Nano, key)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	if id == "" || encodeCursor(t, id) != cursor {
+		return time.Time{}, "", fmt.Errorf("invalid cursor %q", cursor)
+	}
+	if t.Before(cursorEpoch) || t.After(now.Add(cursorClockSkew)) {
+		return time.Time{}, "", fmt.Errorf("implausible cursor time %s", t)
+	}
+	return t, id, nil
+}
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"encoding/base64"
+	"errors"
+	"satellity/internal/session"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeCursor(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+	offset, id, err := decodeCursor(encodeCursor(now.Add(-time.Hour), "id"), now)
+	assert.Nil(err)
+	assert.True(offset.Equal(now.Add(-time.Hour)))
+	assert.Equal("id", id)
+	offset, id, err = decodeCursor("", now)
+	assert.Nil(err)
+	assert.True(offset.IsZero())
+	assert.Equal("", id)
+
+	for _, cursor := range []string{
+		"!garbage",
+		base64.RawURLEncoding.EncodeToString([]byte("yesterday")),
+		base64.RawURLEncoding.EncodeToString([]byte(now.Add(-time.Hour).UTC().Format(time.RFC3339Nano))),
+		encodeKeyCursor(now.Add(-time.Hour).Format(time.RFC3339Nano+"x"), "id"),
+		encodeCursor(now.Add(-time.Hour), ""),
+		encodeCursor(now.Add(time.Hour), "id"),
+		encodeCursor(time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC), "id"),
+	} {
+		_, _, err = decodeCursor(cursor, now)
+		assert.NotNil(err, cursor)
+	}
+
+	key, id, err := decodeKeyCursor(encodeKeyCursor("username", "id"))
+	assert.Nil(err)
+	assert.Equal("username", key)
+	assert.Equal("id", id)
+	_, _, err = decodeKeyCursor(base64.RawURLEncoding.EncodeToString([]byte(`[ "username", "id" ]`)))
+	assert.NotNil(err)
+}
+
+func TestInvalidCursorError(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	for _, cursor := range []string{"!garbage", encodeCursor(time.Now().AddDate(1, 0, 0), "id")} {
+		page, err := ReadUsersPage(mctx, cursor, "", 2)
+		assert.Nil(page)
+		assert.True(errors.Is(err, session.InvalidCursorError(mctx.context)))
+	}
+	_, err := SearchUsers(mctx, "username", "!garbage", 2)
+	assert.True(errors.Is(err, session.InvalidCursorError(mctx.context)))
+}
@@ -26,6 +26,7 @@ CREATE TABLE IF NOT EXISTS participants (
 CREATE INDEX IF NOT EXISTS participant_createdx ON participants (created_at);
 CREATE INDEX IF NOT EXISTS participant_user_createdx ON participants (user_id,created_at);
 CREATE INDEX IF NOT EXISTS participant_group_createdx ON participants (group_id,created_at);
+CREATE INDEX IF NOT EXISTS participant_group_created_userx ON participants (group_id,created_at,user_id);
 `
 
 // Roles of the participant
@@ -97,6 +98,9 @@ func findParticipant(ctx context.Context, tx *sql.Tx, groupID, userID string) (*
 // JoinGroup join the group by id
 func (user *User) JoinGroup(mctx *Context, groupID, role string) (*Group, error) {
 	ctx := mctx.context
+	if err := checkWritable(ctx); err != nil {
+		return nil, err
+	}
 	switch role {
 	case ParticipantRoleAdmin,
 		ParticipantRoleVIP,
@@ -111,6 +115,9 @@ func (user *User) JoinGroup(mctx *Context, groupID, role string) (*Group, error)
 		if err != nil || group == nil {
 			return err
 		}
+		if err := lockGroup(ctx, tx, groupID); err != nil {
+			return err
+		}
 		p, err := findParticipant(ctx, tx, groupID, user.UserID)
 		if err != nil {
 			return err
@@ -123,13 +130,7 @@ func (user *User) JoinGroup(mctx *Context, groupID, role string) (*Group, error)
 		}
 		group.User = owner
 
-		var count int64
-		err = tx.QueryRowContext(ctx, "SELECT count(*) FROM participants WHERE group_id=$1", groupID).Scan(&count)
-		if err != nil {
-			return err
-		}
-		group.UsersCount = count + 1
-		_, err = tx.ExecContext(ctx, "UPDATE groups SET users_count=$1 WHERE group_id=$2", group.UsersCount, group.GroupID)
+		err = tx.QueryRowContext(ctx, "UPDATE groups SET users_count=users_count+1 WHERE group_id=$1 RETURNING users_count", group.GroupID).Scan(&group.UsersCount)
 		if err != nil {
 			return err
 		}
@@ -143,9 +144,19 @@ func (user *User) JoinGroup(mctx *Context, groupID, role string) (*Group, error)
 	return group, nil
 }
 
+// lockGroup locks the group row, so concurrent joins and exits of the group
+// update users_count one by one.
+func lockGroup(ctx context.Context, tx *sql.Tx, groupID string) error {
+	_, err := tx.ExecContext(ctx, "SELECT 1 FROM groups WHERE group_id=$1 FOR UPDATE", groupID)
+	return err
+}
+
 // ExitGroup exit the group by id
 func (user *User) ExitGroup(mctx *Context, groupID string) (*Group, error) {
 	ctx := mctx.context
+	if err := checkWritable(ctx); err != nil {
+		return nil, err
+	}
 	var group *Group
 	err := mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
 		var err error
@@ -155,6 +166,9 @@ func (user *User) ExitGroup(mctx *Context, groupID string) (*Group, error) {
 		} else if group == nil {
 			return nil
 		}
+		if err := lockGroup(ctx, tx, groupID); err != nil {
+			return err
+		}
 		p, err := findParticipant(ctx, tx, groupID, user.UserID)
 		if err != nil {
 			return err
@@ -169,13 +183,7 @@ func (user *User) ExitGroup(mctx *Context, groupID string) (*Group, error) {
 		}
 		group.User = owner
 
-		var count int64
-		err = tx.QueryRowContext(ctx, "SELECT count(*) FROM participants WHERE group_id=$1", groupID).Scan(&count)
-		if err != nil {
-			return err
-		}
-		group.UsersCount = count - 1
-		_, err = tx.ExecContext(ctx, "UPDATE groups SET users_count=$1 WHERE group_id=$2", group.UsersCount, group.GroupID)
+		err = tx.QueryRowContext(ctx, "UPDATE groups SET users_count=users_count-1 WHERE group_id=$1 RETURNING users_count", group.GroupID).Scan(&group.UsersCount)
 		if err != nil {
 			return err
 		}
@@ -229,9 +237,67 @@ func (g *Group) Participants(mctx *Context, current *User, offset time.Time, lim
 	return users, nil
 }
 
+// ReadGroupMembers read users of the group ordered by the time they joined,
+// ties are ordered by user_id. cursor is the NextCursor of the previous page,
+// empty for the first page. Anonymized users are left out.
+func ReadGroupMembers(mctx *Context, groupID, cursor string, limit int) (*Page[*User], error) {
+	ctx := mctx.context
+	offset, id, err := decodeCursor(cursor, mctx.now())
+	if err != nil {
+		return nil, session.InvalidCursorError(ctx)
+	}
+	if limit < 1 || limit > 512 {
+		limit = 512
+	}
+
+	page := &Page[*User]{}
+	var joinedAt []time.Time
+	err = mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
+		query := fmt.Sprintf("SELECT %s,p.created_at FROM participants p INNER JOIN users u ON u.user_id=p.user_id WHERE p.group_id=$1 AND (p.created_at,p.user_id)>($2,$3) AND NOT %s ORDER BY p.created_at,p.user_id LIMIT $5", "u."+strings.Join(userColumns, ",u."), fmt.Sprintf(anonymizedUserSQL, "$4"))
+		rows, err := tx.QueryContext(ctx, query, groupID, offset, id, AnonymizedNickname, limit+1)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var t time.Time
+			user, err := userFromRows(rowWith{Row: rows, extra: []interface{}{&t}})
+			if err != nil {
+				return err
+			}
+			page.Items = append(page.Items, user)
+			joinedAt = append(joinedAt, t)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, session.TransactionError(ctx, err)
+	}
+	if len(page.Items) > limit {
+		page.Items, page.HasMore = page.Items[:limit], true
+		page.NextCursor = encodeCursor(joinedAt[limit-1], page.Items[limit-1].UserID)
+	}
+	return page, nil
+}
+
+// rowWith scans the columns of extra after the ones of Row, for queries
+// selecting more than a model's columns.
+type rowWith struct {
+	durable.Row
+	extra []interface{}
+}
+
+func (r rowWith) Scan(dest ...interface{}) error {
+	return r.Row.Scan(append(dest, r.extra...)...)
+}
+
 // UpdateParticipant update participant role
 func (g *Group) UpdateParticipant(mctx *Context, current *User, id, role string) error {
 	ctx := mctx.context
+	if err := checkWritable(ctx); err != nil {
+		return err
+	}
 	switch role {
 	case ParticipantRoleAdmin,
 		ParticipantRoleVIP,
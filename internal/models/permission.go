@@ -0,0 +1,54 @@
+package models
+
+// Capabilities granted by the role of an user
+const (
+	PermissionBan              = "can_ban"
+	PermissionDeleteTopic      = "can_delete_topic"
+	PermissionDeleteComment    = "can_delete_comment"
+	PermissionManageCategories = "can_manage_categories"
+	PermissionManageUsers      = "can_manage_users"
+)
+
+var rolePermissions = map[string][]string{
+	userRoleAdmin: {
+		PermissionBan,
+		PermissionDeleteTopic,
+		PermissionDeleteComment,
+		PermissionManageCategories,
+		PermissionManageUsers,
+	},
+	userRoleModerator: {
+		PermissionBan,
+		PermissionDeleteTopic,
+		PermissionDeleteComment,
+	},
+}
+
+// Permissions returns the capabilities of the user resolved by role, handlers
+// should check a named permission instead of the role.
+func (u *User) Permissions(mctx *Context) map[string]bool {
+	set := make(map[string]bool)
+	for _, p := range rolePermissions[u.Role()] {
+		set[p] = true
+	}
+	return set
+}
+
+// ActionEdit is editing the content, no role grants it on others' content
+const ActionEdit = "edit"
+
+// CanAct tells whether actor may perform action on the content owned by
+// targetOwnerID, the owner and admins may do anything, others only the
+// actions their role grants, e.g. moderators PermissionDeleteComment.
+func CanAct(actor *User, action string, targetOwnerID string) bool {
+	if actor == nil {
+		return false
+	}
+	if targetOwnerID != "" && targetOwnerID == actor.UserID {
+		return true
+	}
+	if actor.isAdmin() {
+		return true
+	}
+	return actor.Permissions(nil)[action]
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"satellity/internal/session"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+const profileAuditsDDL = `
+CREATE TABLE IF NOT EXISTS profile_audits (
+	audit_id              VARCHAR(36) PRIMARY KEY,
+	user_id               VARCHAR(36) NOT NULL REFERENCES users ON DELETE CASCADE,
+	actor_id              VARCHAR(36) NOT NULL,
+	field                 VARCHAR(32) NOT NULL,
+	old_value             TEXT NOT NULL,
+	new_value             TEXT NOT NULL,
+	created_at            TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS profile_audits_user_createdx ON profile_audits (user_id, created_at);
+`
+
+// ProfileAudit is a profile field of the user changed by the actor
+type ProfileAudit struct {
+	ActorID   string
+	Change    FieldChange
+	CreatedAt time.Time
+}
+
+// writeProfileAudits records the changes in the transaction of the update,
+// so they commit or roll back together.
+func writeProfileAudits(ctx context.Context, tx *sql.Tx, userID, actorID string, changes []FieldChange, now time.Time) error {
+	for _, c := range changes {
+		query := "INSERT INTO profile_audits(audit_id,user_id,actor_id,field,old_value,new_value,created_at) VALUES ($1,$2,$3,$4,$5,$6,$7)"
+		_, err := tx.ExecContext(ctx, query, uuid.Must(uuid.NewV4()).String(), userID, actorID, c.Field, c.Old, c.New, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadProfileAudits read the profile changes of the user, oldest first, the
+// actor is the user self or an admin.
+func (u *User) ReadProfileAudits(mctx *Context, actor *User) ([]ProfileAudit, error) {
+	ctx := mctx.context
+	if actor == nil || !isPermit(u.UserID, actor) {
+		return nil, session.ForbiddenError(ctx)
+	}
+	rows, err := mctx.database.QueryContext(ctx, "SELECT actor_id,field,old_value,new_value,created_at FROM profile_audits WHERE user_id=$1 ORDER BY created_at,field", u.UserID)
+	if err != nil {
+		return nil, session.TransactionError(ctx, err)
+	}
+	defer rows.Close()
+
+	audits := []ProfileAudit{}
+	for rows.Next() {
+		var a ProfileAudit
+		if err := rows.Scan(&a.ActorID, &a.Change.Field, &a.Change.Old, &a.Change.New, &a.CreatedAt); err != nil {
+			return nil, session.TransactionError(ctx, err)
+		}
+		audits = append(audits, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, session.TransactionError(ctx, err)
+	}
+	return audits, nil
+}
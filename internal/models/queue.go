@@ -0,0 +1,66 @@
+package models
+
+import (
+	"context"
+	"satellity/internal/session"
+	"time"
+)
+
+// Task is a deferred work of models, e.g. send a verification email or
+// recompute the counters, it runs with a copy of the context enqueued it,
+// detached from the cancellation of the request.
+type Task struct {
+	Name string
+	Run  func(mctx *Context) error
+}
+
+// Queue runs the enqueued tasks, maybe in background, an error of the task
+// is the queue's to handle, it never fails the operation enqueued it.
+type Queue interface {
+	Enqueue(mctx *Context, task Task)
+}
+
+type syncQueue struct{}
+
+// Enqueue runs the task inline and logs the error
+func (syncQueue) Enqueue(mctx *Context, task Task) {
+	if err := task.Run(mctx); err != nil {
+		if logger := session.Logger(mctx.context); logger != nil {
+			logger.Errorf("Task %s: %v", task.Name, err)
+		}
+	}
+}
+
+// DefaultQueue is the queue of contexts created by WrapContext, it runs the
+// tasks synchronously, replace it on startup to run them in background.
+var DefaultQueue Queue = syncQueue{}
+
+// WithQueue returns a copy of the context using queue
+func (mctx *Context) WithQueue(queue Queue) *Context {
+	c := *mctx
+	c.queue = queue
+	return &c
+}
+
+// Enqueue defers the task to the queue of the context, the task may outlive
+// the request, so it gets the values of the context but not its deadline.
+func (mctx *Context) Enqueue(task Task) {
+	queue := mctx.queue
+	if queue == nil {
+		queue = syncQueue{}
+	}
+	c := *mctx
+	c.context = detachedContext{mctx.context}
+	queue.Enqueue(&c, task)
+}
+
+// detachedContext keeps the values of the parent, e.g. the logger, but is
+// never canceled.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
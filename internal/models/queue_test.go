@@ -0,0 +1,86 @@
+package models
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testQueue struct {
+	names []string
+}
+
+func (q *testQueue) Enqueue(mctx *Context, task Task) {
+	q.names = append(q.names, task.Name)
+	task.Run(mctx)
+}
+
+func TestEnqueueRecomputeCommentsCount(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	user := createTestUser(mctx, "im.yuqlee@gmail.com", "username", "password")
+	category, _ := CreateCategory(mctx, "name", "alias", "Description", 0)
+	topic, _ := user.CreateTopic(mctx, "title", "body", category.CategoryID, false)
+	assert.NotNil(topic)
+	_, err := user.CreateComment(mctx, topic.TopicID, "comment body")
+	assert.Nil(err)
+	_, err = mctx.database.ExecContext(mctx.context, "UPDATE topics SET comments_count=0 WHERE topic_id=$1", topic.TopicID)
+	assert.Nil(err)
+
+	queue := &testQueue{}
+	mctx.WithQueue(queue).Enqueue(RecomputeCommentsCount(topic.TopicID))
+	assert.Equal([]string{"recompute_comments_count"}, queue.names)
+	topic, err = ReadTopic(mctx, topic.TopicID)
+	assert.Nil(err)
+	assert.Equal(int64(1), topic.CommentsCount)
+
+	_, err = mctx.database.ExecContext(mctx.context, "UPDATE topics SET comments_count=0 WHERE topic_id=$1", topic.TopicID)
+	assert.Nil(err)
+	mctx.Enqueue(RecomputeCommentsCount(topic.TopicID))
+	topic, err = ReadTopic(mctx, topic.TopicID)
+	assert.Nil(err)
+	assert.Equal(int64(1), topic.CommentsCount)
+}
+
+func TestDeleteCommentEnqueue(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	user := createTestUser(mctx, "im.yuqlee@gmail.com", "username", "password")
+	category, _ := CreateCategory(mctx, "name", "alias", "Description", 0)
+	topic, _ := user.CreateTopic(mctx, "title", "body", category.CategoryID, false)
+	assert.NotNil(topic)
+	comment, err := user.CreateComment(mctx, topic.TopicID, "comment body")
+	assert.Nil(err)
+
+	queue := &testQueue{}
+	assert.Nil(user.DeleteComment(mctx.WithQueue(queue), comment.CommentID))
+	assert.Equal([]string{"recompute_comments_count"}, queue.names)
+	topic, err = ReadTopic(mctx, topic.TopicID)
+	assert.Nil(err)
+	assert.Equal(int64(0), topic.CommentsCount)
+}
+
+func TestEnqueueDetachedContext(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	ctx, cancel := context.WithCancel(mctx.context)
+	cancel()
+	request := *mctx
+	request.context = ctx
+	var taskErr error
+	request.Enqueue(Task{Name: "check", Run: func(mctx *Context) error {
+		taskErr = mctx.context.Err()
+		return nil
+	}})
+	assert.Nil(taskErr)
+}
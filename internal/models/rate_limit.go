@@ -0,0 +1,113 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"satellity/internal/configs"
+	"satellity/internal/session"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Sliding windows of the content creation rate limits
+const (
+	topicsRateWindow       = time.Hour
+	commentsRateWindow     = time.Minute
+	failedLoginsRateWindow = time.Hour
+)
+
+// Actions of RateLimitStatus
+const (
+	RateLimitLogin   = "login"
+	RateLimitTopic   = "topic"
+	RateLimitComment = "comment"
+)
+
+// RateLimitStatus reports the remaining allowance of action within its window
+// and the time the oldest counted row leaves the window, which is now if none
+// counted. Remaining is -1 if the action is unlimited for the user. The login
+// allowance counts the failed logins.
+func (u *User) RateLimitStatus(mctx *Context, action string) (int, time.Time, error) {
+	ctx := mctx.context
+	now := mctx.now()
+	var table string
+	var max int
+	var window time.Duration
+	switch action {
+	case RateLimitLogin:
+		table, max, window = "failed_logins", failedLoginsRateLimit(), failedLoginsRateWindow
+	case RateLimitTopic:
+		table, max, window = "topics", topicsRateLimit(), topicsRateWindow
+	case RateLimitComment:
+		table, max, window = "comments", commentsRateLimit(), commentsRateWindow
+	default:
+		return 0, time.Time{}, session.BadDataError(ctx)
+	}
+	if max <= 0 || (action != RateLimitLogin && u.isAdmin()) {
+		return -1, now, nil
+	}
+
+	var count int
+	var oldest pq.NullTime
+	query := fmt.Sprintf("SELECT count(*), MIN(created_at) FROM %s WHERE user_id=$1 AND created_at>$2", table)
+	row, err := mctx.database.QueryRowContext(ctx, query, u.UserID, now.Add(-window))
+	if err != nil {
+		return 0, time.Time{}, session.TransactionError(ctx, err)
+	}
+	if err := row.Scan(&count, &oldest); err != nil {
+		return 0, time.Time{}, session.TransactionError(ctx, err)
+	}
+	remaining := max - count
+	if remaining < 0 {
+		remaining = 0
+	}
+	if !oldest.Valid {
+		return remaining, now, nil
+	}
+	return remaining, oldest.Time.Add(window), nil
+}
+
+// checkRateLimit returns TooManyRequestsError if the user has created max rows
+// in table within the window before now, max 0 means unlimited and admins are
+// exempt. The user row is locked, so concurrent creations of the same user are
+// counted one by one, call it in the creation transaction.
+func checkRateLimit(ctx context.Context, tx *sql.Tx, user *User, table string, max int, window time.Duration, now time.Time) error {
+	if max <= 0 || user.isAdmin() {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, "SELECT 1 FROM users WHERE user_id=$1 FOR UPDATE", user.UserID); err != nil {
+		return err
+	}
+	var count int
+	query := fmt.Sprintf("SELECT count(*) FROM %s WHERE user_id=$1 AND created_at>$2", table)
+	if err := tx.QueryRowContext(ctx, query, user.UserID, now.Add(-window)).Scan(&count); err != nil {
+		return err
+	}
+	if count >= max {
+		return session.TooManyRequestsError(ctx)
+	}
+	return nil
+}
+
+func topicsRateLimit() int {
+	if configs.Current() == nil {
+		return 0
+	}
+	return configs.Current().System.RateLimits.TopicsPerHour
+}
+
+func failedLoginsRateLimit() int {
+	if configs.Current() == nil {
+		return 0
+	}
+	return configs.Current().System.RateLimits.FailedLoginsPerHour
+}
+
+func commentsRateLimit() int {
+	if configs.Current() == nil {
+		return 0
+	}
+	return configs.Current().System.RateLimits.CommentsPerMinute
+}
@@ -0,0 +1,93 @@
+package models
+
+import (
+	"fmt"
+	"satellity/internal/configs"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContentRateLimits(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	limits := configs.Current().System.RateLimits
+	defer func() { configs.Current().System.RateLimits = limits }()
+	configs.Current().System.RateLimits.TopicsPerHour = 2
+	configs.Current().System.RateLimits.CommentsPerMinute = 2
+
+	user := createTestUser(mctx, "im.yuqlee@gmail.com", "username", "password")
+	assert.NotNil(user)
+	category, err := CreateCategory(mctx, "name", "alias", "Description", 0)
+	assert.Nil(err)
+	var topic *Topic
+	for i := 0; i < 2; i++ {
+		topic, err = user.CreateTopic(mctx, fmt.Sprintf("title %d", i), "body", category.CategoryID, false)
+		assert.Nil(err)
+		_, err = user.CreateComment(mctx, topic.TopicID, "comment")
+		assert.Nil(err)
+	}
+	_, err = user.CreateTopic(mctx, "title 2", "body", category.CategoryID, false)
+	assert.NotNil(err)
+	_, err = user.CreateComment(mctx, topic.TopicID, "comment")
+	assert.NotNil(err)
+
+	_, err = mctx.database.Exec("UPDATE topics SET created_at=$1", time.Now().Add(-topicsRateWindow))
+	assert.Nil(err)
+	_, err = mctx.database.Exec("UPDATE comments SET created_at=$1", time.Now().Add(-commentsRateWindow))
+	assert.Nil(err)
+	_, err = user.CreateTopic(mctx, "title 2", "body", category.CategoryID, false)
+	assert.Nil(err)
+	_, err = user.CreateComment(mctx, topic.TopicID, "comment")
+	assert.Nil(err)
+
+	configs.Current().OperatorSet[user.Email.String] = true
+	defer delete(configs.Current().OperatorSet, user.Email.String)
+	for i := 0; i < 3; i++ {
+		_, err = user.CreateComment(mctx, topic.TopicID, "comment")
+		assert.Nil(err)
+	}
+}
+
+func TestRateLimitStatus(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	limits := configs.Current().System.RateLimits
+	defer func() { configs.Current().System.RateLimits = limits }()
+	configs.Current().System.RateLimits.TopicsPerHour = 3
+	configs.Current().System.RateLimits.FailedLoginsPerHour = 2
+
+	user := createTestUser(mctx, "im.yuqlee@gmail.com", "username", "password")
+	assert.NotNil(user)
+	category, err := CreateCategory(mctx, "name", "alias", "Description", 0)
+	assert.Nil(err)
+
+	remaining, resetAt, err := user.RateLimitStatus(mctx, RateLimitTopic)
+	assert.Nil(err)
+	assert.Equal(3, remaining)
+	_, err = user.CreateTopic(mctx, "title", "body", category.CategoryID, false)
+	assert.Nil(err)
+	remaining, resetAt, err = user.RateLimitStatus(mctx, RateLimitTopic)
+	assert.Nil(err)
+	assert.Equal(2, remaining)
+	assert.True(resetAt.After(time.Now()))
+
+	assert.Nil(recordFailedLogin(mctx, user))
+	remaining, resetAt, err = user.RateLimitStatus(mctx, RateLimitLogin)
+	assert.Nil(err)
+	assert.Equal(1, remaining)
+	assert.True(resetAt.After(time.Now()))
+
+	remaining, _, err = user.RateLimitStatus(mctx, RateLimitComment)
+	assert.Nil(err)
+	assert.Equal(-1, remaining)
+	_, _, err = user.RateLimitStatus(mctx, "unknown")
+	assert.NotNil(err)
+}
@@ -3,27 +3,98 @@ CREATE TABLE IF NOT EXISTS users (
   email                  VARCHAR(512),
   username               VARCHAR(64) NOT NULL CHECK (username ~* '^[a-z0-9][a-z0-9_]{3,63}$'),
   nickname               VARCHAR(64) NOT NULL DEFAULT '',
+  display_name           VARCHAR(128),
   biography              VARCHAR(2048) NOT NULL DEFAULT '',
   encrypted_password     VARCHAR(1024),
   github_id              VARCHAR(1024) UNIQUE,
   groups_count           BIGINT NOT NULL DEFAULT 0,
+  role                   VARCHAR(32) NOT NULL DEFAULT 'member',
+  email_verified_at      TIMESTAMP WITH TIME ZONE,
+  profile_locked         BOOLEAN NOT NULL DEFAULT false,
+  profile_updated_at     TIMESTAMP WITH TIME ZONE,
   created_at             TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
   updated_at             TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
 );
 
 CREATE UNIQUE INDEX IF NOT EXISTS users_emailx ON users ((LOWER(email)));
 CREATE UNIQUE INDEX IF NOT EXISTS users_usernamex ON users ((LOWER(username)));
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_exactx ON users (username);
 CREATE INDEX IF NOT EXISTS users_createdx ON users (created_at);
+CREATE INDEX IF NOT EXISTS users_created_userx ON users (created_at, user_id);
+CREATE INDEX IF NOT EXISTS users_username_patternx ON users ((LOWER(username)) text_pattern_ops);
+CREATE INDEX IF NOT EXISTS users_username_c_userx ON users ((LOWER(username) COLLATE "C"), user_id);
+CREATE INDEX IF NOT EXISTS users_updated_userx ON users (updated_at, user_id);
+CREATE INDEX IF NOT EXISTS users_username_skeletonx ON users ((replace(replace(translate(LOWER(username), '01i', 'oll'), 'rn', 'm'), 'vv', 'w')));
+
+
+CREATE TABLE IF NOT EXISTS email_verifications (
+  user_id               VARCHAR(36) PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+  code_hash             VARCHAR(128) NOT NULL,
+  created_at            TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+
+CREATE TABLE IF NOT EXISTS failed_logins (
+  user_id               VARCHAR(36) NOT NULL REFERENCES users ON DELETE CASCADE,
+  created_at            TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS failed_logins_user_createdx ON failed_logins (user_id, created_at);
+
+
+CREATE TABLE IF NOT EXISTS username_reservations (
+  reservation_id        VARCHAR(36) PRIMARY KEY,
+  username              VARCHAR(64) NOT NULL,
+  expired_at            TIMESTAMP WITH TIME ZONE NOT NULL,
+  created_at            TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS username_reservations_usernamex ON username_reservations ((LOWER(username)));
+CREATE UNIQUE INDEX IF NOT EXISTS username_reservations_username_exactx ON username_reservations (username);
+
+
+CREATE TABLE IF NOT EXISTS linked_providers (
+  provider              VARCHAR(32) NOT NULL,
+  external_id           VARCHAR(1024) NOT NULL,
+  user_id               VARCHAR(36) NOT NULL REFERENCES users ON DELETE CASCADE,
+  handle                VARCHAR(255) NOT NULL DEFAULT '',
+  created_at            TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (provider, external_id)
+);
+
+CREATE INDEX IF NOT EXISTS linked_providers_userx ON linked_providers (user_id);
+
+
+CREATE TABLE IF NOT EXISTS profile_audits (
+  audit_id              VARCHAR(36) PRIMARY KEY,
+  user_id               VARCHAR(36) NOT NULL REFERENCES users ON DELETE CASCADE,
+  actor_id              VARCHAR(36) NOT NULL,
+  field                 VARCHAR(32) NOT NULL,
+  old_value             TEXT NOT NULL,
+  new_value             TEXT NOT NULL,
+  created_at            TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS profile_audits_user_createdx ON profile_audits (user_id, created_at);
 
 
 CREATE TABLE IF NOT EXISTS sessions (
   session_id            VARCHAR(36) PRIMARY KEY,
   user_id               VARCHAR(36) NOT NULL,
   secret                VARCHAR(1024) NOT NULL,
+  secret_hash           VARCHAR(64) NOT NULL DEFAULT '',
+  ip                    VARCHAR(64) NOT NULL DEFAULT '',
+  user_agent            VARCHAR(512),
+  device_name           VARCHAR(128),
+  last_seen_at          TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  expires_at            TIMESTAMP WITH TIME ZONE,
   created_at            TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
 );
 
 CREATE INDEX IF NOT EXISTS sessions_userx ON sessions (user_id);
+CREATE INDEX IF NOT EXISTS sessions_secret_hashx ON sessions (secret_hash);
+CREATE INDEX IF NOT EXISTS sessions_ipx ON sessions (ip);
+CREATE INDEX IF NOT EXISTS sessions_last_seenx ON sessions (last_seen_at);
 
 
 CREATE TABLE IF NOT EXISTS categories (
@@ -90,6 +161,7 @@ CREATE TABLE IF NOT EXISTS comments (
 );
 
 CREATE INDEX IF NOT EXISTS comments_topic_createdx ON comments (topic_id, created_at);
+CREATE INDEX IF NOT EXISTS comments_topic_created_commentx ON comments (topic_id, created_at, comment_id);
 CREATE INDEX IF NOT EXISTS comments_user_createdx ON comments (user_id, created_at);
 CREATE INDEX IF NOT EXISTS comments_score_createdx ON comments (score DESC, created_at);
 
@@ -131,6 +203,7 @@ CREATE TABLE IF NOT EXISTS participants (
 CREATE INDEX IF NOT EXISTS participant_createdx ON participants (created_at);
 CREATE INDEX IF NOT EXISTS participant_user_createdx ON participants (user_id,created_at);
 CREATE INDEX IF NOT EXISTS participant_group_createdx ON participants (group_id,created_at);
+CREATE INDEX IF NOT EXISTS participant_group_created_userx ON participants (group_id,created_at,user_id);
 
 
 
@@ -3,16 +3,26 @@ package models
 import (
 	"context"
 	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
 	"crypto/x509"
 	"database/sql"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"net"
+	"satellity/internal/configs"
 	"satellity/internal/durable"
 	"satellity/internal/session"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
+	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/gofrs/uuid"
+	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -21,54 +31,110 @@ CREATE TABLE IF NOT EXISTS sessions (
 	session_id            VARCHAR(36) PRIMARY KEY,
 	user_id               VARCHAR(36) NOT NULL,
 	secret                VARCHAR(1024) NOT NULL,
+	secret_hash           VARCHAR(64) NOT NULL DEFAULT '',
+	ip                    VARCHAR(64) NOT NULL DEFAULT '',
+	user_agent            VARCHAR(512),
+	device_name           VARCHAR(128),
+	last_seen_at          TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	expires_at            TIMESTAMP WITH TIME ZONE,
 	created_at            TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
 );
 CREATE INDEX ON sessions (user_id);
+CREATE INDEX IF NOT EXISTS sessions_secret_hashx ON sessions (secret_hash);
+CREATE INDEX IF NOT EXISTS sessions_ipx ON sessions (ip);
+CREATE INDEX IF NOT EXISTS sessions_last_seenx ON sessions (last_seen_at);
 `
 
 // Session contains user's current login information
 type Session struct {
-	SessionID string    `sql:"session_id,pk"`
-	UserID    string    `sql:"user_id"`
-	Secret    string    `sql:"secret"`
-	CreatedAt time.Time `sql:"created_at"`
+	SessionID  string         `sql:"session_id,pk"`
+	UserID     string         `sql:"user_id"`
+	Secret     string         `sql:"secret"`
+	SecretHash string         `sql:"secret_hash"`
+	IP         string         `sql:"ip"`
+	UserAgent  sql.NullString `sql:"user_agent"`
+	DeviceName sql.NullString `sql:"device_name"`
+	LastSeenAt time.Time      `sql:"last_seen_at"`
+	ExpiresAt  pq.NullTime    `sql:"expires_at"`
+	CreatedAt  time.Time      `sql:"created_at"`
 }
 
-var sessionColumns = []string{"session_id", "user_id", "secret", "created_at"}
+var sessionColumns = []string{"session_id", "user_id", "secret", "secret_hash", "ip", "user_agent", "device_name", "last_seen_at", "expires_at", "created_at"}
 
 func (s *Session) values() []interface{} {
-	return []interface{}{s.SessionID, s.UserID, s.Secret, s.CreatedAt}
+	return []interface{}{s.SessionID, s.UserID, s.Secret, s.SecretHash, s.IP, s.UserAgent, s.DeviceName, s.LastSeenAt, s.ExpiresAt, s.CreatedAt}
 }
 
-// CreateSession create a new user session
-func CreateSession(mctx *Context, identity, password, sessionSecret string) (*User, error) {
-	ctx := mctx.context
-	data, err := hex.DecodeString(sessionSecret)
-	if err != nil {
-		return nil, session.BadDataError(ctx)
+// SessionTTL is the lifetime of a new session, session.long_ttl if remember,
+// otherwise session.short_ttl, 0 never expires. Clients signing their tokens
+// by SignSessionToken should use it as the duration.
+func SessionTTL(remember bool) time.Duration {
+	if configs.Current() == nil {
+		return 0
 	}
-	public, err := x509.ParsePKIXPublicKey(data)
-	if err != nil {
-		return nil, session.BadDataError(ctx)
+	if remember {
+		return configs.Current().Durations.SessionLongTTL
 	}
-	switch public.(type) {
-	case *ecdsa.PublicKey:
-	default:
-		return nil, session.BadDataError(ctx)
+	return configs.Current().Durations.SessionShortTTL
+}
+
+func sessionExpiresAt(t time.Time, remember bool) pq.NullTime {
+	ttl := SessionTTL(remember)
+	if ttl <= 0 {
+		return pq.NullTime{}
 	}
+	return pq.NullTime{Time: t.Add(ttl), Valid: true}
+}
 
-	user, err := ReadUserByUsernameOrEmail(mctx, identity)
-	if err != nil {
+// Expired tells whether the session has expired at now
+func (s *Session) Expired(now time.Time) bool {
+	return s.ExpiresAt.Valid && !now.Before(s.ExpiresAt.Time)
+}
+
+// sessionTouchInterval throttles the last_seen_at writes of a session
+const sessionTouchInterval = time.Minute
+
+// SessionSecretHash is the hex encoded sha256 of the session secret
+func SessionSecretHash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateSession create a new user session, which expires after
+// SessionTTL(remember). It's safe to retry, a retried call creates another
+// session rather than failing, the session ids never collide.
+func CreateSession(mctx *Context, identity, password, sessionSecret string, remember bool) (*User, error) {
+	ctx := mctx.context
+	if err := checkWritable(ctx); err != nil {
 		return nil, err
-	} else if user == nil {
+	}
+	if err := ValidateSessionSecret(ctx, sessionSecret); err != nil {
+		return nil, err
+	}
+
+	user, err := ReadUserByUsernameOrEmail(mctx, identity)
+	if errors.Is(err, session.ErrNotFound) {
+		// spend the time of a password compare, so unknown identities can't be
+		// told by the response time
+		_ = bcrypt.CompareHashAndPassword([]byte(dummyBcryptHash()), []byte(password))
 		return nil, session.IdentityNonExistError(ctx)
+	} else if err != nil {
+		return nil, err
 	}
-	if err := bcrypt.CompareHashAndPassword([]byte(user.EncryptedPassword.String), []byte(password)); err != nil {
-		return nil, session.InvalidPasswordError(ctx)
+	// oauth only users have no password to compare, the identity is already
+	// told by IdentityNonExistError, so say why instead of a mismatch
+	if !user.EncryptedPassword.Valid || user.EncryptedPassword.String == "" {
+		return nil, session.PasswordNotSetError(ctx)
+	}
+	if err := comparePassword(mctx, user, password); err != nil {
+		return nil, err
 	}
 
 	err = mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
-		s, err := user.addSession(ctx, tx, sessionSecret)
+		if err := resetFailedLogins(ctx, tx, user); err != nil {
+			return err
+		}
+		s, err := user.loginSession(ctx, tx, sessionSecret, remember, mctx.now())
 		if err != nil {
 			return err
 		}
@@ -81,40 +147,552 @@ func CreateSession(mctx *Context, identity, password, sessionSecret string) (*Us
 	return user, nil
 }
 
-func (user *User) addSession(ctx context.Context, tx *sql.Tx, secret string) (*Session, error) {
+var dummyHash struct {
+	sync.Mutex
+	cost int
+	hash string
+}
+
+// dummyBcryptHash is a valid hash of a random password at passwordCost, it's
+// generated once and again when the cost changes.
+func dummyBcryptHash() string {
+	cost := passwordCost()
+	dummyHash.Lock()
+	defer dummyHash.Unlock()
+	if dummyHash.hash != "" && dummyHash.cost == cost {
+		return dummyHash.hash
+	}
+	password := make([]byte, 32)
+	if _, err := rand.Read(password); err != nil {
+		panic(err)
+	}
+	hash, err := bcrypt.GenerateFromPassword(password, cost)
+	if err != nil {
+		panic(err)
+	}
+	dummyHash.cost, dummyHash.hash = cost, string(hash)
+	return dummyHash.hash
+}
+
+// ValidateSessionSecret checks the session secret is a hex encoded PKIX ECDSA
+// public key, it has no side effects, handlers could pre-validate with it.
+func ValidateSessionSecret(ctx context.Context, secret string) error {
+	if secret == "" {
+		return session.MissingFieldError(ctx, "session secret")
+	}
+	data, err := hex.DecodeString(secret)
+	if err != nil {
+		return session.BadDataError(ctx)
+	}
+	public, err := x509.ParsePKIXPublicKey(data)
+	if err != nil {
+		return session.BadDataError(ctx)
+	}
+	if _, ok := public.(*ecdsa.PublicKey); !ok {
+		return session.BadDataError(ctx)
+	}
+	return nil
+}
+
+// GenerateSessionKeyPair generates a P-256 key pair for clients which can't do
+// it, the public key is the hex encoded PKIX session secret, the private key is
+// hex encoded SEC 1, it's returned once and never stored.
+func GenerateSessionKeyPair() (string, string, error) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	public, err := x509.MarshalPKIXPublicKey(priv.Public())
+	if err != nil {
+		return "", "", err
+	}
+	private, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(public), hex.EncodeToString(private), nil
+}
+
+// SignSessionToken mints the ES256 token of the session by the private key of
+// GenerateSessionKeyPair, valid for the duration, with the iss and aud claims
+// of the config.
+func SignSessionToken(privateHex, uid, sid string, duration time.Duration) (string, error) {
+	der, err := hex.DecodeString(privateHex)
+	if err != nil {
+		return "", err
+	}
+	priv, err := x509.ParseECPrivateKey(der)
+	if err != nil {
+		return "", err
+	}
+	claims := jwt.MapClaims{"uid": uid, "sid": sid, "exp": time.Now().Add(duration).Unix()}
+	if config := configs.Current(); config != nil {
+		if iss := config.Session.JWTIssuer; iss != "" {
+			claims["iss"] = iss
+		}
+		if aud := config.Session.JWTAudience; aud != "" {
+			claims["aud"] = aud
+		}
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodES256, claims).SignedString(priv)
+}
+
+// signingKey returns the public key of kid in session.keys, tokens of unknown
+// kid are rejected.
+func signingKey(kid string) (interface{}, error) {
+	var hexKey string
+	if config := configs.Current(); config != nil {
+		hexKey = config.Session.Keys[kid]
+	}
+	if hexKey == "" {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	pkix, err := hex.DecodeString(hexKey)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParsePKIXPublicKey(pkix)
+}
+
+// sessionIP is the remote address of the request in the canonical form of
+// net.IP, without port, so ReadSessionsByIP matches any spelling of the
+// address, e.g. "2001:DB8::0:1" is stored as "2001:db8::1". An address not
+// parsed as IP is stored as it is.
+func sessionIP(ctx context.Context) string {
+	address := strings.TrimSpace(session.RemoteAddress(ctx))
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+	if ip := net.ParseIP(address); ip != nil {
+		return ip.String()
+	}
+	return address
+}
+
+// sessionDevice is the user agent of the request and its device name, null if
+// the request has no user agent
+func sessionDevice(ctx context.Context) (sql.NullString, sql.NullString) {
+	ua := session.UserAgent(ctx)
+	if ua == "" {
+		return sql.NullString{}, sql.NullString{}
+	}
+	if utf8.RuneCountInString(ua) > maximumUserAgentSize {
+		ua = string([]rune(ua)[:maximumUserAgentSize])
+	}
+	return sql.NullString{String: ua, Valid: true}, sql.NullString{String: DeviceName(ua), Valid: true}
+}
+
+func sessionMaxPerUser() int {
+	if configs.Current() == nil {
+		return 0
+	}
+	return configs.Current().Session.MaxPerUser
+}
+
+// Values of session.relogin
+const (
+	sessionReloginNew   = "new"
+	sessionReloginReuse = "reuse"
+)
+
+func sessionRelogin() string {
+	if configs.Current() == nil || configs.Current().Session.Relogin == "" {
+		return sessionReloginNew
+	}
+	return configs.Current().Session.Relogin
+}
+
+// loginSession is the session of a re-login, a new one, or with session.relogin
+// "reuse" the newest session of the same user agent and IP with the secret
+// rotated in place, so clients which lost their token don't pile up sessions.
+// The device name alone is too coarse, two phones of the same model share it.
+// A reused session is signed in again, its created_at is now.
+func (user *User) loginSession(ctx context.Context, tx *sql.Tx, secret string, remember bool, now time.Time) (*Session, error) {
+	ua, _ := sessionDevice(ctx)
+	if sessionRelogin() != sessionReloginReuse || !ua.Valid {
+		return user.addSession(ctx, tx, secret, remember, now)
+	}
+	ip := sessionIP(ctx)
+	query := fmt.Sprintf("SELECT %s FROM sessions WHERE user_id=$1 AND user_agent=$2 AND ip=$3 ORDER BY created_at DESC, session_id DESC LIMIT 1 FOR UPDATE", strings.Join(sessionColumns, ","))
+	s, err := sessionFromRows(tx.QueryRowContext(ctx, query, user.UserID, ua, ip))
+	if err == sql.ErrNoRows {
+		return user.addSession(ctx, tx, secret, remember, now)
+	} else if err != nil {
+		return nil, err
+	}
+	s.Secret, s.SecretHash, s.LastSeenAt, s.CreatedAt = secret, SessionSecretHash(secret), now, now
+	s.ExpiresAt = sessionExpiresAt(now, remember)
+	cols, params := durable.PrepareColumnsWithValuesOffset([]string{"secret", "secret_hash", "last_seen_at", "expires_at", "created_at"}, 1)
+	_, err = tx.ExecContext(ctx, fmt.Sprintf("UPDATE sessions SET (%s)=(%s) WHERE session_id=$1", cols, params), s.SessionID, s.Secret, s.SecretHash, s.LastSeenAt, s.ExpiresAt, s.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	authenticatedSessions.invalidate(user.UserID, s.SessionID)
+	return s, nil
+}
+
+// newSessionID generates the session id, replaced in tests to force a conflict
+var newSessionID = func() string {
+	return uuid.Must(uuid.NewV4()).String()
+}
+
+func (user *User) addSession(ctx context.Context, tx *sql.Tx, secret string, remember bool, now time.Time) (*Session, error) {
 	s := &Session{
-		SessionID: uuid.Must(uuid.NewV4()).String(),
-		UserID:    user.UserID,
-		Secret:    secret,
-		CreatedAt: time.Now(),
+		SessionID:  newSessionID(),
+		UserID:     user.UserID,
+		Secret:     secret,
+		SecretHash: SessionSecretHash(secret),
+		IP:         sessionIP(ctx),
+		LastSeenAt: now,
+		ExpiresAt:  sessionExpiresAt(now, remember),
+		CreatedAt:  now,
 	}
 
+	s.UserAgent, s.DeviceName = sessionDevice(ctx)
+
+	// a conflicting session id is regenerated once, ON CONFLICT keeps the
+	// transaction usable, a failed INSERT would abort it
 	cols, params := durable.PrepareColumnsWithValues(sessionColumns)
-	_, err := tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO sessions(%s) VALUES(%s)", cols, params), s.values()...)
+	query := fmt.Sprintf("INSERT INTO sessions(%s) VALUES(%s) ON CONFLICT (session_id) DO NOTHING", cols, params)
+	for attempt := 0; ; attempt++ {
+		result, err := tx.ExecContext(ctx, query, s.values()...)
+		if err != nil {
+			return nil, session.TransactionError(ctx, err)
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return nil, session.TransactionError(ctx, err)
+		}
+		if rows > 0 {
+			break
+		}
+		if attempt > 0 {
+			return nil, session.TransactionError(ctx, fmt.Errorf("duplicate session id %s", s.SessionID))
+		}
+		s.SessionID = newSessionID()
+	}
+	if max := sessionMaxPerUser(); max > 0 {
+		query := "DELETE FROM sessions WHERE session_id IN (SELECT session_id FROM sessions WHERE user_id=$1 ORDER BY created_at DESC, session_id DESC OFFSET $2)"
+		if _, err := tx.ExecContext(ctx, query, user.UserID, max); err != nil {
+			return nil, session.TransactionError(ctx, err)
+		}
+		authenticatedSessions.invalidateUser(user.UserID)
+	}
+	return s, nil
+}
+
+// RotateAllSessionSecrets replaces the secret of every session of the user,
+// secrets maps session id to the new secret, it's an alternative of revoking
+// sessions on password change for users who want to stay logged in. Tokens
+// signed by the old keys stop working.
+func (user *User) RotateAllSessionSecrets(mctx *Context, secrets map[string]string) error {
+	ctx := mctx.context
+	if err := checkWritable(ctx); err != nil {
+		return err
+	}
+	for _, secret := range secrets {
+		if err := ValidateSessionSecret(ctx, secret); err != nil {
+			return err
+		}
+	}
+
+	err := mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
+		for sid, secret := range secrets {
+			result, err := tx.ExecContext(ctx, "UPDATE sessions SET (secret,secret_hash)=($1,$2) WHERE user_id=$3 AND session_id=$4", secret, SessionSecretHash(secret), user.UserID, sid)
+			if err != nil {
+				return err
+			}
+			if count, err := result.RowsAffected(); err != nil {
+				return err
+			} else if count == 0 {
+				return session.NotFoundError(ctx)
+			}
+		}
+		return nil
+	})
+	authenticatedSessions.invalidateUser(user.UserID)
+	if err != nil {
+		if _, ok := err.(session.Error); ok {
+			return err
+		}
+		return session.TransactionError(ctx, err)
+	}
+	return nil
+}
+
+// RevokeSession deletes a session of the user by id, e.g. log out another
+// device, the current session could be revoked too.
+func (user *User) RevokeSession(mctx *Context, sessionID string) error {
+	ctx := mctx.context
+	if err := checkWritable(ctx); err != nil {
+		return err
+	}
+	if _, err := uuid.FromString(sessionID); err != nil {
+		return session.NotFoundError(ctx)
+	}
+
+	err := mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
+		row := tx.QueryRowContext(ctx, fmt.Sprintf("SELECT %s FROM sessions WHERE session_id=$1 FOR UPDATE", strings.Join(sessionColumns, ",")), sessionID)
+		s, err := sessionFromRows(row)
+		if err == sql.ErrNoRows {
+			return session.NotFoundError(ctx)
+		} else if err != nil {
+			return err
+		}
+		if s.UserID != user.UserID {
+			return session.ForbiddenError(ctx)
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM sessions WHERE session_id=$1", s.SessionID)
+		return err
+	})
+	if err != nil {
+		if _, ok := err.(session.Error); ok {
+			return err
+		}
+		return session.TransactionError(ctx, err)
+	}
+	authenticatedSessions.invalidate(user.UserID, sessionID)
+	return nil
+}
+
+// DedupeSessionsByDevice keeps the newest session of each device name of the
+// user and revokes the older ones, left by re-logins of clients which lost
+// their token, returns the count revoked. Sessions without a device name are
+// kept.
+func (user *User) DedupeSessionsByDevice(mctx *Context) (int64, error) {
+	ctx := mctx.context
+	if err := checkWritable(ctx); err != nil {
+		return 0, err
+	}
+	query := `DELETE FROM sessions WHERE session_id IN (
+	SELECT session_id FROM (
+		SELECT session_id, row_number() OVER (PARTITION BY device_name ORDER BY created_at DESC, session_id DESC) AS n
+		FROM sessions WHERE user_id=$1 AND device_name IS NOT NULL
+	) d WHERE d.n>1)`
+	result, err := mctx.database.ExecContext(ctx, query, user.UserID)
+	if err != nil {
+		return 0, session.TransactionError(ctx, err)
+	}
+	count, err := result.RowsAffected()
 	if err != nil {
+		return 0, session.TransactionError(ctx, err)
+	}
+	if count > 0 {
+		authenticatedSessions.invalidateUser(user.UserID)
+	}
+	return count, nil
+}
+
+// touchSession sets last_seen_at of the session to now, at most once per
+// sessionTouchInterval. It's skipped in the read only mode, signed in users
+// are still authenticated then.
+func touchSession(mctx *Context, uid, sid string) error {
+	ctx := mctx.context
+	if checkWritable(ctx) != nil {
+		return nil
+	}
+	t := mctx.now()
+	_, err := mctx.database.ExecContext(ctx, "UPDATE sessions SET last_seen_at=$1 WHERE user_id=$2 AND session_id=$3 AND last_seen_at<$4", t, uid, sid, t.Add(-sessionTouchInterval))
+	return err
+}
+
+// sessionCleanupPause is the sleep between two batches of DeleteSessionsOlderThan
+var sessionCleanupPause = 50 * time.Millisecond
+
+func sessionCleanupBatchSize() int {
+	if config := configs.Current(); config != nil && config.Session.CleanupBatchSize > 0 {
+		return config.Session.CleanupBatchSize
+	}
+	return 1000
+}
+
+// DeleteSessionsOlderThan deletes the sessions created before cutoff, returns
+// the count. It deletes session.cleanup_batch_size rows a time until none
+// remains, so a huge cleanup doesn't lock the table for long.
+func DeleteSessionsOlderThan(mctx *Context, cutoff time.Time) (int64, error) {
+	ctx := mctx.context
+	if err := checkWritable(ctx); err != nil {
+		return 0, err
+	}
+	query := "WITH stale AS (SELECT session_id FROM sessions WHERE created_at<$1 LIMIT $2) DELETE FROM sessions WHERE session_id IN (SELECT session_id FROM stale) RETURNING user_id, session_id"
+	batch := sessionCleanupBatchSize()
+	var total int64
+	for {
+		rows, err := mctx.database.QueryContext(ctx, query, cutoff, batch)
+		if err != nil {
+			return total, session.TransactionError(ctx, err)
+		}
+		var count int
+		for rows.Next() {
+			var uid, sid string
+			if err := rows.Scan(&uid, &sid); err != nil {
+				rows.Close()
+				return total, session.TransactionError(ctx, err)
+			}
+			authenticatedSessions.invalidate(uid, sid)
+			count++
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return total, session.TransactionError(ctx, err)
+		}
+		total += int64(count)
+		if count < batch {
+			return total, nil
+		}
+		time.Sleep(sessionCleanupPause)
+	}
+}
+
+// DeleteOrphanedSessions deletes the sessions whose user doesn't exist, e.g.
+// left by users deleted without their sessions, returns the count.
+func DeleteOrphanedSessions(mctx *Context) (int64, error) {
+	ctx := mctx.context
+	if err := checkWritable(ctx); err != nil {
+		return 0, err
+	}
+	query := "DELETE FROM sessions WHERE session_id IN (SELECT s.session_id FROM sessions s LEFT JOIN users u ON u.user_id=s.user_id WHERE u.user_id IS NULL) RETURNING user_id, session_id"
+	rows, err := mctx.database.QueryContext(ctx, query)
+	if err != nil {
+		return 0, session.TransactionError(ctx, err)
+	}
+	defer rows.Close()
+
+	var count int64
+	for rows.Next() {
+		var uid, sid string
+		if err := rows.Scan(&uid, &sid); err != nil {
+			return count, session.TransactionError(ctx, err)
+		}
+		authenticatedSessions.invalidate(uid, sid)
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return count, session.TransactionError(ctx, err)
+	}
+	return count, nil
+}
+
+// IsCurrent tells whether the session is the one u authenticated with
+func (s *Session) IsCurrent(u *User) bool {
+	return u != nil && s.SessionID == u.SessionID
+}
+
+// Sessions read the sessions of the user, newest first, session_id breaks the
+// ties of created_at, so the order is stable and each session appears once.
+func (user *User) Sessions(mctx *Context) ([]*Session, error) {
+	ctx := mctx.context
+	rows, err := mctx.database.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM sessions WHERE user_id=$1 ORDER BY created_at DESC, session_id", strings.Join(sessionColumns, ",")), user.UserID)
+	if err != nil {
+		return nil, session.TransactionError(ctx, err)
+	}
+	defer rows.Close()
+
+	var sessions []*Session
+	for rows.Next() {
+		s, err := sessionFromRows(rows)
+		if err != nil {
+			return nil, session.TransactionError(ctx, err)
+		}
+		sessions = append(sessions, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, session.TransactionError(ctx, err)
+	}
+	return sessions, nil
+}
+
+// ReadSessionsByIP read the sessions created from ip, newest first, for
+// incident response. Admin only, the secrets are stripped.
+func ReadSessionsByIP(mctx *Context, actor *User, ip string) ([]*Session, error) {
+	ctx := mctx.context
+	if actor == nil || !actor.isAdmin() {
+		return nil, session.ForbiddenError(ctx)
+	}
+	parsed := net.ParseIP(strings.TrimSpace(ip))
+	if parsed == nil {
+		return nil, session.BadDataError(ctx)
+	}
+	rows, err := mctx.database.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM sessions WHERE ip=$1 ORDER BY created_at DESC, session_id", strings.Join(sessionColumns, ",")), parsed.String())
+	if err != nil {
+		return nil, session.TransactionError(ctx, err)
+	}
+	defer rows.Close()
+
+	var sessions []*Session
+	for rows.Next() {
+		s, err := sessionFromRows(rows)
+		if err != nil {
+			return nil, session.TransactionError(ctx, err)
+		}
+		s.Secret, s.SecretHash = "", ""
+		sessions = append(sessions, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, session.TransactionError(ctx, err)
+	}
+	return sessions, nil
+}
+
+// ReadSessionBySecretHash read the session by the hash of its secret, for the
+// opaque token authentication, returns nil if not found.
+func ReadSessionBySecretHash(mctx *Context, hash string) (*Session, error) {
+	ctx := mctx.context
+	if len(hash) != sha256.Size*2 {
+		return nil, nil
+	}
+	row, err := mctx.database.QueryRowContext(ctx, fmt.Sprintf("SELECT %s FROM sessions WHERE secret_hash=$1", strings.Join(sessionColumns, ",")), hash)
+	if err != nil {
+		return nil, session.TransactionError(ctx, err)
+	}
+	s, err := sessionFromRows(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, session.TransactionError(ctx, err)
+	}
+	return s, nil
+}
+
+// ReadSession read the session sid of the user uid, a missing session is
+// reported as session.NotFoundError, the same as ReadUser.
+func ReadSession(mctx *Context, uid, sid string) (*Session, error) {
+	ctx := mctx.context
+	var s *Session
+	err := mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
+		var err error
+		s, err = readSession(ctx, tx, uid, sid)
+		return err
+	})
+	if err != nil {
+		if _, ok := err.(session.Error); ok {
+			return nil, err
+		}
 		return nil, session.TransactionError(ctx, err)
 	}
 	return s, nil
 }
 
+// readSession returns session.NotFoundError if the session is absent
 func readSession(ctx context.Context, tx *sql.Tx, uid, sid string) (*Session, error) {
 	if id, _ := uuid.FromString(uid); id.String() == uuid.Nil.String() {
-		return nil, nil
+		return nil, session.NotFoundError(ctx)
 	}
 	if id, _ := uuid.FromString(sid); id.String() == uuid.Nil.String() {
-		return nil, nil
+		return nil, session.NotFoundError(ctx)
 	}
 
 	row := tx.QueryRowContext(ctx, fmt.Sprintf("SELECT %s FROM sessions WHERE user_id=$1 AND session_id=$2", strings.Join(sessionColumns, ",")), uid, sid)
 	s, err := sessionFromRows(row)
 	if err == sql.ErrNoRows {
-		return nil, nil
+		return nil, session.NotFoundError(ctx)
 	}
 	return s, err
 }
 
 func sessionFromRows(row durable.Row) (*Session, error) {
 	var s Session
-	err := row.Scan(&s.SessionID, &s.UserID, &s.Secret, &s.CreatedAt)
+	err := row.Scan(&s.SessionID, &s.UserID, &s.Secret, &s.SecretHash, &s.IP, &s.UserAgent, &s.DeviceName, &s.LastSeenAt, &s.ExpiresAt, &s.CreatedAt)
 	return &s, err
 }
@@ -0,0 +1,101 @@
+package models
+
+import (
+	"satellity/internal/configs"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const maximumCachedSessions = 10000
+
+type cachedSession struct {
+	user      User
+	secret    string
+	expiredAt time.Time
+}
+
+// sessionCache keeps the authenticated sessions for session.cache_ttl, so
+// AuthenticateUser doesn't read the database on every request. Entries must be
+// invalidated when the session or the user changes.
+type sessionCache struct {
+	sync.Mutex
+	entries map[string]*cachedSession
+}
+
+var authenticatedSessions = &sessionCache{entries: make(map[string]*cachedSession)}
+
+func sessionCacheTTL() time.Duration {
+	if configs.Current() == nil {
+		return 0
+	}
+	return configs.Current().Durations.SessionCacheTTL
+}
+
+func sessionCacheKey(uid, sid string) string {
+	return uid + ":" + sid
+}
+
+// get returns a copy of the cached user and the session secret, nil if missing
+// or expired at now.
+func (c *sessionCache) get(uid, sid string, now time.Time) (*User, string) {
+	if sessionCacheTTL() <= 0 {
+		return nil, ""
+	}
+	c.Lock()
+	defer c.Unlock()
+	key := sessionCacheKey(uid, sid)
+	entry := c.entries[key]
+	if entry == nil {
+		return nil, ""
+	}
+	if now.After(entry.expiredAt) {
+		delete(c.entries, key)
+		return nil, ""
+	}
+	user := entry.user
+	return &user, entry.secret
+}
+
+// set caches the user of the session for session.cache_ttl from now, or until
+// the session expires if that's earlier.
+func (c *sessionCache) set(user *User, secret string, sessionExpiresAt pq.NullTime, now time.Time) {
+	ttl := sessionCacheTTL()
+	if ttl <= 0 || user == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	expiredAt := now.Add(ttl)
+	if sessionExpiresAt.Valid && sessionExpiresAt.Time.Before(expiredAt) {
+		expiredAt = sessionExpiresAt.Time
+	}
+	if len(c.entries) >= maximumCachedSessions {
+		for key, entry := range c.entries {
+			if now.After(entry.expiredAt) {
+				delete(c.entries, key)
+			}
+		}
+		if len(c.entries) >= maximumCachedSessions {
+			return
+		}
+	}
+	c.entries[sessionCacheKey(user.UserID, user.SessionID)] = &cachedSession{user: *user, secret: secret, expiredAt: expiredAt}
+}
+
+func (c *sessionCache) invalidate(uid, sid string) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.entries, sessionCacheKey(uid, sid))
+}
+
+func (c *sessionCache) invalidateUser(uid string) {
+	c.Lock()
+	defer c.Unlock()
+	for key, entry := range c.entries {
+		if entry.user.UserID == uid {
+			delete(c.entries, key)
+		}
+	}
+}
@@ -0,0 +1,827 @@
+package models
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"satellity/internal/configs"
+	"satellity/internal/session"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestValidateSessionSecret(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(err)
+	public, err := x509.MarshalPKIXPublicKey(priv.Public())
+	assert.Nil(err)
+	assert.Nil(ValidateSessionSecret(ctx, hex.EncodeToString(public)))
+
+	assert.NotNil(ValidateSessionSecret(ctx, "not a hex string"))
+	assert.NotNil(ValidateSessionSecret(ctx, hex.EncodeToString([]byte("not a pkix blob"))))
+
+	rsaPriv, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(err)
+	rsaPublic, err := x509.MarshalPKIXPublicKey(rsaPriv.Public())
+	assert.Nil(err)
+	assert.NotNil(ValidateSessionSecret(ctx, hex.EncodeToString(rsaPublic)))
+}
+
+func TestEmptySessionSecret(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	_, err := CreateUser(mctx, "im.yuqlee@gmail.com", "username", "nickname", "", "password", "")
+	assert.NotNil(err)
+	sessionErr, ok := err.(session.Error)
+	assert.True(ok)
+	assert.Equal(10002, sessionErr.Code)
+	assert.Equal("The session secret is required.", sessionErr.Description)
+
+	_, err = CreateSession(mctx, "username", "password", "", false)
+	assert.NotNil(err)
+	sessionErr, ok = err.(session.Error)
+	assert.True(ok)
+	assert.Equal(10002, sessionErr.Code)
+	assert.Equal("The session secret is required.", sessionErr.Description)
+}
+
+func TestAuthenticateUserCache(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	ttl := configs.Current().Durations.SessionCacheTTL
+	defer func() { configs.Current().Durations.SessionCacheTTL = ttl }()
+	configs.Current().Durations.SessionCacheTTL = time.Minute
+
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	public, _ := x509.MarshalPKIXPublicKey(priv.Public())
+	user, err := CreateUser(mctx, "im.yuqlee@gmail.com", "username", "nickname", "", "password", hex.EncodeToString(public))
+	assert.Nil(err)
+	claims := &jwt.MapClaims{"uid": user.UserID, "sid": user.SessionID}
+	ss, err := jwt.NewWithClaims(jwt.SigningMethodES256, claims).SignedString(priv)
+	assert.Nil(err)
+	current, err := AuthenticateUser(mctx, ss)
+	assert.Nil(err)
+	assert.NotNil(current)
+
+	// the session row is gone, only the cache could authenticate it
+	_, err = mctx.database.Exec("DELETE FROM sessions WHERE session_id=$1", user.SessionID)
+	assert.Nil(err)
+	current, err = AuthenticateUser(mctx, ss)
+	assert.Nil(err)
+	assert.NotNil(current)
+	assert.Equal(user.SessionID, current.SessionID)
+
+	// the cached session expires by the clock of the context
+	later := mctx.WithClock(&fakeClock{now: time.Now().Add(2 * time.Minute)})
+	current, err = AuthenticateUser(later, ss)
+	assert.Nil(err)
+	assert.Nil(current)
+
+	// revoking a session drops it from the cache
+	second, err := CreateSession(mctx, "username", "password", hex.EncodeToString(public), false)
+	assert.Nil(err)
+	claims = &jwt.MapClaims{"uid": second.UserID, "sid": second.SessionID}
+	ss, err = jwt.NewWithClaims(jwt.SigningMethodES256, claims).SignedString(priv)
+	assert.Nil(err)
+	current, err = AuthenticateUser(mctx, ss)
+	assert.Nil(err)
+	assert.NotNil(current)
+	assert.Nil(second.RevokeSession(mctx, second.SessionID))
+	current, err = AuthenticateUser(mctx, ss)
+	assert.Nil(err)
+	assert.Nil(current)
+}
+
+func TestRotateAllSessionSecrets(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	sign := func(priv *ecdsa.PrivateKey, u *User) string {
+		claims := &jwt.MapClaims{"uid": u.UserID, "sid": u.SessionID}
+		ss, _ := jwt.NewWithClaims(jwt.SigningMethodES256, claims).SignedString(priv)
+		return ss
+	}
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	public, _ := x509.MarshalPKIXPublicKey(priv.Public())
+	user, err := CreateUser(mctx, "im.yuqlee@gmail.com", "username", "nickname", "", "password", hex.EncodeToString(public))
+	assert.Nil(err)
+	second, err := CreateSession(mctx, "username", "password", hex.EncodeToString(public), false)
+	assert.Nil(err)
+
+	err = user.RotateAllSessionSecrets(mctx, map[string]string{user.SessionID: "invalid secret"})
+	assert.NotNil(err)
+	keys := make(map[string]*ecdsa.PrivateKey)
+	secrets := make(map[string]string)
+	for _, sid := range []string{user.SessionID, second.SessionID} {
+		keys[sid], _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		public, _ := x509.MarshalPKIXPublicKey(keys[sid].Public())
+		secrets[sid] = hex.EncodeToString(public)
+	}
+	err = user.RotateAllSessionSecrets(mctx, secrets)
+	assert.Nil(err)
+	for _, u := range []*User{user, second} {
+		current, err := AuthenticateUser(mctx, sign(priv, u))
+		assert.Nil(err)
+		assert.Nil(current)
+		current, err = AuthenticateUser(mctx, sign(keys[u.SessionID], u))
+		assert.Nil(err)
+		assert.NotNil(current)
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	public, _ := x509.MarshalPKIXPublicKey(priv.Public())
+	user, err := CreateUser(mctx, "im.yuqlee@gmail.com", "username", "nickname", "", "password", hex.EncodeToString(public))
+	assert.Nil(err)
+	phone, err := CreateSession(mctx, "username", "password", hex.EncodeToString(public), false)
+	assert.Nil(err)
+	other, err := CreateUser(mctx, "validfake@gmail.com", "usernamex", "nickname", "", "password", hex.EncodeToString(public))
+	assert.Nil(err)
+
+	err = user.RevokeSession(mctx, other.SessionID)
+	assert.NotNil(err)
+	assert.Equal(http.StatusForbidden, err.(session.Error).Code)
+	err = user.RevokeSession(mctx, uuid.Must(uuid.NewV4()).String())
+	assert.True(errors.Is(err, session.ErrNotFound))
+	err = user.RevokeSession(mctx, phone.SessionID)
+	assert.Nil(err)
+	err = user.RevokeSession(mctx, phone.SessionID)
+	assert.True(errors.Is(err, session.ErrNotFound))
+	err = user.RevokeSession(mctx, user.SessionID)
+	assert.Nil(err)
+	var count int
+	row, err := mctx.database.QueryRow("SELECT count(*) FROM sessions WHERE user_id=$1", user.UserID)
+	assert.Nil(err)
+	assert.Nil(row.Scan(&count))
+	assert.Equal(0, count)
+}
+
+func TestAuthenticateUserKeyRing(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	keys := configs.Current().Session.Keys
+	defer func() { configs.Current().Session.Keys = keys }()
+	configs.Current().Session.Keys = make(map[string]string)
+	ring := make(map[string]*ecdsa.PrivateKey)
+	for _, kid := range []string{"2020-01", "2020-02"} {
+		ring[kid], _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		public, _ := x509.MarshalPKIXPublicKey(ring[kid].Public())
+		configs.Current().Session.Keys[kid] = hex.EncodeToString(public)
+	}
+
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	public, _ := x509.MarshalPKIXPublicKey(priv.Public())
+	user, err := CreateUser(mctx, "im.yuqlee@gmail.com", "username", "nickname", "", "password", hex.EncodeToString(public))
+	assert.Nil(err)
+	sign := func(kid string, key *ecdsa.PrivateKey) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, &jwt.MapClaims{"uid": user.UserID, "sid": user.SessionID})
+		token.Header["kid"] = kid
+		ss, _ := token.SignedString(key)
+		return ss
+	}
+	for kid, key := range ring {
+		current, err := AuthenticateUser(mctx, sign(kid, key))
+		assert.Nil(err)
+		assert.NotNil(current)
+	}
+	current, err := AuthenticateUser(mctx, sign("2020-03", ring["2020-01"]))
+	assert.Nil(err)
+	assert.Nil(current)
+	current, err = AuthenticateUser(mctx, sign("2020-02", priv))
+	assert.Nil(err)
+	assert.Nil(current)
+}
+
+func TestReadSession(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	user := createTestUser(mctx, "im.yuqlee@gmail.com", "username", "password")
+	s, err := ReadSession(mctx, user.UserID, user.SessionID)
+	assert.Nil(err)
+	assert.Equal(user.SessionID, s.SessionID)
+	s, err = ReadSession(mctx, user.UserID, uuid.Must(uuid.NewV4()).String())
+	assert.True(errors.Is(err, session.ErrNotFound))
+	assert.Nil(s)
+	s, err = ReadSession(mctx, "invalid", user.SessionID)
+	assert.True(errors.Is(err, session.ErrNotFound))
+	assert.Nil(s)
+}
+
+func TestReadSessionBySecretHash(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	public, _ := x509.MarshalPKIXPublicKey(priv.Public())
+	secret := hex.EncodeToString(public)
+	user, err := CreateUser(mctx, "im.yuqlee@gmail.com", "username", "nickname", "", "password", secret)
+	assert.Nil(err)
+
+	s, err := ReadSessionBySecretHash(mctx, SessionSecretHash(secret))
+	assert.Nil(err)
+	assert.NotNil(s)
+	assert.Equal(user.SessionID, s.SessionID)
+	assert.Equal(user.UserID, s.UserID)
+	s, err = ReadSessionBySecretHash(mctx, SessionSecretHash(secret+"00"))
+	assert.Nil(err)
+	assert.Nil(s)
+	s, err = ReadSessionBySecretHash(mctx, "invalid")
+	assert.Nil(err)
+	assert.Nil(s)
+}
+
+func TestSessionIsCurrent(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	public, _ := x509.MarshalPKIXPublicKey(priv.Public())
+	user, err := CreateUser(mctx, "im.yuqlee@gmail.com", "username", "nickname", "", "password", hex.EncodeToString(public))
+	assert.Nil(err)
+	phone, err := CreateSession(mctx, "username", "password", hex.EncodeToString(public), false)
+	assert.Nil(err)
+	claims := &jwt.MapClaims{"uid": phone.UserID, "sid": phone.SessionID}
+	ss, err := jwt.NewWithClaims(jwt.SigningMethodES256, claims).SignedString(priv)
+	assert.Nil(err)
+	current, err := AuthenticateUser(mctx, ss)
+	assert.Nil(err)
+	assert.NotNil(current)
+
+	sessions, err := current.Sessions(mctx)
+	assert.Nil(err)
+	assert.Len(sessions, 2)
+	for _, s := range sessions {
+		assert.Equal(s.SessionID == phone.SessionID, s.IsCurrent(current))
+	}
+	assert.Equal(phone.SessionID, sessions[0].SessionID)
+	assert.False(sessions[1].IsCurrent(current))
+	assert.Equal(user.SessionID, sessions[1].SessionID)
+	assert.False(sessions[0].IsCurrent(nil))
+}
+
+func TestSessionsOrder(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	public, _ := x509.MarshalPKIXPublicKey(priv.Public())
+	user, err := CreateUser(mctx, "im.yuqlee@gmail.com", "username", "nickname", "", "password", hex.EncodeToString(public))
+	assert.Nil(err)
+	for i := 0; i < 4; i++ {
+		_, err := CreateSession(mctx, "username", "password", hex.EncodeToString(public), false)
+		assert.Nil(err)
+	}
+	_, err = mctx.database.Exec("UPDATE sessions SET created_at=$1 WHERE user_id=$2", time.Now(), user.UserID)
+	assert.Nil(err)
+
+	sessions, err := user.Sessions(mctx)
+	assert.Nil(err)
+	assert.Len(sessions, 5)
+	seen := make(map[string]bool)
+	current := 0
+	for i, s := range sessions {
+		assert.False(seen[s.SessionID])
+		seen[s.SessionID] = true
+		if i > 0 {
+			assert.True(sessions[i-1].SessionID < s.SessionID)
+		}
+		if s.IsCurrent(user) {
+			current++
+		}
+	}
+	assert.Equal(1, current)
+	for i := 0; i < 3; i++ {
+		again, err := user.Sessions(mctx)
+		assert.Nil(err)
+		for j := range again {
+			assert.Equal(sessions[j].SessionID, again[j].SessionID)
+		}
+	}
+}
+
+func TestFailedLoginCount(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	public, _ := x509.MarshalPKIXPublicKey(priv.Public())
+	user, err := CreateUser(mctx, "im.yuqlee@gmail.com", "username", "nickname", "", "password", hex.EncodeToString(public))
+	assert.Nil(err)
+	since := time.Now().Add(-time.Minute)
+	for i := 0; i < 3; i++ {
+		_, err := CreateSession(mctx, "username", "wrong password", hex.EncodeToString(public), false)
+		assert.NotNil(err)
+	}
+	count, err := user.FailedLoginCount(mctx, since)
+	assert.Nil(err)
+	assert.Equal(int64(3), count)
+	count, err = user.FailedLoginCount(mctx, time.Now())
+	assert.Nil(err)
+	assert.Equal(int64(0), count)
+
+	_, err = CreateSession(mctx, "username", "password", hex.EncodeToString(public), false)
+	assert.Nil(err)
+	count, err = user.FailedLoginCount(mctx, since)
+	assert.Nil(err)
+	assert.Equal(int64(0), count)
+	_, err = CreateSession(mctx, "username", "wrong password", hex.EncodeToString(public), false)
+	assert.NotNil(err)
+	count, err = user.FailedLoginCount(mctx, since)
+	assert.Nil(err)
+	assert.Equal(int64(1), count)
+}
+
+func TestDeleteSessionsOlderThan(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	batch := configs.Current().Session.CleanupBatchSize
+	defer func() { configs.Current().Session.CleanupBatchSize = batch }()
+	configs.Current().Session.CleanupBatchSize = 2
+
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	public, _ := x509.MarshalPKIXPublicKey(priv.Public())
+	user, err := CreateUser(mctx, "im.yuqlee@gmail.com", "username", "nickname", "", "password", hex.EncodeToString(public))
+	assert.Nil(err)
+	for i := 0; i < 4; i++ {
+		_, err = CreateSession(mctx, "username", "password", hex.EncodeToString(public), false)
+		assert.Nil(err)
+	}
+	_, err = mctx.database.Exec("UPDATE sessions SET created_at=$1", time.Now().Add(-48*time.Hour))
+	assert.Nil(err)
+	current, err := CreateSession(mctx, "username", "password", hex.EncodeToString(public), false)
+	assert.Nil(err)
+
+	count, err := DeleteSessionsOlderThan(mctx, time.Now().Add(-24*time.Hour))
+	assert.Nil(err)
+	assert.Equal(int64(5), count)
+	sessions, err := user.Sessions(mctx)
+	assert.Nil(err)
+	assert.Len(sessions, 1)
+	assert.Equal(current.SessionID, sessions[0].SessionID)
+}
+
+func TestReadSessionsByIP(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	public, _ := x509.MarshalPKIXPublicKey(priv.Public())
+	suspicious := WrapContext(session.WithRemoteAddress(context.Background(), "203.0.113.7"), mctx.database)
+	user, err := CreateUser(suspicious, "im.yuqlee@gmail.com", "username", "nickname", "", "password", hex.EncodeToString(public))
+	assert.Nil(err)
+	other := WrapContext(session.WithRemoteAddress(context.Background(), "198.51.100.1"), mctx.database)
+	_, err = CreateSession(other, "username", "password", hex.EncodeToString(public), false)
+	assert.Nil(err)
+	admin := &User{AssignedRole: userRoleAdmin}
+
+	sessions, err := ReadSessionsByIP(mctx, admin, "203.0.113.7")
+	assert.Nil(err)
+	assert.Len(sessions, 1)
+	assert.Equal(user.SessionID, sessions[0].SessionID)
+	assert.Equal("203.0.113.7", sessions[0].IP)
+	assert.Equal("", sessions[0].Secret)
+	sessions, err = ReadSessionsByIP(mctx, admin, "192.0.2.1")
+	assert.Nil(err)
+	assert.Len(sessions, 0)
+	_, err = ReadSessionsByIP(mctx, admin, "not an ip")
+	assert.NotNil(err)
+	_, err = ReadSessionsByIP(mctx, user, "203.0.113.7")
+	assert.NotNil(err)
+
+	for _, address := range []string{"2001:DB8:0:0::0:1", "[2001:db8::1]:443"} {
+		ctx := WrapContext(session.WithRemoteAddress(context.Background(), address), mctx.database)
+		_, err = CreateSession(ctx, "username", "password", hex.EncodeToString(public), false)
+		assert.Nil(err)
+	}
+	sessions, err = ReadSessionsByIP(mctx, admin, "2001:db8::0001")
+	assert.Nil(err)
+	assert.Len(sessions, 2)
+	for _, s := range sessions {
+		assert.Equal("2001:db8::1", s.IP)
+	}
+	mapped := WrapContext(session.WithRemoteAddress(context.Background(), "::ffff:203.0.113.7"), mctx.database)
+	_, err = CreateSession(mapped, "username", "password", hex.EncodeToString(public), false)
+	assert.Nil(err)
+	sessions, err = ReadSessionsByIP(mctx, admin, "203.0.113.7")
+	assert.Nil(err)
+	assert.Len(sessions, 2)
+}
+
+func TestAuthenticateUserIssuerAndAudience(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	options := configs.Current().Session
+	defer func() { configs.Current().Session = options }()
+	configs.Current().Session.JWTIssuer = "https://satellity.example.com"
+	configs.Current().Session.JWTAudience = "satellity"
+
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	public, _ := x509.MarshalPKIXPublicKey(priv.Public())
+	user, err := CreateUser(mctx, "im.yuqlee@gmail.com", "username", "nickname", "", "password", hex.EncodeToString(public))
+	assert.Nil(err)
+	for _, tc := range []struct {
+		iss, aud interface{}
+		valid    bool
+	}{
+		{"https://satellity.example.com", "satellity", true},
+		{"https://satellity.example.com", []interface{}{"other", "satellity"}, true},
+		{"https://satellity.example.com", "other", false},
+		{"https://other.example.com", "satellity", false},
+		{nil, nil, false},
+	} {
+		claims := jwt.MapClaims{"uid": user.UserID, "sid": user.SessionID}
+		if tc.iss != nil {
+			claims["iss"], claims["aud"] = tc.iss, tc.aud
+		}
+		ss, err := jwt.NewWithClaims(jwt.SigningMethodES256, claims).SignedString(priv)
+		assert.Nil(err)
+		current, err := AuthenticateUser(mctx, ss)
+		assert.Nil(err)
+		assert.Equal(tc.valid, current != nil)
+	}
+}
+
+func TestDeleteOrphanedSessions(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	user := createTestUser(mctx, "im.yuqlee@gmail.com", "username", "password")
+	assert.NotNil(user)
+	orphan := uuid.Must(uuid.NewV4()).String()
+	_, err := mctx.database.Exec("INSERT INTO sessions(session_id,user_id,secret) VALUES ($1,$2,'secret')", uuid.Must(uuid.NewV4()).String(), orphan)
+	assert.Nil(err)
+
+	count, err := DeleteOrphanedSessions(mctx)
+	assert.Nil(err)
+	assert.Equal(int64(1), count)
+	sessions, err := user.Sessions(mctx)
+	assert.Nil(err)
+	assert.Len(sessions, 1)
+	count, err = DeleteOrphanedSessions(mctx)
+	assert.Nil(err)
+	assert.Equal(int64(0), count)
+}
+
+func TestDummyBcryptHash(t *testing.T) {
+	assert := assert.New(t)
+	if configs.Current() == nil {
+		configs.Set(&configs.Option{})
+		defer func() { configs.Set(nil) }()
+	}
+	cost := configs.Current().System.PasswordCost
+	defer func() { configs.Current().System.PasswordCost = cost }()
+	configs.Current().System.PasswordCost = bcrypt.DefaultCost
+
+	hash := dummyBcryptHash()
+	assert.Equal(hash, dummyBcryptHash())
+	start := time.Now()
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte("password"))
+	assert.Equal(bcrypt.ErrMismatchedHashAndPassword, err)
+	assert.True(time.Since(start) > 5*time.Millisecond)
+
+	configs.Current().System.PasswordCost = bcrypt.MinCost
+	hash = dummyBcryptHash()
+	c, err := bcrypt.Cost([]byte(hash))
+	assert.Nil(err)
+	assert.Equal(bcrypt.MinCost, c)
+	assert.NotNil(bcrypt.CompareHashAndPassword([]byte(hash), []byte("")))
+}
+
+func TestGenerateSessionKeyPair(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	public, private, err := GenerateSessionKeyPair()
+	assert.Nil(err)
+	assert.Nil(ValidateSessionSecret(mctx.context, public))
+	user, err := CreateUser(mctx, "im.yuqlee@gmail.com", "username", "nickname", "", "password", public)
+	assert.Nil(err)
+
+	token, err := SignSessionToken(private, user.UserID, user.SessionID, time.Minute)
+	assert.Nil(err)
+	current, err := AuthenticateUser(mctx, token)
+	assert.Nil(err)
+	assert.NotNil(current)
+	assert.Equal(user.UserID, current.UserID)
+	token, err = SignSessionToken(private, user.UserID, user.SessionID, -time.Minute)
+	assert.Nil(err)
+	current, err = AuthenticateUser(mctx, token)
+	assert.Nil(err)
+	assert.Nil(current)
+	_, err = SignSessionToken(public, user.UserID, user.SessionID, time.Minute)
+	assert.NotNil(err)
+}
+
+func TestSessionDevice(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	ua := "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/78.0.3904.108 Safari/537.36"
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	public, _ := x509.MarshalPKIXPublicKey(priv.Public())
+	browser := WrapContext(session.WithUserAgent(context.Background(), ua), mctx.database)
+	user, err := CreateUser(browser, "im.yuqlee@gmail.com", "username", "nickname", "", "password", hex.EncodeToString(public))
+	assert.Nil(err)
+	_, err = CreateSession(mctx, "username", "password", hex.EncodeToString(public), false)
+	assert.Nil(err)
+
+	sessions, err := user.Sessions(mctx)
+	assert.Nil(err)
+	assert.Len(sessions, 2)
+	devices := make(map[string]*Session)
+	for _, s := range sessions {
+		devices[s.SessionID] = s
+	}
+	s := devices[user.SessionID]
+	assert.NotNil(s)
+	assert.Equal(ua, s.UserAgent.String)
+	assert.Equal("Chrome on Windows", s.DeviceName.String)
+	for id, s := range devices {
+		if id != user.SessionID {
+			assert.False(s.UserAgent.Valid)
+			assert.False(s.DeviceName.Valid)
+		}
+	}
+
+	assert.Equal("Safari on iPhone", DeviceName("Mozilla/5.0 (iPhone; CPU iPhone OS 13_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/13.0.3 Mobile/15E148 Safari/604.1"))
+	assert.Equal("Firefox on Linux", DeviceName("Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:70.0) Gecko/20100101 Firefox/70.0"))
+	assert.Equal("Edge on Windows", DeviceName("Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/79.0.3945.74 Safari/537.36 Edg/79.0.309.43"))
+	assert.Equal("Unknown device", DeviceName("curl/7.64.1"))
+}
+
+func TestAddSessionConflict(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	user := createTestUser(mctx, "im.yuqlee@gmail.com", "username", "password")
+	assert.NotNil(user)
+	generate := newSessionID
+	defer func() { newSessionID = generate }()
+	ids := []string{user.SessionID, uuid.Must(uuid.NewV4()).String()}
+	newSessionID = func() string {
+		id := ids[0]
+		ids = ids[1:]
+		return id
+	}
+
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	public, _ := x509.MarshalPKIXPublicKey(priv.Public())
+	retried, err := CreateSession(mctx, "username", "password", hex.EncodeToString(public), false)
+	assert.Nil(err)
+	assert.NotNil(retried)
+	assert.NotEqual(user.SessionID, retried.SessionID)
+	assert.Len(ids, 0)
+	sessions, err := user.Sessions(mctx)
+	assert.Nil(err)
+	assert.Len(sessions, 2)
+
+	ids = []string{user.SessionID, retried.SessionID}
+	_, err = CreateSession(mctx, "username", "password", hex.EncodeToString(public), false)
+	assert.NotNil(err)
+}
+
+func TestCreateSessionWithoutPassword(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	user := createTestUser(mctx, "im.yuqlee@gmail.com", "username", "password")
+	assert.NotNil(user)
+	_, err := mctx.database.Exec("UPDATE users SET encrypted_password=NULL WHERE user_id=$1", user.UserID)
+	assert.Nil(err)
+
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	public, _ := x509.MarshalPKIXPublicKey(priv.Public())
+	for _, password := range []string{"password", ""} {
+		_, err = CreateSession(mctx, "username", password, hex.EncodeToString(public), false)
+		assert.True(errors.Is(err, session.PasswordNotSetError(mctx.context)))
+	}
+	count, err := user.FailedLoginCount(mctx, time.Now().Add(-time.Hour))
+	assert.Nil(err)
+	assert.Equal(int64(0), count)
+}
+
+func TestDedupeSessionsByDevice(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	chrome := WrapContext(session.WithUserAgent(context.Background(), "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/78.0.3904.108 Safari/537.36"), mctx.database)
+	firefox := WrapContext(session.WithUserAgent(context.Background(), "Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:70.0) Gecko/20100101 Firefox/70.0"), mctx.database)
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	public, _ := x509.MarshalPKIXPublicKey(priv.Public())
+	user, err := CreateUser(chrome, "im.yuqlee@gmail.com", "username", "nickname", "", "password", hex.EncodeToString(public))
+	assert.Nil(err)
+	newest, err := CreateSession(chrome, "username", "password", hex.EncodeToString(public), false)
+	assert.Nil(err)
+	other, err := CreateSession(firefox, "username", "password", hex.EncodeToString(public), false)
+	assert.Nil(err)
+	_, err = mctx.database.Exec("UPDATE sessions SET created_at=$1 WHERE session_id=$2", time.Now().Add(-time.Hour), user.SessionID)
+	assert.Nil(err)
+
+	count, err := user.DedupeSessionsByDevice(mctx)
+	assert.Nil(err)
+	assert.Equal(int64(1), count)
+	sessions, err := user.Sessions(mctx)
+	assert.Nil(err)
+	assert.Len(sessions, 2)
+	ids := map[string]bool{}
+	for _, s := range sessions {
+		ids[s.SessionID] = true
+	}
+	assert.True(ids[newest.SessionID])
+	assert.True(ids[other.SessionID])
+	assert.False(ids[user.SessionID])
+
+	count, err = user.DedupeSessionsByDevice(mctx)
+	assert.Nil(err)
+	assert.Equal(int64(0), count)
+}
+
+func TestSessionRelogin(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	options := configs.Current().Session
+	defer func() { configs.Current().Session = options }()
+	browser := WrapContext(session.WithUserAgent(context.Background(), "Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:70.0) Gecko/20100101 Firefox/70.0"), mctx.database)
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	public, _ := x509.MarshalPKIXPublicKey(priv.Public())
+	user, err := CreateUser(browser, "im.yuqlee@gmail.com", "username", "nickname", "", "password", hex.EncodeToString(public))
+	assert.Nil(err)
+
+	configs.Current().Session.Relogin = sessionReloginNew
+	again, err := CreateSession(browser, "username", "password", hex.EncodeToString(public), false)
+	assert.Nil(err)
+	assert.NotEqual(user.SessionID, again.SessionID)
+	sessions, err := user.Sessions(mctx)
+	assert.Nil(err)
+	assert.Len(sessions, 2)
+
+	configs.Current().Session.Relogin = sessionReloginReuse
+	rotated, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rotatedPublic, _ := x509.MarshalPKIXPublicKey(rotated.Public())
+	clock := &fakeClock{now: time.Now().Add(time.Hour).Truncate(time.Second)}
+	reused, err := CreateSession(browser.WithClock(clock), "username", "password", hex.EncodeToString(rotatedPublic), false)
+	assert.Nil(err)
+	assert.Equal(again.SessionID, reused.SessionID)
+	sessions, err = user.Sessions(mctx)
+	assert.Nil(err)
+	assert.Len(sessions, 2)
+	s, err := readTestSession(mctx, user.UserID, reused.SessionID)
+	assert.Nil(err)
+	assert.Equal(hex.EncodeToString(rotatedPublic), s.Secret)
+	assert.Equal(SessionSecretHash(hex.EncodeToString(rotatedPublic)), s.SecretHash)
+	assert.True(s.CreatedAt.Equal(clock.now))
+
+	fresh, err := CreateSession(mctx, "username", "password", hex.EncodeToString(public), false)
+	assert.Nil(err)
+	assert.NotEqual(reused.SessionID, fresh.SessionID)
+	sessions, err = user.Sessions(mctx)
+	assert.Nil(err)
+	assert.Len(sessions, 3)
+
+	// the same browser from another address is another device
+	elsewhere := WrapContext(session.WithRemoteAddress(browser.context, "203.0.113.7"), mctx.database)
+	moved, err := CreateSession(elsewhere, "username", "password", hex.EncodeToString(public), false)
+	assert.Nil(err)
+	assert.NotEqual(reused.SessionID, moved.SessionID)
+	sessions, err = user.Sessions(mctx)
+	assert.Nil(err)
+	assert.Len(sessions, 4)
+}
+
+func TestSessionRememberMe(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	durations := configs.Current().Durations
+	defer func() { configs.Current().Durations = durations }()
+	configs.Current().Durations.SessionCacheTTL = 0
+	configs.Current().Durations.SessionShortTTL = time.Hour
+	configs.Current().Durations.SessionLongTTL = 30 * 24 * time.Hour
+
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	public, _ := x509.MarshalPKIXPublicKey(priv.Public())
+	user := createTestUser(mctx, "im.yuqlee@gmail.com", "username", "password")
+	assert.NotNil(user)
+	short, err := CreateSession(mctx, "username", "password", hex.EncodeToString(public), false)
+	assert.Nil(err)
+	long, err := CreateSession(mctx, "username", "password", hex.EncodeToString(public), true)
+	assert.Nil(err)
+
+	shortSession, err := readTestSession(mctx, user.UserID, short.SessionID)
+	assert.Nil(err)
+	longSession, err := readTestSession(mctx, user.UserID, long.SessionID)
+	assert.Nil(err)
+	assert.True(shortSession.ExpiresAt.Valid)
+	assert.True(longSession.ExpiresAt.Valid)
+	assert.Equal(time.Hour, shortSession.ExpiresAt.Time.Sub(shortSession.CreatedAt))
+	assert.Equal(30*24*time.Hour, longSession.ExpiresAt.Time.Sub(longSession.CreatedAt))
+
+	tokens := make(map[string]string)
+	for _, u := range []*User{short, long} {
+		claims := &jwt.MapClaims{"uid": u.UserID, "sid": u.SessionID}
+		tokens[u.SessionID], err = jwt.NewWithClaims(jwt.SigningMethodES256, claims).SignedString(priv)
+		assert.Nil(err)
+		current, err := AuthenticateUser(mctx, tokens[u.SessionID])
+		assert.Nil(err)
+		assert.NotNil(current)
+	}
+
+	clock := &fakeClock{now: time.Now().Add(2 * time.Hour)}
+	later := mctx.WithClock(clock)
+	current, err := AuthenticateUser(later, tokens[short.SessionID])
+	assert.Nil(err)
+	assert.Nil(current)
+	current, err = AuthenticateUser(later, tokens[long.SessionID])
+	assert.Nil(err)
+	assert.NotNil(current)
+	clock.now = time.Now().Add(31 * 24 * time.Hour)
+	current, err = AuthenticateUser(later, tokens[long.SessionID])
+	assert.Nil(err)
+	assert.Nil(current)
+	assert.Equal(time.Hour, SessionTTL(false))
+}
+
+func TestSessionClock(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: now}
+	user := createTestUser(mctx.WithClock(clock), "im.yuqlee@gmail.com", "username", "password")
+	assert.NotNil(user)
+	assert.True(now.Equal(user.CreatedAt))
+	s, err := ReadSession(mctx, user.UserID, user.SessionID)
+	assert.Nil(err)
+	assert.True(now.Equal(s.CreatedAt))
+	assert.True(now.Equal(s.LastSeenAt))
+	assert.True(sessionExpiresAt(now, false).Time.Equal(s.ExpiresAt.Time))
+}
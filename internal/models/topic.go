@@ -74,6 +74,9 @@ type Topic struct {
 //CreateTopic create a new Topic
 func (user *User) CreateTopic(mctx *Context, title, body, categoryID string, draft bool) (*Topic, error) {
 	ctx := mctx.context
+	if err := checkWritable(ctx); err != nil {
+		return nil, err
+	}
 
 	if draft {
 		t, err := user.DraftTopic(mctx)
@@ -107,6 +110,9 @@ func (user *User) CreateTopic(mctx *Context, title, body, categoryID string, dra
 	}
 
 	err = mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
+		if err := checkRateLimit(ctx, tx, user, "topics", topicsRateLimit(), topicsRateWindow, mctx.now()); err != nil {
+			return err
+		}
 		category, err := findCategory(ctx, tx, categoryID)
 		if err != nil {
 			return err
@@ -141,6 +147,9 @@ func (user *User) CreateTopic(mctx *Context, title, body, categoryID string, dra
 // UpdateTopic update a Topic by ID
 func (user *User) UpdateTopic(mctx *Context, id, title, body, categoryID string, draft bool) (*Topic, error) {
 	ctx := mctx.context
+	if err := checkWritable(ctx); err != nil {
+		return nil, err
+	}
 	title, body = strings.TrimSpace(title), strings.TrimSpace(body)
 	if title != "" && len(title) < minTitleSize {
 		return nil, session.BadDataError(ctx)
@@ -156,7 +165,7 @@ func (user *User) UpdateTopic(mctx *Context, id, title, body, categoryID string,
 			return err
 		} else if topic == nil {
 			return nil
-		} else if topic.UserID != user.UserID && !user.isAdmin() {
+		} else if !CanAct(user, ActionEdit, topic.UserID) {
 			return session.AuthorizationError(ctx)
 		}
 		prevDraft = topic.Draft
@@ -182,6 +191,10 @@ func (user *User) UpdateTopic(mctx *Context, id, title, body, categoryID string,
 		cols, params := durable.PrepareColumnsWithValues([]string{"title", "body", "category_id", "draft"})
 		vals := []interface{}{topic.Title, topic.Body, topic.CategoryID, topic.Draft}
 		_, err = tx.ExecContext(ctx, fmt.Sprintf("UPDATE topics SET (%s)=(%s) WHERE topic_id='%s'", cols, params, topic.TopicID), vals...)
+		if err != nil {
+			return err
+		}
+		topic.UpdatedAt, err = touchUpdatedAt(ctx, tx, "topics", "topic_id", topic.TopicID, mctx.now())
 		return err
 	})
 	if err != nil {
@@ -247,6 +260,9 @@ func ReadTopic(mctx *Context, id string) (*Topic, error) {
 	if err != nil {
 		return nil, session.TransactionError(ctx, err)
 	}
+	if topic == nil {
+		return nil, session.NotFoundError(ctx)
+	}
 	return topic, nil
 }
 
@@ -278,8 +294,8 @@ func (user *User) DraftTopic(mctx *Context) (*Topic, error) {
 func ReadTopicWithRelation(mctx *Context, id string, user *User) (*Topic, error) {
 	ctx := mctx.context
 	topic, err := ReadTopic(mctx, id)
-	if err != nil || topic == nil {
-		return topic, err
+	if err != nil {
+		return nil, err
 	}
 	err = fillTopicWithAction(mctx, topic, user)
 	if err != nil {
@@ -303,11 +319,11 @@ func findTopic(ctx context.Context, tx *sql.Tx, id string) (*Topic, error) {
 // ReadTopicByShortID read a topic by Short ID
 func ReadTopicByShortID(mctx *Context, id string) (*Topic, error) {
 	subs := strings.Split(id, "-")
+	ctx := mctx.context
 	if len(subs) < 1 || len(subs[0]) <= 5 {
-		return nil, nil
+		return nil, session.NotFoundError(ctx)
 	}
 	id = subs[0]
-	ctx := mctx.context
 	var topic *Topic
 	err := mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
 		var err error
@@ -330,6 +346,9 @@ func ReadTopicByShortID(mctx *Context, id string) (*Topic, error) {
 	if err != nil {
 		return nil, session.TransactionError(ctx, err)
 	}
+	if topic == nil {
+		return nil, session.NotFoundError(ctx)
+	}
 	return topic, nil
 }
 
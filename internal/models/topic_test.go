@@ -1,7 +1,9 @@
 package models
 
 import (
+	"errors"
 	"fmt"
+	"satellity/internal/session"
 	"testing"
 	"time"
 
@@ -63,7 +65,7 @@ func TestTopicCRUD(t *testing.T) {
 			assert.Equal(tc.bookmarksCount, topic.BookmarksCount)
 			assert.Equal(tc.likesCount, topic.LikesCount)
 			new, err := ReadTopic(ctx, uuid.Must(uuid.NewV4()).String())
-			assert.Nil(err)
+			assert.True(errors.Is(err, session.ErrNotFound))
 			assert.Nil(new)
 			new, err = ReadTopicByShortID(ctx, topic.ShortID)
 			assert.Nil(err)
@@ -71,7 +73,7 @@ func TestTopicCRUD(t *testing.T) {
 			assert.Equal(tc.title, new.Title)
 			assert.Equal(tc.body, new.Body)
 			new, err = ReadTopicByShortID(ctx, "xyz")
-			assert.Nil(err)
+			assert.True(errors.Is(err, session.ErrNotFound))
 			assert.Nil(new)
 			topics, err := ReadTopics(ctx, time.Time{})
 			assert.Nil(err)
@@ -53,6 +53,9 @@ func (tu *TopicUser) values() []interface{} {
 // ActiondBy execute user action, like or bookmark a topic
 func (topic *Topic) ActiondBy(mctx *Context, user *User, action string, state bool) (*Topic, error) {
 	ctx := mctx.context
+	if err := checkWritable(ctx); err != nil {
+		return nil, err
+	}
 	if action != TopicUserActionLiked &&
 		action != TopicUserActionBookmarked {
 		return topic, session.BadDataError(ctx)
@@ -2,25 +2,40 @@ package models
 
 import (
 	"context"
-	"crypto/ecdsa"
+	"crypto/md5"
+	"crypto/rand"
 	"crypto/x509"
 	"database/sql"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"satellity/internal/configs"
 	"satellity/internal/durable"
 	"satellity/internal/session"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/gofrs/uuid"
+	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 )
 
 const (
-	userRoleAdmin  = "admin"
-	userRoleMember = "member"
+	userRoleAdmin     = "admin"
+	userRoleModerator = "moderator"
+	userRoleMember    = "member"
+)
+
+// User profile limits, counted in characters (runes) as VARCHAR does
+const (
+	MaximumNicknameSize    = 64
+	MaximumDisplayNameSize = 128
+	MaximumBiographySize   = 2048
 )
 
 const usersDDL = `
@@ -29,17 +44,28 @@ CREATE TABLE IF NOT EXISTS users (
 	email                  VARCHAR(512),
 	username               VARCHAR(64) NOT NULL CHECK (username ~* '^[a-z0-9][a-z0-9_]{3,63}$'),
 	nickname               VARCHAR(64) NOT NULL DEFAULT '',
+	display_name           VARCHAR(128),
 	biography              VARCHAR(2048) NOT NULL DEFAULT '',
 	encrypted_password     VARCHAR(1024),
 	github_id              VARCHAR(1024) UNIQUE,
 	groups_count           BIGINT NOT NULL DEFAULT 0,
+	role                   VARCHAR(32) NOT NULL DEFAULT 'member',
+	email_verified_at      TIMESTAMP WITH TIME ZONE,
+	profile_locked         BOOLEAN NOT NULL DEFAULT false,
+	profile_updated_at     TIMESTAMP WITH TIME ZONE,
 	created_at             TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
 	updated_at             TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
 );
 
 CREATE UNIQUE INDEX IF NOT EXISTS users_emailx ON users ((LOWER(email)));
 CREATE UNIQUE INDEX IF NOT EXISTS users_usernamex ON users ((LOWER(username)));
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_exactx ON users (username);
 CREATE INDEX IF NOT EXISTS users_createdx ON users (created_at);
+CREATE INDEX IF NOT EXISTS users_created_userx ON users (created_at, user_id);
+CREATE INDEX IF NOT EXISTS users_username_patternx ON users ((LOWER(username)) text_pattern_ops);
+CREATE INDEX IF NOT EXISTS users_username_c_userx ON users ((LOWER(username) COLLATE "C"), user_id);
+CREATE INDEX IF NOT EXISTS users_updated_userx ON users (updated_at, user_id);
+CREATE INDEX IF NOT EXISTS users_username_skeletonx ON users ((replace(replace(translate(LOWER(username), '01i', 'oll'), 'rn', 'm'), 'vv', 'w')));
 `
 
 // User contains info of a register user
@@ -48,82 +74,107 @@ type User struct {
 	Email             sql.NullString
 	Username          string
 	Nickname          string
+	DisplayName       sql.NullString
 	Biography         string
 	EncryptedPassword sql.NullString
 	GithubID          sql.NullString
 	GroupsCount       int64
+	AssignedRole      string
+	EmailVerifiedAt   pq.NullTime
+	ProfileLocked     bool
 	CreatedAt         time.Time
 	UpdatedAt         time.Time
 
-	SessionID string
-	isNew     bool
+	SessionID    string
+	isNew        bool
+	githubLinked bool
+	githubLogin  string
 }
 
-var userColumns = []string{"user_id", "email", "username", "nickname", "biography", "encrypted_password", "github_id", "groups_count", "created_at", "updated_at"}
+var userColumns = []string{"user_id", "email", "username", "nickname", "display_name", "biography", "encrypted_password", "github_id", "groups_count", "role", "email_verified_at", "profile_locked", "created_at", "updated_at"}
 
 func (u *User) values() []interface{} {
-	return []interface{}{u.UserID, u.Email, u.Username, u.Nickname, u.Biography, u.EncryptedPassword, u.GithubID, u.GroupsCount, u.CreatedAt, u.UpdatedAt}
+	return []interface{}{u.UserID, u.Email, u.Username, u.Nickname, u.DisplayName, u.Biography, u.EncryptedPassword, u.GithubID, u.GroupsCount, u.AssignedRole, u.EmailVerifiedAt, u.ProfileLocked, u.CreatedAt, u.UpdatedAt}
 }
 
 func userFromRows(row durable.Row) (*User, error) {
 	var u User
-	err := row.Scan(&u.UserID, &u.Email, &u.Username, &u.Nickname, &u.Biography, &u.EncryptedPassword, &u.GithubID, &u.GroupsCount, &u.CreatedAt, &u.UpdatedAt)
+	err := row.Scan(&u.UserID, &u.Email, &u.Username, &u.Nickname, &u.DisplayName, &u.Biography, &u.EncryptedPassword, &u.GithubID, &u.GroupsCount, &u.AssignedRole, &u.EmailVerifiedAt, &u.ProfileLocked, &u.CreatedAt, &u.UpdatedAt)
 	return &u, err
 }
 
-// CreateUser create a new user
+// CreateUser create a new user and its first session in one transaction, the
+// returned user always has SessionID of the session. Tokens are minted by the
+// client, signed by the private key of sessionSecret with the uid and sid
+// claims, so there's nothing to mint here. The email could be blank if
+// system.email_required is false, the user signs in by username then.
 func CreateUser(mctx *Context, email, username, nickname, biography, password string, sessionSecret string) (*User, error) {
 	ctx := mctx.context
-	data, err := hex.DecodeString(sessionSecret)
-	if err != nil {
-		return nil, session.BadDataError(ctx)
-	}
-	public, err := x509.ParsePKIXPublicKey(data)
-	if err != nil {
-		return nil, session.BadDataError(ctx)
+	if err := checkWritable(ctx); err != nil {
+		return nil, err
 	}
-	switch public.(type) {
-	case *ecdsa.PublicKey:
-	default:
-		return nil, session.BadDataError(ctx)
+	if err := ValidateSessionSecret(ctx, sessionSecret); err != nil {
+		return nil, err
 	}
 
-	email = strings.TrimSpace(email)
-	if err := validateEmailFormat(ctx, email); err != nil {
-		return nil, err
+	email = normalizeString(email)
+	if email != "" || emailRequired() {
+		if err := validateEmailFormat(ctx, email); err != nil {
+			return nil, err
+		}
 	}
-	username = strings.TrimSpace(username)
-	if len(username) < 3 {
+	username = normalizeString(username)
+	if !usernameRegexp().MatchString(username) {
 		return nil, session.BadDataError(ctx)
 	}
-	nickname = strings.TrimSpace(nickname)
+	nickname = normalizeNickname(nickname)
 	if nickname == "" {
 		nickname = username
+		if configs.Current().System.GenerateNickname {
+			nickname = NicknameGenerator()
+		}
 	}
-	password, err = validateAndEncryptPassword(ctx, password)
+	biography = sanitizeBiography(normalizeString(biography))
+	if !validateProfileFields(nickname, biography) {
+		return nil, session.BadDataError(ctx)
+	}
+	password, err := validateAndEncryptPassword(ctx, password)
 	if err != nil {
 		return nil, err
 	}
 
-	t := time.Now()
+	t := mctx.now()
 	user := &User{
 		UserID:            uuid.Must(uuid.NewV4()).String(),
-		Email:             sql.NullString{String: email, Valid: true},
+		Email:             sql.NullString{String: email, Valid: email != ""},
 		Username:          username,
 		Nickname:          nickname,
 		Biography:         biography,
 		EncryptedPassword: sql.NullString{String: password, Valid: true},
+		AssignedRole:      userRoleMember,
 		CreatedAt:         t,
 		UpdatedAt:         t,
 	}
 
 	err = mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
+		if err := checkNicknameImpersonation(ctx, tx, user.Nickname, user.UserID); err != nil {
+			return err
+		}
+		if err := checkUsernameReserved(ctx, tx, user.Username, mctx.now()); err != nil {
+			return err
+		}
+		if err := checkConfusableUsername(ctx, tx, user.Username, user.UserID); err != nil {
+			return err
+		}
+		if err := bootstrapFirstAdmin(ctx, tx, user); err != nil {
+			return err
+		}
 		cols, params := durable.PrepareColumnsWithValues(userColumns)
 		_, err := tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO users(%s) VALUES (%s)", cols, params), user.values()...)
 		if err != nil {
 			return err
 		}
-		s, err := user.addSession(ctx, tx, sessionSecret)
+		s, err := user.addSession(ctx, tx, sessionSecret, false, mctx.now())
 		if err != nil {
 			return err
 		}
@@ -136,36 +187,168 @@ func CreateUser(mctx *Context, email, username, nickname, biography, password st
 		}
 		return nil, session.TransactionError(ctx, err)
 	}
+	mctx.userCreated(user)
 	return user, nil
 }
 
-// UpdateProfile update user's profile
-func (u *User) UpdateProfile(mctx *Context, nickname, biography string) error {
+// UpdateProfile update user's profile, blank fields are unchanged, it's a no-op
+// if all of them are blank unless system.reject_empty_profile_update is on.
+func (u *User) UpdateProfile(mctx *Context, nickname, displayName, biography string) error {
+	return u.UpdateProfileAs(mctx, u, nickname, displayName, biography)
+}
+
+// UpdateProfileAs is UpdateProfile by the actor, the user self or an admin,
+// only admins could update a locked profile. Users are refused with
+// TooManyRequestsError within system.profile_update_cooldown of the last
+// update, admins are exempt. The changed fields are recorded in profile_audits
+// in the same transaction.
+func (u *User) UpdateProfileAs(mctx *Context, actor *User, nickname, displayName, biography string) error {
 	ctx := mctx.context
-	nickname, biography = strings.TrimSpace(nickname), strings.TrimSpace(biography)
-	if len(nickname) == 0 && len(biography) == 0 {
+	if err := checkWritable(ctx); err != nil {
+		return err
+	}
+	if actor == nil || (actor.UserID != u.UserID && !actor.isAdmin()) {
+		return session.ForbiddenError(ctx)
+	}
+	if u.ProfileLocked && !actor.isAdmin() {
+		return session.ProfileLockedError(ctx)
+	}
+	nickname, biography = normalizeNickname(nickname), normalizeString(biography)
+	displayName = normalizeNickname(displayName)
+	if len(nickname) == 0 && len(displayName) == 0 && len(biography) == 0 {
+		if config := configs.Current(); config != nil && config.System.RejectEmptyProfileUpdate {
+			return session.BadDataError(ctx)
+		}
 		return nil
 	}
+	if biography != "" {
+		biography = sanitizeBiography(biography)
+	}
+	if !validateProfileFields(nickname, biography) || !validateDisplayName(displayName) {
+		return session.BadDataError(ctx)
+	}
+	var changes []FieldChange
+	if nickname != "" && nickname != u.Nickname {
+		changes = append(changes, FieldChange{Field: "nickname", Old: u.Nickname, New: nickname})
+	}
+	if displayName != "" && displayName != u.DisplayName.String {
+		changes = append(changes, FieldChange{Field: "display_name", Old: u.DisplayName.String, New: displayName})
+	}
+	if biography != "" && biography != u.Biography {
+		changes = append(changes, FieldChange{Field: "biography", Old: u.Biography, New: biography})
+	}
+	updated := *u
 	if nickname != "" {
-		u.Nickname = nickname
+		updated.Nickname = nickname
+	}
+	if displayName != "" {
+		updated.DisplayName = sql.NullString{String: displayName, Valid: true}
 	}
 	if biography != "" {
-		u.Biography = biography
+		updated.Biography = biography
+	}
+	now := mctx.now()
+	cols, params := durable.PrepareColumnsWithValuesOffset([]string{"nickname", "display_name", "biography", "profile_updated_at"}, 1)
+	args := []interface{}{u.UserID, updated.Nickname, updated.DisplayName, updated.Biography, now}
+	condition := "user_id=$1"
+	if !actor.isAdmin() {
+		condition += " AND NOT profile_locked"
+		if cooldown := profileUpdateCooldown(); cooldown > 0 {
+			args = append(args, now.Add(-cooldown))
+			condition += fmt.Sprintf(" AND (profile_updated_at IS NULL OR profile_updated_at<=$%d)", len(args))
+		}
+	}
+	err := mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
+		if nickname != "" {
+			if err := checkNicknameImpersonation(ctx, tx, nickname, u.UserID); err != nil {
+				return err
+			}
+		}
+		result, err := tx.ExecContext(ctx, fmt.Sprintf("UPDATE users SET (%s)=(%s) WHERE %s", cols, params, condition), args...)
+		if err != nil {
+			return err
+		}
+		if count, err := result.RowsAffected(); err != nil {
+			return err
+		} else if count == 0 {
+			var locked bool
+			err := tx.QueryRowContext(ctx, "SELECT profile_locked FROM users WHERE user_id=$1", u.UserID).Scan(&locked)
+			if err == sql.ErrNoRows {
+				return session.NotFoundError(ctx)
+			} else if err != nil {
+				return err
+			}
+			if locked {
+				return session.ProfileLockedError(ctx)
+			}
+			return session.TooManyRequestsError(ctx)
+		}
+		updated.UpdatedAt, err = touchUpdatedAt(ctx, tx, "users", "user_id", u.UserID, now)
+		if err != nil {
+			return err
+		}
+		return writeProfileAudits(ctx, tx, u.UserID, actor.UserID, changes, now)
+	})
+	if err != nil {
+		if _, ok := err.(session.Error); ok {
+			return err
+		}
+		return session.TransactionError(ctx, err)
 	}
-	u.UpdatedAt = time.Now()
-	cols, params := durable.PrepareColumnsWithValues([]string{"nickname", "biography", "updated_at"})
-	_, err := mctx.database.ExecContext(ctx, fmt.Sprintf("UPDATE users SET (%s)=(%s) WHERE user_id='%s'", cols, params, u.UserID), u.Nickname, u.Biography, u.UpdatedAt)
+	*u = updated
+	authenticatedSessions.invalidateUser(u.UserID)
+	mctx.userUpdated(u, changes)
+	return nil
+}
+
+func profileUpdateCooldown() time.Duration {
+	if configs.Current() == nil {
+		return 0
+	}
+	return configs.Current().Durations.ProfileUpdateCooldown
+}
+
+// SetProfileLocked freezes or unfreezes the profile of the user, e.g. of a
+// spammer, admin only.
+func (u *User) SetProfileLocked(mctx *Context, actor *User, locked bool) error {
+	ctx := mctx.context
+	if err := checkWritable(ctx); err != nil {
+		return err
+	}
+	if actor == nil || !actor.isAdmin() {
+		return session.ForbiddenError(ctx)
+	}
+	var updatedAt time.Time
+	err := mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "UPDATE users SET profile_locked=$1 WHERE user_id=$2", locked, u.UserID)
+		if err != nil {
+			return err
+		}
+		updatedAt, err = touchUpdatedAt(ctx, tx, "users", "user_id", u.UserID, mctx.now())
+		return err
+	})
 	if err != nil {
 		return session.TransactionError(ctx, err)
 	}
+	var changes []FieldChange
+	if u.ProfileLocked != locked {
+		changes = append(changes, FieldChange{Field: "profile_locked", Old: strconv.FormatBool(u.ProfileLocked), New: strconv.FormatBool(locked)})
+	}
+	u.ProfileLocked, u.UpdatedAt = locked, updatedAt
+	authenticatedSessions.invalidateUser(u.UserID)
+	mctx.userUpdated(u, changes)
 	return nil
 }
 
 // AuthenticateUser read a user by tokenString. tokenString is a jwt token, more
-// about jwt: https://github.com/dgrijalva/jwt-go
+// about jwt: https://github.com/dgrijalva/jwt-go, a token with kid header is
+// verified by the key of session.keys instead of the session secret.
 func AuthenticateUser(mctx *Context, tokenString string) (*User, error) {
 	ctx := mctx.context
 	var user *User
+	var secret string
+	var expiresAt pq.NullTime
+	var cached bool
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		claims, ok := token.Claims.(jwt.MapClaims)
 		if !ok {
@@ -174,32 +357,50 @@ func AuthenticateUser(mctx *Context, tokenString string) (*User, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodECDSA); !ok {
 			return nil, nil
 		}
+		if !validTokenIssuerAndAudience(claims) {
+			return nil, nil
+		}
 		uid, sid := fmt.Sprint(claims["uid"]), fmt.Sprint(claims["sid"])
-		var s *Session
-		err := mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
-			u, err := findUserByID(ctx, tx, uid)
-			if err != nil {
-				return err
-			} else if u == nil {
+		if user, secret = authenticatedSessions.get(uid, sid, mctx.now()); user != nil {
+			cached = true
+		} else {
+			var s *Session
+			err := mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
+				u, err := findUserByID(ctx, tx, uid)
+				if err != nil {
+					return err
+				} else if u == nil {
+					return nil
+				}
+				user = u
+				s, err = readSession(ctx, tx, uid, sid)
+				if errors.Is(err, session.ErrNotFound) {
+					s = nil
+					return nil
+				} else if err != nil {
+					return err
+				} else if s.Expired(mctx.now()) {
+					s = nil
+					return nil
+				}
+				user.SessionID = s.SessionID
 				return nil
-			}
-			user = u
-			s, err = readSession(ctx, tx, uid, sid)
+			})
 			if err != nil {
-				return err
-			} else if s == nil {
-				return nil
+				if _, ok := err.(session.Error); ok {
+					return nil, err
+				}
+				return nil, session.TransactionError(ctx, err)
 			}
-			user.SessionID = s.SessionID
-			return nil
-		})
-		if err != nil {
-			if _, ok := err.(session.Error); ok {
-				return nil, err
+			if s == nil {
+				return nil, nil
 			}
-			return nil, session.TransactionError(ctx, err)
+			secret, expiresAt = s.Secret, s.ExpiresAt
 		}
-		pkix, err := hex.DecodeString(s.Secret)
+		if kid, ok := token.Header["kid"]; ok {
+			return signingKey(fmt.Sprint(kid))
+		}
+		pkix, err := hex.DecodeString(secret)
 		if err != nil {
 			return nil, err
 		}
@@ -208,39 +409,204 @@ func AuthenticateUser(mctx *Context, tokenString string) (*User, error) {
 	if err != nil || !token.Valid {
 		return nil, nil
 	}
+	if !cached {
+		authenticatedSessions.set(user, secret, expiresAt, mctx.now())
+		if err := touchSession(mctx, user.UserID, user.SessionID); err != nil {
+			if logger := session.Logger(ctx); logger != nil {
+				logger.Errorf("touchSession %s: %v", user.SessionID, err)
+			}
+		}
+	}
 	return user, nil
 }
 
-// ReadUsers read users by offset
+// validTokenIssuerAndAudience checks the iss and aud claims against
+// session.jwt_issuer and session.jwt_audience, a blank config skips its check.
+func validTokenIssuerAndAudience(claims jwt.MapClaims) bool {
+	config := configs.Current()
+	if config == nil {
+		return true
+	}
+	if iss := config.Session.JWTIssuer; iss != "" && claims["iss"] != iss {
+		return false
+	}
+	aud := config.Session.JWTAudience
+	if aud == "" {
+		return true
+	}
+	switch v := claims["aud"].(type) {
+	case string:
+		return v == aud
+	case []interface{}:
+		for _, a := range v {
+			if a == aud {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// usersOrderFields are the columns users could be sorted by
+var usersOrderFields = map[string]string{"created_at": "created_at"}
+
+// ReadUsers read users by offset, newest first, as an anonymous caller of
+// ReadUsersPageAs, so it's limited by system.users_list_max_depth too.
+//
+// Deprecated: use ReadUsersPage, which tells whether there are more users.
 func ReadUsers(mctx *Context, offset time.Time) ([]*User, error) {
+	if err := checkUsersListDepth(mctx, nil, offset, "", "created_at DESC"); err != nil {
+		return nil, err
+	}
+	page, err := readUsersPage(mctx, offset, "", "created_at DESC", 100)
+	if err != nil {
+		return nil, err
+	}
+	return page.Items, nil
+}
+
+// ReadUsersPage read users sorted by sort, "-created_at" (default) or
+// "created_at", cursor is the NextCursor of the previous page, empty for the
+// first page. The caller is anonymous, see ReadUsersPageAs.
+func ReadUsersPage(mctx *Context, cursor, sort string, limit int) (*Page[*User], error) {
+	return ReadUsersPageAs(mctx, nil, cursor, sort, limit)
+}
+
+// ReadUsersPageAs is ReadUsersPage by the actor, nil if not signed in.
+// Anonymous callers could skip at most system.users_list_max_depth users by
+// the cursor, deeper cursors are AuthorizationError, so walking all users
+// requires signing in. 0 is unlimited.
+func ReadUsersPageAs(mctx *Context, actor *User, cursor, sort string, limit int) (*Page[*User], error) {
+	ctx := mctx.context
+	if sort == "" {
+		sort = "-created_at"
+	}
+	orderBy, err := safeOrderBy(sort, usersOrderFields)
+	if err != nil {
+		return nil, session.BadDataError(ctx)
+	}
+	offset, id, err := decodeCursor(cursor, mctx.now())
+	if err != nil {
+		return nil, session.InvalidCursorError(ctx)
+	}
+	if limit < 1 || limit > 100 {
+		limit = 100
+	}
+	if err := checkUsersListDepth(mctx, actor, offset, id, orderBy); err != nil {
+		return nil, err
+	}
+	return readUsersPage(mctx, offset, id, orderBy, limit)
+}
+
+func usersListMaxDepth() int {
+	if configs.Current() == nil {
+		return 0
+	}
+	return configs.Current().System.UsersListMaxDepth
+}
+
+// checkUsersListDepth counts the users before the offset of the cursor, the
+// count stops at the max depth, so it's cheap on users_created_userx. The depth
+// is counted rather than carried in the cursor, which the client could forge.
+func checkUsersListDepth(mctx *Context, actor *User, offset time.Time, id, orderBy string) error {
+	ctx := mctx.context
+	max := usersListMaxDepth()
+	if max <= 0 || actor != nil || offset.IsZero() {
+		return nil
+	}
+	condition := "(created_at,user_id)<=($1,$2)"
+	if strings.HasSuffix(orderBy, " DESC") {
+		condition = "(created_at,user_id)>=($1,$2)"
+	}
+	var depth int
+	query := fmt.Sprintf("SELECT count(*) FROM (SELECT 1 FROM users WHERE %s LIMIT $3) d", condition)
+	row, err := mctx.database.QueryRowContext(ctx, query, offset, id, max+1)
+	if err != nil {
+		return session.TransactionError(ctx, err)
+	}
+	if err := row.Scan(&depth); err != nil {
+		return session.TransactionError(ctx, err)
+	}
+	if depth > max {
+		return session.AuthorizationError(ctx)
+	}
+	return nil
+}
+
+// SearchUsers read users whose username starts with query, case insensitive,
+// ordered by username and user_id, so pages are stable under concurrent
+// signups. Both the LIKE prefix and the order compare LOWER(username) in the
+// "C" collation, so they are served by users_username_c_userx, the
+// text_pattern_ops index can't serve the ORDER BY.
+func SearchUsers(mctx *Context, query, cursor string, limit int) (*Page[*User], error) {
 	ctx := mctx.context
-	if offset.IsZero() {
-		offset = time.Now()
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return &Page[*User]{}, nil
 	}
-	rows, err := mctx.database.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM users WHERE created_at<$1 ORDER BY created_at DESC LIMIT 100", strings.Join(userColumns, ",")), offset)
+	username, id, err := decodeKeyCursor(cursor)
+	if err != nil {
+		return nil, session.InvalidCursorError(ctx)
+	}
+	if limit < 1 || limit > 100 {
+		limit = 100
+	}
+
+	pattern := escapeLikePattern(query) + "%"
+	stmt := fmt.Sprintf(`SELECT %s FROM users WHERE LOWER(username) COLLATE "C" LIKE $1 AND (LOWER(username) COLLATE "C", user_id)>($2, $3) ORDER BY LOWER(username) COLLATE "C", user_id LIMIT $4`, strings.Join(userColumns, ","))
+	rows, err := mctx.database.QueryContext(ctx, stmt, pattern, username, id, limit+1)
 	if err != nil {
 		return nil, session.TransactionError(ctx, err)
 	}
 	defer rows.Close()
 
-	var users []*User
+	page := &Page[*User]{}
 	for rows.Next() {
 		user, err := userFromRows(rows)
 		if err != nil {
 			return nil, session.TransactionError(ctx, err)
 		}
-		users = append(users, user)
+		page.Items = append(page.Items, user)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, session.TransactionError(ctx, err)
 	}
-	return users, nil
+	if len(page.Items) > limit {
+		page.Items, page.HasMore = page.Items[:limit], true
+		last := page.Items[limit-1]
+		page.NextCursor = encodeKeyCursor(strings.ToLower(last.Username), last.UserID)
+	}
+	return page, nil
 }
 
-func readUsersByIds(ctx context.Context, tx *sql.Tx, ids []string) ([]*User, error) {
-	rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM users WHERE user_id IN ('%s') LIMIT 100", strings.Join(userColumns, ","), strings.Join(ids, "','")))
+// escapeLikePattern escapes the wildcards of LIKE in s, so it's matched literally
+func escapeLikePattern(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// Limits of FindUsersByEmailFragment
+const (
+	minimumEmailFragmentSize = 3
+	maximumEmailFragmentHits = 50
+)
+
+// FindUsersByEmailFragment finds the users whose email contains fragment,
+// case insensitive, for support to find an account, admin only. The infix
+// LIKE can't use users_emailx, it scans all users, so the fragment must have
+// at least 3 characters and at most 50 users are returned, ordered by email.
+func FindUsersByEmailFragment(mctx *Context, actor *User, fragment string) ([]*User, error) {
+	ctx := mctx.context
+	if actor == nil || !actor.isAdmin() {
+		return nil, session.ForbiddenError(ctx)
+	}
+	fragment = strings.ToLower(strings.TrimSpace(fragment))
+	if utf8.RuneCountInString(fragment) < minimumEmailFragmentSize {
+		return nil, session.BadDataError(ctx)
+	}
+	query := fmt.Sprintf("SELECT %s FROM users WHERE LOWER(email) LIKE '%%'||$1||'%%' ORDER BY LOWER(email), user_id LIMIT $2", strings.Join(userColumns, ","))
+	rows, err := mctx.database.QueryContext(ctx, query, escapeLikePattern(fragment), maximumEmailFragmentHits)
 	if err != nil {
-		return nil, err
+		return nil, session.TransactionError(ctx, err)
 	}
 	defer rows.Close()
 
@@ -248,95 +614,727 @@ func readUsersByIds(ctx context.Context, tx *sql.Tx, ids []string) ([]*User, err
 	for rows.Next() {
 		user, err := userFromRows(rows)
 		if err != nil {
-			return nil, err
+			return nil, session.TransactionError(ctx, err)
 		}
 		users = append(users, user)
 	}
-	return users, rows.Err()
+	if err := rows.Err(); err != nil {
+		return nil, session.TransactionError(ctx, err)
+	}
+	return users, nil
 }
 
-func readUserSet(ctx context.Context, tx *sql.Tx, ids []string) (map[string]*User, error) {
-	users, err := readUsersByIds(ctx, tx, ids)
+// readUsersPage read the users after (offset, id) in the order of orderBy,
+// ties of created_at are ordered by user_id, so no user is skipped or repeated
+// across pages. An empty id is before any user at offset.
+func readUsersPage(mctx *Context, offset time.Time, id, orderBy string, limit int) (*Page[*User], error) {
+	ctx := mctx.context
+	condition, order := "(created_at,user_id)>($1,$2)", orderBy+", user_id ASC"
+	if strings.HasSuffix(orderBy, " DESC") {
+		condition, order = "(created_at,user_id)<($1,$2)", orderBy+", user_id DESC"
+		if now := mctx.now(); offset.IsZero() || offset.After(now) {
+			offset, id = now, ""
+		}
+	}
+	query := fmt.Sprintf("SELECT %s FROM users WHERE %s ORDER BY %s LIMIT $3", strings.Join(userColumns, ","), condition, order)
+	rows, err := mctx.database.QueryContext(ctx, query, offset, id, limit+1)
 	if err != nil {
-		return nil, err
+		return nil, session.TransactionError(ctx, err)
 	}
-	set := make(map[string]*User, 0)
-	for _, u := range users {
-		set[u.UserID] = u
+	defer rows.Close()
+
+	page := &Page[*User]{}
+	for rows.Next() {
+		user, err := userFromRows(rows)
+		if err != nil {
+			return nil, session.TransactionError(ctx, err)
+		}
+		page.Items = append(page.Items, user)
 	}
-	return set, nil
+	if err := rows.Err(); err != nil {
+		return nil, session.TransactionError(ctx, err)
+	}
+	if len(page.Items) > limit {
+		page.Items, page.HasMore = page.Items[:limit], true
+		last := page.Items[limit-1]
+		page.NextCursor = encodeCursor(last.CreatedAt, last.UserID)
+	}
+	return page, nil
 }
 
-// ReadUser read user by id.
-func ReadUser(mctx *Context, id string) (*User, error) {
+// ReadSignupCohorts counts users by signup day, week or month, keyed by the
+// first date (UTC) of the bucket like 2006-01-02. Admin only.
+func ReadSignupCohorts(mctx *Context, actor *User, granularity string) (map[string]int64, error) {
 	ctx := mctx.context
-	var user *User
-	err := mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
-		var err error
-		user, err = findUserByID(ctx, tx, id)
-		return err
-	})
+	if actor == nil || !actor.isAdmin() {
+		return nil, session.ForbiddenError(ctx)
+	}
+	switch granularity {
+	case "day", "week", "month":
+	default:
+		return nil, session.BadDataError(ctx)
+	}
+
+	rows, err := mctx.database.QueryContext(ctx, "SELECT date_trunc($1, created_at AT TIME ZONE 'UTC') AS cohort, count(*) FROM users GROUP BY cohort", granularity)
 	if err != nil {
-		if _, ok := err.(session.Error); ok {
-			return nil, err
+		return nil, session.TransactionError(ctx, err)
+	}
+	defer rows.Close()
+
+	cohorts := make(map[string]int64)
+	for rows.Next() {
+		var cohort time.Time
+		var count int64
+		if err := rows.Scan(&cohort, &count); err != nil {
+			return nil, session.TransactionError(ctx, err)
 		}
+		cohorts[cohort.Format("2006-01-02")] = count
+	}
+	if err := rows.Err(); err != nil {
 		return nil, session.TransactionError(ctx, err)
 	}
-	return user, nil
+	return cohorts, nil
 }
 
-// ReadUserByUsernameOrEmail read user by identity, which is an email or username.
-func ReadUserByUsernameOrEmail(mctx *Context, identity string) (*User, error) {
+// ReadActiveUsers read the users seen within the duration by any session, the
+// most recently seen first. Anonymized users have no session, so they're never
+// listed.
+func ReadActiveUsers(mctx *Context, within time.Duration, limit int) ([]*User, error) {
 	ctx := mctx.context
-	identity = strings.ToLower(strings.TrimSpace(identity))
-	if len(identity) < 3 {
-		return nil, nil
+	if limit < 1 || limit > 100 {
+		limit = 100
 	}
-
-	var user *User
-	err := mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
-		var err error
-		user, err = findUserByIdentity(ctx, tx, identity)
-		return err
-	})
+	query := fmt.Sprintf("SELECT %s FROM users JOIN (SELECT user_id, max(last_seen_at) AS seen_at FROM sessions WHERE last_seen_at>$1 GROUP BY user_id) s USING (user_id) ORDER BY s.seen_at DESC, user_id LIMIT $2", strings.Join(userColumns, ","))
+	rows, err := mctx.database.QueryContext(ctx, query, mctx.now().Add(-within), limit)
 	if err != nil {
 		return nil, session.TransactionError(ctx, err)
 	}
-	return user, nil
-}
+	defer rows.Close()
 
-func findUserByIdentity(ctx context.Context, tx *sql.Tx, identity string) (*User, error) {
-	row := tx.QueryRowContext(ctx, fmt.Sprintf("SELECT %s FROM users WHERE username=$1 OR email=$1 LIMIT 1", strings.Join(userColumns, ",")), identity)
-	user, err := userFromRows(row)
-	if err == sql.ErrNoRows {
-		return nil, nil
-	} else if err != nil {
-		return nil, err
+	var users []*User
+	for rows.Next() {
+		user, err := userFromRows(rows)
+		if err != nil {
+			return nil, session.TransactionError(ctx, err)
+		}
+		users = append(users, user)
 	}
-	return user, nil
-}
-
-// Role of an user, contains admin and member for now.
-func (u *User) Role() string {
-	if configs.AppConfig.OperatorSet[u.Email.String] {
-		return userRoleAdmin
+	if err := rows.Err(); err != nil {
+		return nil, session.TransactionError(ctx, err)
 	}
-	return userRoleMember
+	return users, nil
 }
 
-// Name is nickname or username
-func (u *User) Name() string {
-	if u.Nickname != "" {
-		return u.Nickname
+// ReadUsersUpdatedSince read the users after (since, afterID) in the order of
+// updated_at and user_id, the least recently updated first, for incremental
+// syncs. Bulk updates stamp many users with the same updated_at, so the next
+// sync passes the updated_at and user_id of the last one, an empty afterID
+// includes the users updated at since.
+func ReadUsersUpdatedSince(mctx *Context, since time.Time, afterID string, limit int) ([]*User, error) {
+	ctx := mctx.context
+	if limit < 1 || limit > 100 {
+		limit = 100
 	}
-	return u.Username
-}
+	query := fmt.Sprintf("SELECT %s FROM users WHERE (updated_at,user_id)>($1,$2) ORDER BY updated_at, user_id LIMIT $3", strings.Join(userColumns, ","))
+	rows, err := mctx.database.QueryContext(ctx, query, since, afterID, limit)
+	if err != nil {
+		return nil, session.TransactionError(ctx, err)
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		user, err := userFromRows(rows)
+		if err != nil {
+			return nil, session.TransactionError(ctx, err)
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, session.TransactionError(ctx, err)
+	}
+	return users, nil
+}
+
+// FindCaseConflictingUsernames groups the usernames equal except the case, e.g.
+// "Bob" and "bob", operators should resolve them before users_usernamex is
+// created on an old database. Each group is ordered by signup.
+func FindCaseConflictingUsernames(mctx *Context) ([][]string, error) {
+	ctx := mctx.context
+	query := "SELECT array_agg(username ORDER BY created_at, user_id) FROM users GROUP BY LOWER(username) HAVING count(*)>1 ORDER BY LOWER(username)"
+	rows, err := mctx.database.QueryContext(ctx, query)
+	if err != nil {
+		return nil, session.TransactionError(ctx, err)
+	}
+	defer rows.Close()
+
+	var groups [][]string
+	for rows.Next() {
+		var usernames []string
+		if err := rows.Scan(pq.Array(&usernames)); err != nil {
+			return nil, session.TransactionError(ctx, err)
+		}
+		groups = append(groups, usernames)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, session.TransactionError(ctx, err)
+	}
+	return groups, nil
+}
+
+func readUsersByIds(ctx context.Context, tx *sql.Tx, ids []string) ([]*User, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM users WHERE user_id IN ('%s') LIMIT 100", strings.Join(userColumns, ","), strings.Join(ids, "','")))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		user, err := userFromRows(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// ReadUsersOrdered read users by ids in the order of ids, e.g. a ranked feed,
+// missing users are skipped.
+func ReadUsersOrdered(mctx *Context, ids []string) ([]*User, error) {
+	ctx := mctx.context
+	if len(ids) == 0 {
+		return []*User{}, nil
+	}
+	rows, err := mctx.database.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM users WHERE user_id=ANY($1)", strings.Join(userColumns, ",")), pq.Array(ids))
+	if err != nil {
+		return nil, session.TransactionError(ctx, err)
+	}
+	defer rows.Close()
+
+	set := make(map[string]*User, len(ids))
+	for rows.Next() {
+		user, err := userFromRows(rows)
+		if err != nil {
+			return nil, session.TransactionError(ctx, err)
+		}
+		set[user.UserID] = user
+	}
+	if err := rows.Err(); err != nil {
+		return nil, session.TransactionError(ctx, err)
+	}
+	users := make([]*User, 0, len(set))
+	for _, id := range ids {
+		if user := set[id]; user != nil {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+// UserReadError is the failure of a single user in a batch read
+type UserReadError struct {
+	UserID string
+	Err    error
+}
+
+// ReadUsersByIDsPartial read users by ids like readUsersByIds, but a row fails
+// to scan doesn't fail the whole read, the users scanned are returned along with
+// the errors of failed rows. The error returned is only for the query itself.
+func ReadUsersByIDsPartial(mctx *Context, ids []string) ([]*User, []*UserReadError, error) {
+	ctx := mctx.context
+	var valid []string
+	for _, id := range ids {
+		if _, err := uuid.FromString(id); err == nil {
+			valid = append(valid, id)
+		}
+	}
+	if len(valid) == 0 {
+		return nil, nil, nil
+	}
+
+	var users []*User
+	var failures []*UserReadError
+	err := mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM users WHERE user_id=ANY($1) LIMIT 100", strings.Join(userColumns, ",")), pq.Array(valid))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		users, failures, err = usersFromRowsPartial(rows)
+		return err
+	})
+	if err != nil {
+		return nil, nil, session.TransactionError(ctx, err)
+	}
+	return users, failures, nil
+}
+
+type userRows interface {
+	durable.Row
+	Next() bool
+	Err() error
+}
+
+func usersFromRowsPartial(rows userRows) ([]*User, []*UserReadError, error) {
+	var users []*User
+	var failures []*UserReadError
+	for rows.Next() {
+		user, err := userFromRows(rows)
+		if err != nil {
+			failures = append(failures, &UserReadError{UserID: user.UserID, Err: err})
+			continue
+		}
+		users = append(users, user)
+	}
+	return users, failures, rows.Err()
+}
+
+func readUserSet(ctx context.Context, tx *sql.Tx, ids []string) (map[string]*User, error) {
+	users, err := readUsersByIds(ctx, tx, ids)
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[string]*User, 0)
+	for _, u := range users {
+		set[u.UserID] = u
+	}
+	return set, nil
+}
+
+// ReadUser read user by id. It never returns (nil, nil), a missing user is
+// reported as session.NotFoundError, which matches errors.Is(err, session.ErrNotFound).
+func ReadUser(mctx *Context, id string) (*User, error) {
+	ctx := mctx.context
+	id = strings.TrimSpace(id)
+	user, err := durable.RunInTransactionValue(ctx, mctx.database, func(tx *sql.Tx) (*User, error) {
+		return findUserByID(ctx, tx, id)
+	})
+	if err != nil {
+		if _, ok := err.(session.Error); ok {
+			return nil, err
+		}
+		return nil, session.TransactionError(ctx, err)
+	}
+	if user == nil {
+		return nil, session.NotFoundError(ctx)
+	}
+	return user, nil
+}
+
+// ReadUserByUsernameOrEmail read user by identity, which is an email or username.
+// Same as ReadUser, a missing user is reported as session.NotFoundError.
+func ReadUserByUsernameOrEmail(mctx *Context, identity string) (*User, error) {
+	ctx := mctx.context
+	identity = strings.TrimSpace(identity)
+	if len(identity) < 3 {
+		return nil, session.NotFoundError(ctx)
+	}
+
+	user, err := durable.RunInTransactionValue(ctx, mctx.database, func(tx *sql.Tx) (*User, error) {
+		return findUserByIdentity(ctx, tx, identity)
+	})
+	if err != nil {
+		return nil, session.TransactionError(ctx, err)
+	}
+	if user == nil {
+		return nil, session.NotFoundError(ctx)
+	}
+	return user, nil
+}
+
+// DeleteUnverifiedUsersOlderThan deletes users who never verified their email
+// and signed up before cutoff, with their sessions, returns the count. Operators,
+// github users and users without email, e.g. username only or anonymized, are kept.
+func DeleteUnverifiedUsersOlderThan(mctx *Context, cutoff time.Time) (int64, error) {
+	ctx := mctx.context
+	if err := checkWritable(ctx); err != nil {
+		return 0, err
+	}
+	operators := []string{}
+	if config := configs.Current(); config != nil {
+		for email := range config.OperatorSet {
+			operators = append(operators, strings.ToLower(email))
+		}
+	}
+
+	var ids []string
+	err := mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
+		query := "DELETE FROM users WHERE email IS NOT NULL AND email_verified_at IS NULL AND github_id IS NULL AND created_at<$1 AND NOT (COALESCE(LOWER(email), '')=ANY($2)) RETURNING user_id"
+		rows, err := tx.QueryContext(ctx, query, cutoff, pq.Array(operators))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM sessions WHERE user_id=ANY($1)", pq.Array(ids))
+		return err
+	})
+	if err != nil {
+		return 0, session.TransactionError(ctx, err)
+	}
+	for _, id := range ids {
+		authenticatedSessions.invalidateUser(id)
+	}
+	return int64(len(ids)), nil
+}
+
+// identityQuery builds the query of identity, the conditions match the
+// expression of users_emailx and users_usernamex, so each one hits its index.
+func identityQuery(kind IdentityKind) string {
+	columns := strings.Join(userColumns, ",")
+	switch kind {
+	case IdentityEmail:
+		return fmt.Sprintf("SELECT %s FROM users WHERE LOWER(email)=$1 LIMIT 1", columns)
+	case IdentityUsername:
+		return fmt.Sprintf("SELECT %s FROM users WHERE %s=$1 LIMIT 1", columns, usernameKeySQL())
+	}
+	return fmt.Sprintf("(SELECT %s FROM users WHERE LOWER(username)=$1) UNION (SELECT %s FROM users WHERE LOWER(email)=$1) LIMIT 1", columns, columns)
+}
+
+func findUserByIdentity(ctx context.Context, tx *sql.Tx, identity string) (*User, error) {
+	kind := ClassifyIdentity(identity)
+	if kind == IdentityUsername {
+		identity = usernameKey(identity)
+	} else {
+		identity = strings.ToLower(identity)
+	}
+	row := tx.QueryRowContext(ctx, identityQuery(kind), identity)
+	user, err := userFromRows(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Role of an user, contains admin, moderator and member. Operators are always
+// admin, others have the assigned role. An unloaded config means no operators.
+func (u *User) Role() string {
+	config := configs.Current()
+	if config != nil && config.OperatorSet[u.Email.String] {
+		return userRoleAdmin
+	}
+	switch u.AssignedRole {
+	case userRoleAdmin, userRoleModerator:
+		return u.AssignedRole
+	}
+	return userRoleMember
+}
+
+// SetRoles assigns role to the users in one transaction, only admins could do
+// it, returns the count of users changed. It's refused if no admin would be
+// left after the change, the admin rows are locked first, so concurrent
+// demotions of the last two admins can't both pass the check.
+func SetRoles(mctx *Context, actor *User, userIDs []string, role string) (int64, error) {
+	ctx := mctx.context
+	if err := checkWritable(ctx); err != nil {
+		return 0, err
+	}
+	if actor == nil || !actor.isAdmin() {
+		return 0, session.ForbiddenError(ctx)
+	}
+	switch role {
+	case userRoleAdmin, userRoleModerator, userRoleMember:
+	default:
+		return 0, session.BadDataError(ctx)
+	}
+	if len(userIDs) == 0 {
+		return 0, nil
+	}
+
+	var count int64
+	err := mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
+		if err := lockAdmins(ctx, tx); err != nil {
+			return err
+		}
+		result, err := tx.ExecContext(ctx, "UPDATE users SET role=$1, updated_at=GREATEST(updated_at, $3) WHERE user_id=ANY($2) AND role<>$1", role, pq.Array(userIDs), mctx.now())
+		if err != nil {
+			return err
+		}
+		count, err = result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		admins, err := adminsCount(ctx, tx)
+		if err != nil {
+			return err
+		}
+		if admins == 0 {
+			return session.BadDataError(ctx)
+		}
+		return nil
+	})
+	if err != nil {
+		if _, ok := err.(session.Error); ok {
+			return 0, err
+		}
+		return 0, session.TransactionError(ctx, err)
+	}
+	for _, id := range userIDs {
+		authenticatedSessions.invalidateUser(id)
+	}
+	return count, nil
+}
+
+// AnonymizedNickname is the nickname of anonymized users
+const AnonymizedNickname = "deleted-user"
+
+// isAnonymized tells whether the user is scrubbed by AnonymizeUser, a live
+// user always has an email, password or github link to sign in.
+func (u *User) isAnonymized() bool {
+	return u.Nickname == AnonymizedNickname && !u.Email.Valid && !u.EncryptedPassword.Valid && !u.GithubID.Valid
+}
+
+// anonymizedUserSQL is isAnonymized as a SQL predicate on users aliased u, the
+// verb is the placeholder of AnonymizedNickname, e.g. fmt.Sprintf(anonymizedUserSQL, "$4").
+const anonymizedUserSQL = "(u.nickname=%s AND u.email IS NULL AND u.encrypted_password IS NULL AND u.github_id IS NULL)"
+
+// reauthWindow is how recent the session of an oauth only user must be to
+// confirm a destructive action without password
+const reauthWindow = 10 * time.Minute
+
+// AnonymizeUser scrubs the personal data of the user on request, the actor is
+// the user self or an admin. The row and the authored content are kept, but
+// email, nickname, biography, password, oauth links, profile audits and
+// sessions are removed. The user self must re-authenticate, by the password,
+// or for oauth only users by a session signed in within reauthWindow, admins
+// anonymizing others don't.
+func AnonymizeUser(mctx *Context, actor *User, userID, password string) error {
+	ctx := mctx.context
+	if err := checkWritable(ctx); err != nil {
+		return err
+	}
+	if actor == nil || !isPermit(userID, actor) {
+		return session.ForbiddenError(ctx)
+	}
+	if actor.UserID == userID {
+		if err := actor.reauthenticate(mctx, password); err != nil {
+			return err
+		}
+	}
+
+	err := mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
+		user, err := findUserByID(ctx, tx, userID)
+		if err != nil {
+			return err
+		} else if user == nil {
+			return session.NotFoundError(ctx)
+		}
+		query := "UPDATE users SET (email,nickname,display_name,biography,encrypted_password,github_id,email_verified_at)=(NULL,$1,NULL,'',NULL,NULL,NULL) WHERE user_id=$2"
+		if _, err := tx.ExecContext(ctx, query, AnonymizedNickname, user.UserID); err != nil {
+			return err
+		}
+		if _, err := touchUpdatedAt(ctx, tx, "users", "user_id", user.UserID, mctx.now()); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM email_verifications WHERE user_id=$1", user.UserID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM linked_providers WHERE user_id=$1", user.UserID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM profile_audits WHERE user_id=$1", user.UserID); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM sessions WHERE user_id=$1", user.UserID)
+		return err
+	})
+	if err != nil {
+		if _, ok := err.(session.Error); ok {
+			return err
+		}
+		return session.TransactionError(ctx, err)
+	}
+	authenticatedSessions.invalidateUser(userID)
+	return nil
+}
+
+// reauthenticate confirms the user is present for a destructive action
+func (u *User) reauthenticate(mctx *Context, password string) error {
+	ctx := mctx.context
+	if u.EncryptedPassword.Valid && u.EncryptedPassword.String != "" {
+		if password == "" {
+			return session.ReauthRequiredError(ctx)
+		}
+		return u.VerifyPassword(mctx, password)
+	}
+	var createdAt time.Time
+	row, err := mctx.database.QueryRowContext(ctx, "SELECT created_at FROM sessions WHERE user_id=$1 AND session_id=$2", u.UserID, u.SessionID)
+	if err != nil {
+		return session.TransactionError(ctx, err)
+	}
+	if err := row.Scan(&createdAt); err == sql.ErrNoRows {
+		return session.ReauthRequiredError(ctx)
+	} else if err != nil {
+		return session.TransactionError(ctx, err)
+	}
+	if mctx.now().Sub(createdAt) > reauthWindow {
+		return session.ReauthRequiredError(ctx)
+	}
+	return nil
+}
+
+// ReadAllAdmins read the admins, users with role admin and the registered
+// operators, each once, ordered by signup. Admin only.
+func ReadAllAdmins(mctx *Context, actor *User) ([]*User, error) {
+	ctx := mctx.context
+	if actor == nil || !actor.isAdmin() {
+		return nil, session.ForbiddenError(ctx)
+	}
+	operators := []string{}
+	if config := configs.Current(); config != nil {
+		for email := range config.OperatorSet {
+			operators = append(operators, email)
+		}
+	}
+	query := fmt.Sprintf("SELECT %s FROM users WHERE role=$1 OR email=ANY($2) ORDER BY created_at, user_id", strings.Join(userColumns, ","))
+	rows, err := mctx.database.QueryContext(ctx, query, userRoleAdmin, pq.Array(operators))
+	if err != nil {
+		return nil, session.TransactionError(ctx, err)
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		user, err := userFromRows(rows)
+		if err != nil {
+			return nil, session.TransactionError(ctx, err)
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, session.TransactionError(ctx, err)
+	}
+	return users, nil
+}
+
+// lockAdmins locks the rows of users with role admin until the transaction
+// ends, operators are admins by the config, a role change can't demote them.
+func lockAdmins(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, "SELECT user_id FROM users WHERE role=$1 FOR UPDATE", userRoleAdmin)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+	}
+	return rows.Err()
+}
+
+// adminsCount counts the registered operators and users with role admin
+func adminsCount(ctx context.Context, tx *sql.Tx) (int64, error) {
+	operators := []string{}
+	if config := configs.Current(); config != nil {
+		for email := range config.OperatorSet {
+			operators = append(operators, email)
+		}
+	}
+	var count int64
+	err := tx.QueryRowContext(ctx, "SELECT count(*) FROM users WHERE role=$1 OR email=ANY($2)", userRoleAdmin, pq.Array(operators)).Scan(&count)
+	return count, err
+}
+
+// ReadUnmatchedOperators returns the operator emails no user registered with,
+// sorted, these operators can't act as admin until they sign up. Admin only.
+func ReadUnmatchedOperators(mctx *Context, actor *User) ([]string, error) {
+	ctx := mctx.context
+	if actor == nil || !actor.isAdmin() {
+		return nil, session.ForbiddenError(ctx)
+	}
+	operators := []string{}
+	if config := configs.Current(); config != nil {
+		for email := range config.OperatorSet {
+			operators = append(operators, email)
+		}
+	}
+	if len(operators) == 0 {
+		return operators, nil
+	}
+	rows, err := mctx.database.QueryContext(ctx, "SELECT email FROM users WHERE email=ANY($1)", pq.Array(operators))
+	if err != nil {
+		return nil, session.TransactionError(ctx, err)
+	}
+	defer rows.Close()
+
+	registered := make(map[string]bool)
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			return nil, session.TransactionError(ctx, err)
+		}
+		registered[email] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, session.TransactionError(ctx, err)
+	}
+	unmatched := []string{}
+	for _, email := range operators {
+		if !registered[email] {
+			unmatched = append(unmatched, email)
+		}
+	}
+	sort.Strings(unmatched)
+	return unmatched, nil
+}
+
+var (
+	nicknameAdjectives = []string{"Brave", "Calm", "Clever", "Gentle", "Happy", "Lucky", "Quiet", "Swift", "Witty", "Bold"}
+	nicknameAnimals    = []string{"Otter", "Fox", "Panda", "Owl", "Koala", "Tiger", "Falcon", "Dolphin", "Lynx", "Heron"}
+)
+
+// NicknameGenerator generates the nickname when it's blank at signup and
+// system.generate_nickname is enabled, replace it to customize.
+var NicknameGenerator = func() string {
+	var b [4]byte
+	_, _ = rand.Read(b[:])
+	n := binary.BigEndian.Uint32(b[:])
+	adjective := nicknameAdjectives[n%uint32(len(nicknameAdjectives))]
+	animal := nicknameAnimals[(n/uint32(len(nicknameAdjectives)))%uint32(len(nicknameAnimals))]
+	return fmt.Sprintf("%s %s %04d", adjective, animal, n%10000)
+}
+
+// Name is nickname or username
+func (u *User) Name() string {
+	if u.Nickname != "" {
+		return u.Nickname
+	}
+	return u.Username
+}
+
+// DisplayedName is the display name, or Name if it's unset
+func (u *User) DisplayedName() string {
+	if u.DisplayName.Valid && u.DisplayName.String != "" {
+		return u.DisplayName.String
+	}
+	return u.Name()
+}
+
+// DefaultAvatarURL is the avatar of users without an email gravatar, an
+// identicon of the hash of user_id, so it's the same on every render.
+func (u *User) DefaultAvatarURL() string {
+	return fmt.Sprintf("https://www.gravatar.com/avatar/%x?s=180&d=identicon&f=y", md5.Sum([]byte(u.UserID)))
+}
 
 func (u *User) isAdmin() bool {
 	return u.Role() == userRoleAdmin
 }
 
 func findUserByID(ctx context.Context, tx *sql.Tx, id string) (*User, error) {
+	id = strings.TrimSpace(id)
 	if _, err := uuid.FromString(id); err != nil {
 		return nil, nil
 	}
@@ -349,12 +1347,87 @@ func findUserByID(ctx context.Context, tx *sql.Tx, id string) (*User, error) {
 	return u, err
 }
 
+// checkNicknameImpersonation returns NicknameImpersonationError if the nickname is
+// the username of another user case insensitively, when system.reject_nickname_impersonation
+// is enabled. Nicknames are not unique in general.
+func checkNicknameImpersonation(ctx context.Context, tx *sql.Tx, nickname, userID string) error {
+	if config := configs.Current(); config == nil || !config.System.RejectNicknameImpersonation {
+		return nil
+	}
+	var exist bool
+	err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE LOWER(username)=LOWER($1) AND user_id<>$2)", nickname, userID).Scan(&exist)
+	if err != nil {
+		return err
+	} else if exist {
+		return session.NicknameImpersonationError(ctx)
+	}
+	return nil
+}
+
+// checkConfusableUsername returns UsernameUnavailableError if the username
+// looks like the username of another user, i.e. they have the same
+// usernameSkeleton, when system.reject_confusable_usernames is enabled. The
+// user userID is excluded, renaming to a look-alike of its own name is fine.
+func checkConfusableUsername(ctx context.Context, tx *sql.Tx, username, userID string) error {
+	if config := configs.Current(); config == nil || !config.System.RejectConfusableUsernames {
+		return nil
+	}
+	var exist bool
+	query := "SELECT EXISTS (SELECT 1 FROM users WHERE %s=$1 AND user_id<>$2)"
+	err := tx.QueryRowContext(ctx, fmt.Sprintf(query, usernameSkeletonSQL), usernameSkeleton(username), userID).Scan(&exist)
+	if err != nil {
+		return err
+	} else if exist {
+		return session.UsernameUnavailableError(ctx)
+	}
+	return nil
+}
+
+// bootstrapFirstAdmin makes the user admin if no user exists and
+// system.bootstrap_first_admin is on. The table is locked only while it's
+// empty, the lock serializes concurrent first signups, so only one of them
+// becomes admin, later signups don't contend on it.
+func bootstrapFirstAdmin(ctx context.Context, tx *sql.Tx, user *User) error {
+	if config := configs.Current(); config == nil || !config.System.BootstrapFirstAdmin {
+		return nil
+	}
+	if exist, err := usersExist(ctx, tx); err != nil || exist {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "LOCK TABLE users IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+		return err
+	}
+	exist, err := usersExist(ctx, tx)
+	if err != nil {
+		return err
+	}
+	if !exist {
+		user.AssignedRole = userRoleAdmin
+	}
+	return nil
+}
+
+func usersExist(ctx context.Context, tx *sql.Tx) (bool, error) {
+	var exist bool
+	err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM users)").Scan(&exist)
+	return exist, err
+}
+
 func usersCount(ctx context.Context, tx *sql.Tx) (int64, error) {
 	var count int64
 	err := tx.QueryRowContext(ctx, "SELECT count(*) FROM users").Scan(&count)
 	return count, err
 }
 
+// touchUpdatedAt advances updated_at of the row in table whose column equals id,
+// mutations should call it in the same transaction, updated_at never goes backwards.
+func touchUpdatedAt(ctx context.Context, tx *sql.Tx, table, column, id string, now time.Time) (time.Time, error) {
+	var t time.Time
+	query := fmt.Sprintf("UPDATE %s SET updated_at=GREATEST(updated_at, $1) WHERE %s=$2 RETURNING updated_at", table, column)
+	err := tx.QueryRowContext(ctx, query, now, id).Scan(&t)
+	return t, err
+}
+
 func validateAndEncryptPassword(ctx context.Context, password string) (string, error) {
 	if len(password) < 8 {
 		return password, session.PasswordTooSimpleError(ctx)
@@ -362,13 +1435,52 @@ func validateAndEncryptPassword(ctx context.Context, password string) (string, e
 	if len(password) > 64 {
 		return password, session.BadDataError(ctx)
 	}
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 10)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost())
 	if err != nil {
 		return password, session.ServerError(ctx, err)
 	}
 	return string(hashedPassword), nil
 }
 
+// VerifyPassword confirms the password of the user without creating a session,
+// e.g. before deleting the account. Users without a password (github only) get
+// PasswordNotSetError. It's throttled and records failures like CreateSession,
+// so it can't be used to guess the password past the rate limit.
+func (u *User) VerifyPassword(mctx *Context, password string) error {
+	ctx := mctx.context
+	if !u.EncryptedPassword.Valid || u.EncryptedPassword.String == "" {
+		return session.PasswordNotSetError(ctx)
+	}
+	return comparePassword(mctx, u, password)
+}
+
+// passwordCost is system.password_cost, bcrypt.DefaultCost if it's out of range
+func passwordCost() int {
+	if config := configs.Current(); config != nil {
+		if cost := config.System.PasswordCost; cost >= bcrypt.MinCost && cost <= bcrypt.MaxCost {
+			return cost
+		}
+	}
+	return bcrypt.DefaultCost
+}
+
+// PasswordNeedsUpgrade tells whether the password hash is weaker than the
+// policy, i.e. its bcrypt cost is below system.password_cost, for a nudge to
+// change the password at login. The length or the strength of the password
+// can't be told from the hash. Users without a password (github only) never
+// need an upgrade.
+func (u *User) PasswordNeedsUpgrade(mctx *Context) bool {
+	if !u.EncryptedPassword.Valid || u.EncryptedPassword.String == "" {
+		return false
+	}
+	cost, err := bcrypt.Cost([]byte(u.EncryptedPassword.String))
+	if err != nil {
+		return true
+	}
+	return cost < passwordCost()
+}
+
+// isPermit is CanAct of ActionEdit, self or admin
 func isPermit(userID string, user *User) bool {
-	return userID == user.UserID || user.isAdmin()
+	return CanAct(user, ActionEdit, userID)
 }
@@ -0,0 +1,176 @@
+package models
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"satellity/internal/session"
+	"strings"
+	"time"
+)
+
+type userExport struct {
+	Profile  userExportProfile   `json:"profile"`
+	Sessions []userExportSession `json:"sessions"`
+	Groups   []userExportGroup   `json:"groups"`
+	Topics   []userExportTopic   `json:"topics"`
+	Comments []userExportComment `json:"comments"`
+}
+
+type userExportProfile struct {
+	UserID          string     `json:"user_id"`
+	Email           string     `json:"email"`
+	Username        string     `json:"username"`
+	Nickname        string     `json:"nickname"`
+	DisplayName     string     `json:"display_name"`
+	Biography       string     `json:"biography"`
+	Role            string     `json:"role"`
+	GithubLinked    bool       `json:"github_linked"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+type userExportSession struct {
+	SessionID string    `json:"session_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type userExportGroup struct {
+	GroupID  string    `json:"group_id"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+type userExportTopic struct {
+	TopicID    string    `json:"topic_id"`
+	Title      string    `json:"title"`
+	Body       string    `json:"body"`
+	CategoryID string    `json:"category_id"`
+	Draft      bool      `json:"draft"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type userExportComment struct {
+	CommentID string    `json:"comment_id"`
+	TopicID   string    `json:"topic_id"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ExportUserData exports the data of the user as a JSON document for data
+// portability, the actor is the user self or an admin. Passwords and session
+// secrets are never exported.
+func ExportUserData(mctx *Context, actor *User, userID string) ([]byte, error) {
+	ctx := mctx.context
+	if actor == nil || !isPermit(userID, actor) {
+		return nil, session.ForbiddenError(ctx)
+	}
+
+	export := &userExport{
+		Sessions: []userExportSession{},
+		Groups:   []userExportGroup{},
+		Topics:   []userExportTopic{},
+		Comments: []userExportComment{},
+	}
+	err := mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
+		user, err := findUserByID(ctx, tx, userID)
+		if err != nil {
+			return err
+		} else if user == nil {
+			return session.NotFoundError(ctx)
+		}
+		export.Profile = userExportProfile{
+			UserID:       user.UserID,
+			Email:        user.Email.String,
+			Username:     user.Username,
+			Nickname:     user.Nickname,
+			DisplayName:  user.DisplayName.String,
+			Biography:    user.Biography,
+			Role:         user.Role(),
+			GithubLinked: user.GithubID.Valid,
+			CreatedAt:    user.CreatedAt,
+			UpdatedAt:    user.UpdatedAt,
+		}
+		if user.EmailVerifiedAt.Valid {
+			export.Profile.EmailVerifiedAt = &user.EmailVerifiedAt.Time
+		}
+
+		rows, err := tx.QueryContext(ctx, "SELECT session_id,created_at FROM sessions WHERE user_id=$1 ORDER BY created_at", user.UserID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var s userExportSession
+			if err := rows.Scan(&s.SessionID, &s.CreatedAt); err != nil {
+				return err
+			}
+			export.Sessions = append(export.Sessions, s)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		rows, err = tx.QueryContext(ctx, "SELECT group_id,role,created_at FROM participants WHERE user_id=$1 ORDER BY created_at", user.UserID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var g userExportGroup
+			if err := rows.Scan(&g.GroupID, &g.Role, &g.JoinedAt); err != nil {
+				return err
+			}
+			export.Groups = append(export.Groups, g)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		rows, err = tx.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM topics WHERE user_id=$1 ORDER BY created_at", strings.Join(topicColumns, ",")), user.UserID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			t, err := topicFromRows(rows)
+			if err != nil {
+				return err
+			}
+			export.Topics = append(export.Topics, userExportTopic{t.TopicID, t.Title, t.Body, t.CategoryID, t.Draft, t.CreatedAt, t.UpdatedAt})
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		rows, err = tx.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM comments WHERE user_id=$1 ORDER BY created_at", strings.Join(commentColumns, ",")), user.UserID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			c, err := commentFromRows(rows)
+			if err != nil {
+				return err
+			}
+			export.Comments = append(export.Comments, userExportComment{c.CommentID, c.TopicID, c.Body, c.CreatedAt, c.UpdatedAt})
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		if _, ok := err.(session.Error); ok {
+			return nil, err
+		}
+		return nil, session.TransactionError(ctx, err)
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(export); err != nil {
+		return nil, session.ServerError(ctx, err)
+	}
+	return buf.Bytes(), nil
+}
@@ -3,13 +3,13 @@ package models
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"satellity/internal/configs"
 	"satellity/internal/durable"
-	"satellity/internal/external"
 	"satellity/internal/session"
 	"strings"
 	"time"
@@ -28,6 +28,9 @@ type GithubUser struct {
 // CreateGithubUser create a github user.
 func CreateGithubUser(mctx *Context, code, sessionSecret string) (*User, error) {
 	ctx := mctx.context
+	if err := checkWritable(ctx); err != nil {
+		return nil, err
+	}
 	token, err := fetchAccessToken(ctx, code)
 	if err != nil {
 		return nil, session.ServerError(ctx, err)
@@ -36,56 +39,167 @@ func CreateGithubUser(mctx *Context, code, sessionSecret string) (*User, error)
 	if err != nil {
 		return nil, session.ServerError(ctx, err)
 	}
-	var user *User
-	err = mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
+	user, err := resolveGithubUser(mctx, data)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err = saveGithubUser(mctx, user, sessionSecret)
+	if err != nil {
+		return nil, err
+	}
+	if user.isNew {
+		mctx.userCreated(user)
+	}
+	go upsertStatistic(mctx, "users")
+	return user, nil
+}
+
+// resolveGithubUser finds the user of the github account. Without one, an
+// existing user of the same email is linked, only if the email is verified and
+// no github account linked, otherwise a github login could take over an account
+// by an unverified email, then a distinct user without the email is created.
+func resolveGithubUser(mctx *Context, data *GithubUser) (*User, error) {
+	ctx := mctx.context
+	var user, existing *User
+	err := mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
 		var err error
 		user, err = findUserByGithubID(ctx, tx, data.NodeID)
+		if err != nil || user != nil || data.Email == "" {
+			return err
+		}
+		existing, err = findUserByIdentity(ctx, tx, strings.ToLower(data.Email))
 		return err
 	})
 	if err != nil {
 		return nil, session.TransactionError(ctx, err)
 	}
-	if user == nil {
-		t := time.Now()
-		user = &User{
-			UserID:    uuid.Must(uuid.NewV4()).String(),
-			Username:  fmt.Sprintf("%s_GH", data.Login),
-			Nickname:  data.Name,
-			GithubID:  sql.NullString{String: data.NodeID, Valid: true},
-			CreatedAt: t,
-			UpdatedAt: t,
-			isNew:     true,
-		}
-		if data.Email != "" {
-			user.Email = sql.NullString{String: data.Email, Valid: true}
-		}
+	if user != nil {
+		user.githubLogin = data.Login
+		return user, nil
+	}
+	if existing != nil && existing.Email.Valid && existing.EmailVerifiedAt.Valid && !existing.GithubID.Valid {
+		existing.GithubID = sql.NullString{String: data.NodeID, Valid: true}
+		existing.githubLinked = true
+		existing.githubLogin = data.Login
+		return existing, nil
 	}
 
-	err = mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
+	t := mctx.now()
+	user = &User{
+		UserID:       uuid.Must(uuid.NewV4()).String(),
+		Username:     fmt.Sprintf("%s_GH", data.Login),
+		Nickname:     data.Name,
+		GithubID:     sql.NullString{String: data.NodeID, Valid: true},
+		AssignedRole: userRoleMember,
+		CreatedAt:    t,
+		UpdatedAt:    t,
+		isNew:        true,
+		githubLogin:  data.Login,
+	}
+	if data.Email != "" && existing == nil {
+		user.Email = sql.NullString{String: data.Email, Valid: true}
+	}
+	return user, nil
+}
+
+// usersGithubIDConstraint is the unique constraint of users.github_id
+const usersGithubIDConstraint = "users_github_id_key"
+
+// saveGithubUser inserts the new github user and adds a session to it. Two
+// concurrent oauth callbacks of the same new github user could both try to insert,
+// or one of them link it to an existing user, the loser gets unique violation
+// on github_id, then it re-reads the user saved by the winner and uses it, so
+// both logins succeed.
+func saveGithubUser(mctx *Context, user *User, sessionSecret string) (*User, error) {
+	ctx := mctx.context
+	err := mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
 		if user.isNew {
 			cols, params := durable.PrepareColumnsWithValues(userColumns)
 			_, err := tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO users(%s) VALUES (%s)", cols, params), user.values()...)
 			if err != nil {
 				return err
 			}
+		} else if user.githubLinked {
+			result, err := tx.ExecContext(ctx, "UPDATE users SET github_id=$1 WHERE user_id=$2 AND github_id IS NULL", user.GithubID, user.UserID)
+			if err != nil {
+				return err
+			}
+			if count, err := result.RowsAffected(); err != nil {
+				return err
+			} else if count == 0 {
+				return session.BadDataError(ctx)
+			}
+			if user.UpdatedAt, err = touchUpdatedAt(ctx, tx, "users", "user_id", user.UserID, mctx.now()); err != nil {
+				return err
+			}
+		}
+		if err := linkProvider(ctx, tx, user, ProviderGithub, user.GithubID.String, user.githubLogin, mctx.now()); err != nil {
+			return err
 		}
-		s, err := user.addSession(ctx, tx, sessionSecret)
+		s, err := user.addSession(ctx, tx, sessionSecret, false, mctx.now())
 		if err != nil {
 			return err
 		}
 		user.SessionID = s.SessionID
 		return err
 	})
+	class := durable.ClassifyError(err)
+	if class.Kind == durable.ErrorUniqueViolation && (user.isNew || (user.githubLinked && class.Constraint == usersGithubIDConstraint)) {
+		githubID := user.GithubID.String
+		err = mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
+			existing, err := findUserByGithubID(ctx, tx, githubID)
+			if err != nil {
+				return err
+			} else if existing == nil {
+				return session.BadDataError(ctx)
+			}
+			s, err := existing.addSession(ctx, tx, sessionSecret, false, mctx.now())
+			if err != nil {
+				return err
+			}
+			existing.SessionID = s.SessionID
+			user = existing
+			return nil
+		})
+	}
 	if err != nil {
+		if _, ok := err.(session.Error); ok {
+			return nil, err
+		}
 		return nil, session.TransactionError(ctx, err)
 	}
-	go upsertStatistic(mctx, "users")
 	return user, nil
 }
 
+// githubTransport is shared by the clients of github api, so the connections
+// are pooled across requests, it requires TLS 1.2 at least.
+var githubTransport = &http.Transport{
+	Proxy:               http.ProxyFromEnvironment,
+	TLSClientConfig:     &tls.Config{MinVersion: tls.VersionTLS12},
+	TLSHandshakeTimeout: 10 * time.Second,
+	IdleConnTimeout:     90 * time.Second,
+}
+
+// githubHTTPClient is the client of github api, it doesn't follow redirects,
+// the timeout is github.timeout, 5s by default.
+func githubHTTPClient() *http.Client {
+	timeout := 5 * time.Second
+	if d := configs.Current().Durations.GithubTimeout; d > 0 {
+		timeout = d
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: githubTransport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
 func fetchAccessToken(ctx context.Context, code string) (string, error) {
-	config := configs.AppConfig
-	client := external.HTTPClient()
+	config := configs.Current()
+	client := githubHTTPClient()
 	data, err := json.Marshal(map[string]interface{}{
 		"client_id":     config.Github.ClientID,
 		"client_secret": config.Github.ClientSecret,
@@ -118,7 +232,7 @@ func fetchAccessToken(ctx context.Context, code string) (string, error) {
 }
 
 func fetchOauthUser(ctx context.Context, accessToken string) (*GithubUser, error) {
-	client := external.HTTPClient()
+	client := githubHTTPClient()
 	req, err := http.NewRequest("GET", "https://api.github.com/user", nil)
 	if err != nil {
 		return nil, err
@@ -144,7 +258,7 @@ func fetchOauthUser(ctx context.Context, accessToken string) (*GithubUser, error
 }
 
 func featchUserEmail(ctx context.Context, accessToken string) (string, error) {
-	client := external.HTTPClient()
+	client := githubHTTPClient()
 	req, err := http.NewRequest("GET", "https://api.github.com/user/public_emails", nil)
 	if err != nil {
 		return "", err
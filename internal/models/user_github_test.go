@@ -0,0 +1,102 @@
+package models
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"satellity/internal/configs"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGithubHTTPClient(t *testing.T) {
+	assert := assert.New(t)
+	if configs.Current() == nil {
+		configs.Set(&configs.Option{})
+		defer func() { configs.Set(nil) }()
+	}
+	timeout := configs.Current().Durations.GithubTimeout
+	defer func() { configs.Current().Durations.GithubTimeout = timeout }()
+	configs.Current().Durations.GithubTimeout = 100 * time.Millisecond
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Second)
+	}))
+	defer slow.Close()
+	client := githubHTTPClient()
+	assert.Equal(uint16(tls.VersionTLS12), client.Transport.(*http.Transport).TLSClientConfig.MinVersion)
+	assert.True(client.Transport == githubHTTPClient().Transport)
+	start := time.Now()
+	_, err := client.Get(slow.URL)
+	assert.NotNil(err)
+	assert.True(time.Since(start) < time.Second)
+
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://attacker.example.com", http.StatusFound)
+	}))
+	defer redirect.Close()
+	resp, err := client.Get(redirect.URL)
+	assert.Nil(err)
+	assert.Equal(http.StatusFound, resp.StatusCode)
+	resp.Body.Close()
+}
+
+func TestResolveGithubUser(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	public, _ := x509.MarshalPKIXPublicKey(priv.Public())
+	verified := createTestUser(mctx, "im.yuqlee@gmail.com", "username", "password")
+	assert.NotNil(verified)
+	_, err := mctx.database.Exec("UPDATE users SET email_verified_at=$1 WHERE user_id=$2", time.Now(), verified.UserID)
+	assert.Nil(err)
+	unverified := createTestUser(mctx, "validfake@gmail.com", "usernamex", "password")
+	assert.NotNil(unverified)
+
+	user, err := resolveGithubUser(mctx, &GithubUser{Login: "octocat", NodeID: "MDQ6VXNlcjE=", Email: "IM.yuqlee@gmail.com"})
+	assert.Nil(err)
+	assert.Equal(verified.UserID, user.UserID)
+	user, err = saveGithubUser(mctx, user, hex.EncodeToString(public))
+	assert.Nil(err)
+	assert.Equal(verified.UserID, user.UserID)
+	user, err = resolveGithubUser(mctx, &GithubUser{Login: "octocat", NodeID: "MDQ6VXNlcjE="})
+	assert.Nil(err)
+	assert.Equal(verified.UserID, user.UserID)
+	assert.Equal("MDQ6VXNlcjE=", user.GithubID.String)
+
+	user, err = resolveGithubUser(mctx, &GithubUser{Login: "hubot", NodeID: "MDQ6VXNlcjI=", Email: "validfake@gmail.com"})
+	assert.Nil(err)
+	assert.NotEqual(unverified.UserID, user.UserID)
+	assert.False(user.Email.Valid)
+	user, err = saveGithubUser(mctx, user, hex.EncodeToString(public))
+	assert.Nil(err)
+	assert.NotEqual(unverified.UserID, user.UserID)
+	unverified, err = ReadUser(mctx, unverified.UserID)
+	assert.Nil(err)
+	assert.False(unverified.GithubID.Valid)
+
+	linked := createTestUser(mctx, "linked@gmail.com", "linkeduser", "password")
+	_, err = mctx.database.Exec("UPDATE users SET email_verified_at=$1 WHERE user_id=$2", time.Now(), linked.UserID)
+	assert.Nil(err)
+	user, err = resolveGithubUser(mctx, &GithubUser{Login: "monalisa", NodeID: "MDQ6VXNlcjM=", Email: "linked@gmail.com"})
+	assert.Nil(err)
+	assert.Equal(linked.UserID, user.UserID)
+	_, err = mctx.database.Exec("UPDATE users SET github_id=$1 WHERE user_id=$2", "MDQ6VXNlcjM=", unverified.UserID)
+	assert.Nil(err)
+	user, err = saveGithubUser(mctx, user, hex.EncodeToString(public))
+	assert.Nil(err)
+	assert.Equal(unverified.UserID, user.UserID)
+	linked, err = ReadUser(mctx, linked.UserID)
+	assert.Nil(err)
+	assert.False(linked.GithubID.Valid)
+}
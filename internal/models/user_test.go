@@ -1,16 +1,25 @@
 package models
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/x509"
 	"database/sql"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"satellity/internal/configs"
+	"satellity/internal/session"
+	"sort"
 	"strings"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/gofrs/uuid"
@@ -64,11 +73,15 @@ func TestUserCRUD(t *testing.T) {
 			assert.Equal(user.Nickname, new.Nickname)
 			err = bcrypt.CompareHashAndPassword([]byte(new.EncryptedPassword.String), []byte(tc.password))
 			assert.Nil(err)
-			new, err = ReadUser(ctx, uuid.Must(uuid.NewV4()).String())
+			new, err = ReadUser(ctx, fmt.Sprintf(" %s\n", user.UserID))
 			assert.Nil(err)
+			assert.NotNil(new)
+			assert.Equal(user.UserID, new.UserID)
+			new, err = ReadUser(ctx, uuid.Must(uuid.NewV4()).String())
+			assert.True(errors.Is(err, session.ErrNotFound))
 			assert.Nil(new)
 			new, err = ReadUserByUsernameOrEmail(ctx, "None")
-			assert.Nil(err)
+			assert.True(errors.Is(err, session.ErrNotFound))
 			assert.Nil(new)
 			new, err = ReadUserByUsernameOrEmail(ctx, tc.email)
 			assert.Nil(err)
@@ -79,7 +92,7 @@ func TestUserCRUD(t *testing.T) {
 			new, err = ReadUserByUsernameOrEmail(ctx, strings.ToUpper(tc.email))
 			assert.Nil(err)
 			assert.NotNil(new)
-			new, err = CreateSession(ctx, tc.email, tc.password, hex.EncodeToString(public))
+			new, err = CreateSession(ctx, tc.email, tc.password, hex.EncodeToString(public), false)
 			assert.Nil(err)
 			assert.NotNil(new)
 			assert.Equal(tc.username, user.Username)
@@ -102,7 +115,7 @@ func TestUserCRUD(t *testing.T) {
 			new, err = AuthenticateUser(ctx, ss)
 			assert.Nil(err)
 			assert.NotNil(new)
-			err = new.UpdateProfile(ctx, "Jason", "")
+			err = new.UpdateProfile(ctx, "Jason", "", "")
 			assert.Nil(err)
 			assert.Equal("Jason", new.Name())
 			new, err = ReadUserByUsernameOrEmail(ctx, tc.username)
@@ -116,20 +129,1668 @@ func TestUserCRUD(t *testing.T) {
 	}
 }
 
-func createTestUser(mctx *Context, email, username, password string) *User {
+func TestClassifyIdentity(t *testing.T) {
+	assert := assert.New(t)
+
+	identityCases := []struct {
+		identity string
+		kind     IdentityKind
+	}{
+		{"im.yuqlee@gmail.com", IdentityEmail},
+		{" IM.YUQLEE@GMAIL.COM ", IdentityEmail},
+		{"username", IdentityUsername},
+		{"User_Name_01", IdentityUsername},
+		{"abc", IdentityAmbiguous},
+		{"john.doe", IdentityAmbiguous},
+		{"@username", IdentityAmbiguous},
+		{"", IdentityAmbiguous},
+	}
+	for _, tc := range identityCases {
+		assert.Equal(tc.kind, ClassifyIdentity(tc.identity), tc.identity)
+	}
+}
+
+func TestIdentityQuery(t *testing.T) {
+	assert := assert.New(t)
+
+	query := identityQuery(ClassifyIdentity("im.yuqlee@gmail.com"))
+	assert.Contains(query, "WHERE LOWER(email)=$1")
+	assert.NotContains(query, "username=")
+	query = identityQuery(ClassifyIdentity("username"))
+	assert.Contains(query, "WHERE LOWER(username)=$1")
+	assert.NotContains(query, "email=")
+	query = identityQuery(ClassifyIdentity("john.doe"))
+	assert.Contains(query, "UNION")
+	assert.NotContains(query, " OR ")
+}
+
+func TestReadSignupCohorts(t *testing.T) {
+	assert := assert.New(t)
+	ctx := setupTestContext()
+	defer ctx.database.Close()
+	defer teardownTestContext(ctx)
+
+	admin := createTestUser(ctx, "im.yuqlee@gmail.com", "username", "password")
+	assert.NotNil(admin)
+	configs.Current().OperatorSet[admin.Email.String] = true
+	defer delete(configs.Current().OperatorSet, admin.Email.String)
+	member := createTestUser(ctx, "jason@gmail.com", "jason", "password")
+	assert.NotNil(member)
+	third := createTestUser(ctx, "lee@gmail.com", "yuqlee", "password")
+	assert.NotNil(third)
+
+	// 2019-05-06 and 2019-05-13 are Mondays
+	for id, createdAt := range map[string]string{
+		admin.UserID:  "2019-05-06T10:00:00Z",
+		member.UserID: "2019-05-08T10:00:00Z",
+		third.UserID:  "2019-05-14T10:00:00Z",
+	} {
+		_, err := ctx.database.Exec("UPDATE users SET created_at=$1 WHERE user_id=$2", createdAt, id)
+		assert.Nil(err)
+	}
+
+	cohorts, err := ReadSignupCohorts(ctx, member, "week")
+	assert.NotNil(err)
+	assert.Nil(cohorts)
+	cohorts, err = ReadSignupCohorts(ctx, admin, "year")
+	assert.NotNil(err)
+	assert.Nil(cohorts)
+	cohorts, err = ReadSignupCohorts(ctx, admin, "week")
+	assert.Nil(err)
+	assert.Len(cohorts, 2)
+	assert.Equal(int64(2), cohorts["2019-05-06"])
+	assert.Equal(int64(1), cohorts["2019-05-13"])
+}
+
+func TestUserUpdatedAt(t *testing.T) {
+	assert := assert.New(t)
+	ctx := setupTestContext()
+	defer ctx.database.Close()
+	defer teardownTestContext(ctx)
+
+	user := createTestUser(ctx, "im.yuqlee@gmail.com", "username", "password")
+	assert.NotNil(user)
+	createdAt := user.UpdatedAt
+	err := user.UpdateProfile(ctx, "Jason", "", "")
+	assert.Nil(err)
+	profileAt := user.UpdatedAt
+	assert.True(profileAt.After(createdAt))
+	_, err = user.CreateGroup(ctx, "valid group", "valid group name", "")
+	assert.Nil(err)
+	new, err := ReadUser(ctx, user.UserID)
+	assert.Nil(err)
+	assert.True(new.UpdatedAt.After(profileAt))
+	assert.Equal(int64(1), new.GroupsCount)
+
+	_, err = ctx.database.Exec("UPDATE users SET role=$1 WHERE user_id=$2", userRoleAdmin, user.UserID)
+	assert.Nil(err)
+	new, err = ReadUser(ctx, user.UserID)
+	assert.Nil(err)
+	admin := createTestUser(ctx, "validfake@gmail.com", "usernamey", "password")
+	assert.NotNil(admin)
+	count, err := SetRoles(ctx, new, []string{admin.UserID}, userRoleAdmin)
+	assert.Nil(err)
+	assert.Equal(int64(1), count)
+	admin, err = ReadUser(ctx, admin.UserID)
+	assert.Nil(err)
+	assert.True(admin.UpdatedAt.After(admin.CreatedAt))
+
+	roleAt := admin.UpdatedAt
+	clock := &fakeClock{now: roleAt.Add(-time.Hour)}
+	_, err = SetRoles(ctx.WithClock(clock), new, []string{admin.UserID}, userRoleMember)
+	assert.Nil(err)
+	admin, err = ReadUser(ctx, admin.UserID)
+	assert.Nil(err)
+	assert.True(admin.UpdatedAt.Equal(roleAt))
+}
+
+func TestUserRoleWithoutConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	config := configs.Current()
+	defer func() { configs.Set(config) }()
+	configs.Set(nil)
+	user := &User{Email: sql.NullString{String: "hi@gmail.com", Valid: true}}
+	assert.NotPanics(func() { user.Role() })
+	assert.Equal(userRoleMember, user.Role())
+	assert.False(user.isAdmin())
+}
+
+type testMXResolver map[string][]*net.MX
+
+func (r testMXResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	if records, ok := r[name]; ok {
+		return records, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func TestValidateEmailMX(t *testing.T) {
+	assert := assert.New(t)
+
+	config := configs.Current()
+	resolver := mxResolver
+	defer func() {
+		configs.Set(config)
+		mxResolver = resolver
+	}()
+	configs.Set(&configs.Option{})
+	mxResolver = testMXResolver{"satellity.org": {{Host: "mx.satellity.org.", Pref: 10}}}
+	ctx := context.Background()
+
+	assert.NotNil(validateEmailFormat(ctx, "hi@nomx.example"))
+	off, on := false, true
+	configs.Current().System.ValidateEmailMX = &off
+	assert.Nil(validateEmailFormat(ctx, "hi@nomx.example"))
+	configs.Current().System.ValidateEmailMX = &on
+	assert.Nil(validateEmailFormat(ctx, "hi@satellity.org"))
+	assert.Nil(validateEmailFormat(ctx, "hi@SATELLITY.org"))
+	assert.NotNil(validateEmailFormat(ctx, "hi@nomx.example"))
+	assert.NotNil(validateEmailFormat(ctx, "invalid email"))
+}
+
+func TestReadUsersPage(t *testing.T) {
+	assert := assert.New(t)
+	ctx := setupTestContext()
+	defer ctx.database.Close()
+	defer teardownTestContext(ctx)
+
+	for i := 0; i < 3; i++ {
+		user := createTestUser(ctx, fmt.Sprintf("validfake%02d@gmail.com", i), fmt.Sprintf("usernamex%02d", i), "password")
+		assert.NotNil(user)
+	}
+	page, err := ReadUsersPage(ctx, "", "", 2)
+	assert.Nil(err)
+	assert.Len(page.Items, 2)
+	assert.True(page.HasMore)
+	offset, id, err := decodeCursor(page.NextCursor, time.Now())
+	assert.Nil(err)
+	assert.True(offset.Equal(page.Items[1].CreatedAt))
+	assert.Equal(page.Items[1].UserID, id)
+	page, err = ReadUsersPage(ctx, page.NextCursor, "", 2)
+	assert.Nil(err)
+	assert.Len(page.Items, 1)
+	assert.Equal("usernamex00", page.Items[0].Username)
+	assert.False(page.HasMore)
+	assert.Equal("", page.NextCursor)
+	page, err = ReadUsersPage(ctx, "!invalid", "", 2)
+	assert.NotNil(err)
+	assert.Nil(page)
+	page, err = ReadUsersPage(ctx, "", "created_at", 2)
+	assert.Nil(err)
+	assert.Equal("usernamex00", page.Items[0].Username)
+	page, err = ReadUsersPage(ctx, "", "username", 2)
+	assert.NotNil(err)
+	assert.Nil(page)
+}
+
+func TestReadUsersPageSameCreatedAt(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	for i := 0; i < 5; i++ {
+		user := createTestUser(mctx, fmt.Sprintf("validfake%02d@gmail.com", i), fmt.Sprintf("usernamex%02d", i), "password")
+		assert.NotNil(user)
+	}
+	_, err := mctx.database.Exec("UPDATE users SET created_at=$1", time.Now().Add(-time.Hour))
+	assert.Nil(err)
+
+	for _, sort := range []string{"-created_at", "created_at"} {
+		seen := make(map[string]bool)
+		var cursor string
+		for {
+			page, err := ReadUsersPage(mctx, cursor, sort, 2)
+			assert.Nil(err)
+			for _, u := range page.Items {
+				assert.False(seen[u.UserID])
+				seen[u.UserID] = true
+			}
+			if !page.HasMore {
+				break
+			}
+			cursor = page.NextCursor
+		}
+		assert.Len(seen, 5)
+	}
+}
+
+func TestReadUsersFutureOffset(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	for i := 0; i < 2; i++ {
+		user := createTestUser(mctx, fmt.Sprintf("validfake%02d@gmail.com", i), fmt.Sprintf("usernamex%02d", i), "password")
+		assert.NotNil(user)
+	}
+	_, err := mctx.database.Exec("UPDATE users SET created_at=$1 WHERE username=$2", time.Now().Add(time.Hour), "usernamex01")
+	assert.Nil(err)
+
+	users, err := ReadUsers(mctx, time.Now())
+	assert.Nil(err)
+	future, err := ReadUsers(mctx, time.Now().AddDate(100, 0, 0))
+	assert.Nil(err)
+	assert.Len(users, 1)
+	assert.Len(future, 1)
+	assert.Equal(users[0].UserID, future[0].UserID)
+}
+
+func TestSetRoles(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	admin := createTestUser(mctx, "im.yuqlee@gmail.com", "username", "password")
+	assert.NotNil(admin)
+	var ids []string
+	for i := 0; i < 3; i++ {
+		user := createTestUser(mctx, fmt.Sprintf("validfake%02d@gmail.com", i), fmt.Sprintf("usernamex%02d", i), "password")
+		assert.NotNil(user)
+		ids = append(ids, user.UserID)
+	}
+	count, err := SetRoles(mctx, admin, ids, userRoleModerator)
+	assert.NotNil(err)
+	assert.Equal(int64(0), count)
+
+	_, err = mctx.database.Exec("UPDATE users SET role=$1 WHERE user_id=$2", userRoleAdmin, admin.UserID)
+	assert.Nil(err)
+	admin, err = ReadUser(mctx, admin.UserID)
+	assert.Nil(err)
+	_, err = SetRoles(mctx, admin, ids, "owner")
+	assert.NotNil(err)
+	count, err = SetRoles(mctx, admin, append(ids, uuid.Must(uuid.NewV4()).String()), userRoleModerator)
+	assert.Nil(err)
+	assert.Equal(int64(3), count)
+	for _, id := range ids {
+		user, err := ReadUser(mctx, id)
+		assert.Nil(err)
+		assert.Equal(userRoleModerator, user.Role())
+	}
+	count, err = SetRoles(mctx, admin, ids, userRoleModerator)
+	assert.Nil(err)
+	assert.Equal(int64(0), count)
+
+	count, err = SetRoles(mctx, admin, append(ids, admin.UserID), userRoleMember)
+	assert.NotNil(err)
+	assert.Equal(int64(0), count)
+	user, err := ReadUser(mctx, ids[0])
+	assert.Nil(err)
+	assert.Equal(userRoleModerator, user.Role())
+	admin, err = ReadUser(mctx, admin.UserID)
+	assert.Nil(err)
+	assert.Equal(userRoleAdmin, admin.Role())
+}
+
+func TestSetRolesConcurrently(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	var admins []*User
+	for i := 0; i < 2; i++ {
+		user := createTestUser(mctx, fmt.Sprintf("validfake%02d@gmail.com", i), fmt.Sprintf("usernamex%02d", i), "password")
+		assert.NotNil(user)
+		_, err := mctx.database.Exec("UPDATE users SET role=$1 WHERE user_id=$2", userRoleAdmin, user.UserID)
+		assert.Nil(err)
+		user, err = ReadUser(mctx, user.UserID)
+		assert.Nil(err)
+		admins = append(admins, user)
+	}
+
+	// each admin demotes itself, only one of them could
+	errs := make(chan error, len(admins))
+	for _, admin := range admins {
+		go func(admin *User) {
+			_, err := SetRoles(mctx, admin, []string{admin.UserID}, userRoleMember)
+			errs <- err
+		}(admin)
+	}
+	var failed int
+	for range admins {
+		if err := <-errs; err != nil {
+			assert.True(errors.Is(err, session.BadDataError(mctx.context)))
+			failed++
+		}
+	}
+	assert.Equal(1, failed)
+	row, err := mctx.database.QueryRow("SELECT count(*) FROM users WHERE role=$1", userRoleAdmin)
+	assert.Nil(err)
+	var count int64
+	assert.Nil(row.Scan(&count))
+	assert.Equal(int64(1), count)
+}
+
+func TestAnonymizeUser(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	user := createTestUser(mctx, "im.yuqlee@gmail.com", "username", "password")
+	assert.NotNil(user)
+	other := createTestUser(mctx, "validfake@gmail.com", "usernamex", "password")
+	assert.NotNil(other)
+	category, err := CreateCategory(mctx, "name", "alias", "Description", 0)
+	assert.Nil(err)
+	topic, err := user.CreateTopic(mctx, "title", "body", category.CategoryID, false)
+	assert.Nil(err)
+	err = user.UpdateProfile(mctx, "nickname", "", "biography")
+	assert.Nil(err)
+	assert.Nil(user.LinkProvider(mctx, "gitea", "1001", "gitea-handle"))
+
+	err = AnonymizeUser(mctx, other, user.UserID, "password")
+	assert.NotNil(err)
+	err = AnonymizeUser(mctx, user, uuid.Must(uuid.NewV4()).String(), "password")
+	assert.NotNil(err)
+	err = AnonymizeUser(mctx, user, user.UserID, "")
+	assert.Equal(10023, err.(session.Error).Code)
+	err = AnonymizeUser(mctx, user, user.UserID, "wrong password")
+	assert.True(errors.Is(err, session.InvalidPasswordError(mctx.context)))
+	err = AnonymizeUser(mctx, user, user.UserID, "password")
+	assert.Nil(err)
+
+	anonymous, err := ReadUser(mctx, user.UserID)
+	assert.Nil(err)
+	assert.Equal(user.UserID, anonymous.UserID)
+	assert.False(anonymous.Email.Valid)
+	assert.Equal(AnonymizedNickname, anonymous.Nickname)
+	assert.Equal("", anonymous.Biography)
+	assert.False(anonymous.EncryptedPassword.Valid)
+	assert.False(anonymous.GithubID.Valid)
+	providers, err := anonymous.LinkedProviders(mctx)
+	assert.Nil(err)
+	assert.Len(providers, 0)
+	audits, err := anonymous.ReadProfileAudits(mctx, &User{AssignedRole: userRoleAdmin})
+	assert.Nil(err)
+	assert.Len(audits, 0)
+	s, err := readTestSession(mctx, user.UserID, user.SessionID)
+	assert.Nil(err)
+	assert.Nil(s)
+	topic, err = ReadTopic(mctx, topic.TopicID)
+	assert.Nil(err)
+	assert.NotNil(topic)
+	assert.Equal(user.UserID, topic.UserID)
+
+	_, err = mctx.database.Exec("UPDATE users SET created_at=$1 WHERE user_id=$2", time.Now().Add(-48*time.Hour), user.UserID)
+	assert.Nil(err)
+	_, err = DeleteUnverifiedUsersOlderThan(mctx, time.Now().Add(-24*time.Hour))
+	assert.Nil(err)
+	topic, err = ReadTopic(mctx, topic.TopicID)
+	assert.Nil(err)
+	assert.NotNil(topic)
+}
+
+func TestUserPermissions(t *testing.T) {
+	assert := assert.New(t)
+
+	admin := &User{AssignedRole: userRoleAdmin}
+	for _, p := range []string{PermissionBan, PermissionDeleteTopic, PermissionDeleteComment, PermissionManageCategories, PermissionManageUsers} {
+		assert.True(admin.Permissions(nil)[p])
+	}
+	moderator := &User{AssignedRole: userRoleModerator}
+	permissions := moderator.Permissions(nil)
+	assert.True(permissions[PermissionBan])
+	assert.True(permissions[PermissionDeleteTopic])
+	assert.True(permissions[PermissionDeleteComment])
+	assert.False(permissions[PermissionManageCategories])
+	assert.False(permissions[PermissionManageUsers])
+	member := &User{AssignedRole: userRoleMember}
+	assert.Len(member.Permissions(nil), 0)
+}
+
+func TestCanAct(t *testing.T) {
+	assert := assert.New(t)
+
+	owner := &User{UserID: "owner", AssignedRole: userRoleMember}
+	admin := &User{UserID: "admin", AssignedRole: userRoleAdmin}
+	moderator := &User{UserID: "moderator", AssignedRole: userRoleModerator}
+	member := &User{UserID: "member", AssignedRole: userRoleMember}
+
+	assert.True(CanAct(owner, ActionEdit, owner.UserID))
+	assert.True(CanAct(owner, PermissionDeleteComment, owner.UserID))
+	assert.True(CanAct(admin, ActionEdit, owner.UserID))
+	assert.True(CanAct(admin, PermissionDeleteTopic, owner.UserID))
+	assert.True(CanAct(moderator, PermissionDeleteComment, owner.UserID))
+	assert.False(CanAct(moderator, ActionEdit, owner.UserID))
+	assert.False(CanAct(member, ActionEdit, owner.UserID))
+	assert.False(CanAct(member, PermissionDeleteComment, owner.UserID))
+	assert.False(CanAct(nil, ActionEdit, owner.UserID))
+	assert.False(CanAct(&User{}, ActionEdit, ""))
+	assert.True(isPermit(owner.UserID, owner))
+	assert.False(isPermit(owner.UserID, member))
+}
+
+func TestNicknameImpersonation(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	reject := configs.Current().System.RejectNicknameImpersonation
+	defer func() { configs.Current().System.RejectNicknameImpersonation = reject }()
+
+	admin := createTestUser(mctx, "im.yuqlee@gmail.com", "username", "password")
+	assert.NotNil(admin)
+	user := createTestUser(mctx, "validfake@gmail.com", "usernamex", "password")
+	assert.NotNil(user)
 	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	public, _ := x509.MarshalPKIXPublicKey(priv.Public())
-	user, _ := CreateUser(mctx, email, username, "nickname", "", password, hex.EncodeToString(public))
-	return user
+
+	configs.Current().System.RejectNicknameImpersonation = false
+	err := user.UpdateProfile(mctx, "USERNAME", "", "")
+	assert.Nil(err)
+	assert.Equal("USERNAME", user.Nickname)
+
+	configs.Current().System.RejectNicknameImpersonation = true
+	err = user.UpdateProfile(mctx, "Username", "", "")
+	assert.NotNil(err)
+	assert.Equal(10018, err.(session.Error).Code)
+	err = user.UpdateProfile(mctx, "usernamex", "", "")
+	assert.Nil(err)
+	_, err = CreateUser(mctx, "validfake02@gmail.com", "usernamexx", "username", "", "password", hex.EncodeToString(public))
+	assert.NotNil(err)
+	assert.Equal(10018, err.(session.Error).Code)
+	_, err = CreateUser(mctx, "validfake02@gmail.com", "usernamexx", "", "", "password", hex.EncodeToString(public))
+	assert.Nil(err)
 }
 
-func readTestSession(mctx *Context, uid, sid string) (*Session, error) {
-	ctx := mctx.context
-	var s *Session
-	err := mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
-		var err error
-		s, err = readSession(ctx, tx, uid, sid)
-		return err
-	})
-	return s, err
+func TestExportUserData(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	public, _ := x509.MarshalPKIXPublicKey(priv.Public())
+	secret := hex.EncodeToString(public)
+	user, err := CreateUser(mctx, "im.yuqlee@gmail.com", "username", "nickname", "", "password", secret)
+	assert.Nil(err)
+	other := createTestUser(mctx, "validfake@gmail.com", "usernamex", "password")
+	assert.NotNil(other)
+	category, err := CreateCategory(mctx, "name", "alias", "Description", 0)
+	assert.Nil(err)
+	topic, err := user.CreateTopic(mctx, "exported title", "exported body", category.CategoryID, false)
+	assert.Nil(err)
+	_, err = user.CreateComment(mctx, topic.TopicID, "exported comment")
+	assert.Nil(err)
+
+	data, err := ExportUserData(mctx, other, user.UserID)
+	assert.NotNil(err)
+	assert.Nil(data)
+	data, err = ExportUserData(mctx, user, user.UserID)
+	assert.Nil(err)
+	var export userExport
+	assert.Nil(json.Unmarshal(data, &export))
+	assert.Equal(user.UserID, export.Profile.UserID)
+	assert.Len(export.Sessions, 1)
+	assert.Equal(user.SessionID, export.Sessions[0].SessionID)
+	assert.Len(export.Topics, 1)
+	assert.Equal(topic.TopicID, export.Topics[0].TopicID)
+	assert.Len(export.Comments, 1)
+	assert.Equal("exported comment", export.Comments[0].Body)
+	assert.False(strings.Contains(string(data), user.EncryptedPassword.String))
+	assert.False(strings.Contains(string(data), secret))
+	assert.False(strings.Contains(string(data), "encrypted_password"))
+}
+
+func TestReadUsersOrdered(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	var ids []string
+	for i := 0; i < 4; i++ {
+		user := createTestUser(mctx, fmt.Sprintf("validfake%02d@gmail.com", i), fmt.Sprintf("usernamex%02d", i), "password")
+		assert.NotNil(user)
+		ids = append(ids, user.UserID)
+	}
+	shuffled := []string{ids[2], ids[0], uuid.Must(uuid.NewV4()).String(), ids[3], ids[1]}
+	users, err := ReadUsersOrdered(mctx, shuffled)
+	assert.Nil(err)
+	assert.Len(users, 4)
+	for i, id := range []string{ids[2], ids[0], ids[3], ids[1]} {
+		assert.Equal(id, users[i].UserID)
+	}
+	users, err = ReadUsersOrdered(mctx, nil)
+	assert.Nil(err)
+	assert.Len(users, 0)
+}
+
+type testHooks struct {
+	created []*User
+	updated [][]FieldChange
+}
+
+func (h *testHooks) OnUserCreated(u *User) error {
+	h.created = append(h.created, u)
+	return errors.New("welcome email failed")
+}
+
+func (h *testHooks) OnUserUpdated(u *User, changes []FieldChange) error {
+	h.updated = append(h.updated, changes)
+	return nil
+}
+
+func TestOnUserCreated(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	hooks := &testHooks{}
+	hctx := mctx.WithHooks(hooks)
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	public, _ := x509.MarshalPKIXPublicKey(priv.Public())
+	user, err := CreateUser(hctx, "im.yuqlee@gmail.com", "username", "nickname", "", "password", hex.EncodeToString(public))
+	assert.Nil(err)
+	assert.NotNil(user)
+	assert.Len(hooks.created, 1)
+	assert.Equal(user.UserID, hooks.created[0].UserID)
+	committed, err := ReadUser(mctx, hooks.created[0].UserID)
+	assert.Nil(err)
+	assert.Equal(user.UserID, committed.UserID)
+
+	_, err = CreateUser(hctx, "im.yuqlee@gmail.com", "username", "nickname", "", "password", hex.EncodeToString(public))
+	assert.NotNil(err)
+	assert.Len(hooks.created, 1)
+	_, err = CreateUser(mctx, "validfake@gmail.com", "usernamex", "nickname", "", "password", hex.EncodeToString(public))
+	assert.Nil(err)
+	assert.Len(hooks.created, 1)
+}
+
+func TestReadOnlyMode(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	user := createTestUser(mctx, "im.yuqlee@gmail.com", "username", "password")
+	assert.NotNil(user)
+	defer func() { configs.Current().Maintenance.ReadOnly = false }()
+	configs.Current().Maintenance.ReadOnly = true
+
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	public, _ := x509.MarshalPKIXPublicKey(priv.Public())
+	_, err := CreateUser(mctx, "validfake@gmail.com", "usernamex", "nickname", "", "password", hex.EncodeToString(public))
+	assert.NotNil(err)
+	assert.Equal(10019, err.(session.Error).Code)
+	_, err = CreateSession(mctx, "username", "password", hex.EncodeToString(public), false)
+	assert.NotNil(err)
+	err = user.UpdateProfile(mctx, "new nickname", "", "")
+	assert.NotNil(err)
+	current, err := ReadUser(mctx, user.UserID)
+	assert.Nil(err)
+	assert.Equal("nickname", current.Nickname)
+	seen := time.Now().Add(-time.Hour)
+	_, err = mctx.database.Exec("UPDATE sessions SET last_seen_at=$1 WHERE user_id=$2", seen, user.UserID)
+	assert.Nil(err)
+	assert.Nil(touchSession(mctx, user.UserID, user.SessionID))
+	s, err := ReadSession(mctx, user.UserID, user.SessionID)
+	assert.Nil(err)
+	assert.True(s.LastSeenAt.Before(seen.Add(time.Second)))
+
+	configs.Current().Maintenance.ReadOnly = false
+	_, err = CreateUser(mctx, "validfake@gmail.com", "usernamex", "nickname", "", "password", hex.EncodeToString(public))
+	assert.Nil(err)
+}
+
+func TestDeleteUnverifiedUsersOlderThan(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	old := createTestUser(mctx, "im.yuqlee@gmail.com", "username", "password")
+	assert.NotNil(old)
+	verified := createTestUser(mctx, "validfake@gmail.com", "usernamex", "password")
+	assert.NotNil(verified)
+	operator := createTestUser(mctx, "validfake02@gmail.com", "usernamexx", "password")
+	assert.NotNil(operator)
+	recent := createTestUser(mctx, "validfake03@gmail.com", "usernamexxx", "password")
+	assert.NotNil(recent)
+	configs.Current().OperatorSet[operator.Email.String] = true
+	defer delete(configs.Current().OperatorSet, operator.Email.String)
+
+	past := time.Now().Add(-48 * time.Hour)
+	_, err := mctx.database.Exec("UPDATE users SET created_at=$1 WHERE user_id<>$2", past, recent.UserID)
+	assert.Nil(err)
+	_, err = mctx.database.Exec("UPDATE users SET email_verified_at=$1 WHERE user_id=$2", past, verified.UserID)
+	assert.Nil(err)
+
+	count, err := DeleteUnverifiedUsersOlderThan(mctx, time.Now().Add(-24*time.Hour))
+	assert.Nil(err)
+	assert.Equal(int64(1), count)
+	_, err = ReadUser(mctx, old.UserID)
+	assert.True(errors.Is(err, session.ErrNotFound))
+	s, err := readTestSession(mctx, old.UserID, old.SessionID)
+	assert.Nil(err)
+	assert.Nil(s)
+	for _, u := range []*User{verified, operator, recent} {
+		user, err := ReadUser(mctx, u.UserID)
+		assert.Nil(err)
+		assert.NotNil(user)
+	}
+}
+
+func TestSafeOrderBy(t *testing.T) {
+	assert := assert.New(t)
+	allowed := map[string]string{"created_at": "u.created_at", "username": "u.username"}
+
+	orderBy, err := safeOrderBy("created_at", allowed)
+	assert.Nil(err)
+	assert.Equal("u.created_at ASC", orderBy)
+	orderBy, err = safeOrderBy("-username", allowed)
+	assert.Nil(err)
+	assert.Equal("u.username DESC", orderBy)
+	for _, field := range []string{"created_at; DROP TABLE users", "-created_at DESC", "email", "", "-"} {
+		orderBy, err = safeOrderBy(field, allowed)
+		assert.NotNil(err)
+		assert.Equal("", orderBy)
+	}
+}
+
+func TestUserBiographySanitize(t *testing.T) {
+	assert := assert.New(t)
+	ctx := setupTestContext()
+	defer ctx.database.Close()
+	defer teardownTestContext(ctx)
+
+	user := createTestUser(ctx, "im.yuqlee@gmail.com", "username", "password")
+	assert.NotNil(user)
+	err := user.UpdateProfile(ctx, "", "", "hello<script>alert('xss')</script> <b>world</b>")
+	assert.Nil(err)
+	new, err := ReadUser(ctx, user.UserID)
+	assert.Nil(err)
+	assert.Equal("hello world", new.Biography)
+	err = user.UpdateProfile(ctx, "", "", "1 < 2 and 3 > 2")
+	assert.Nil(err)
+	new, err = ReadUser(ctx, user.UserID)
+	assert.Nil(err)
+	assert.Equal("1 < 2 and 3 > 2", new.Biography)
+
+	policy := configs.Current().System.BiographyPolicy
+	defer func() { configs.Current().System.BiographyPolicy = policy }()
+	configs.Current().System.BiographyPolicy = BiographyPolicyMarkdown
+	err = user.UpdateProfile(ctx, "", "", "**bold** [link](https://satellity.org) <script>alert(1)</script>")
+	assert.Nil(err)
+	new, err = ReadUser(ctx, user.UserID)
+	assert.Nil(err)
+	assert.Equal("**bold** [link](https://satellity.org)", new.Biography)
+	err = user.UpdateProfile(ctx, "", "", "1 < 2 and 3 > 2")
+	assert.Nil(err)
+	new, err = ReadUser(ctx, user.UserID)
+	assert.Nil(err)
+	assert.Equal("1 < 2 and 3 > 2", new.Biography)
+	err = user.UpdateProfile(ctx, "", "", `<b onclick="alert(1)">bold</b> <a href="javascript:alert(1)">x</a> <a href="https://satellity.org">y</a>`)
+	assert.Nil(err)
+	new, err = ReadUser(ctx, user.UserID)
+	assert.Nil(err)
+	assert.Equal(`<b>bold</b> <a>x</a> <a href="https://satellity.org">y</a>`, new.Biography)
+}
+
+func TestUserGenerateNickname(t *testing.T) {
+	assert := assert.New(t)
+	ctx := setupTestContext()
+	defer ctx.database.Close()
+	defer teardownTestContext(ctx)
+
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	public, _ := x509.MarshalPKIXPublicKey(priv.Public())
+	user, err := CreateUser(ctx, "im.yuqlee@gmail.com", "username", "", "", "password", hex.EncodeToString(public))
+	assert.Nil(err)
+	assert.Equal("username", user.Nickname)
+
+	enabled, generator := configs.Current().System.GenerateNickname, NicknameGenerator
+	defer func() {
+		configs.Current().System.GenerateNickname, NicknameGenerator = enabled, generator
+	}()
+	configs.Current().System.GenerateNickname = true
+	assert.Regexp(`^[A-Z][a-z]+ [A-Z][a-z]+ \d{4}$`, NicknameGenerator())
+	NicknameGenerator = func() string { return "Brave Otter 4821" }
+	user, err = CreateUser(ctx, "jason@gmail.com", "jason", "  ", "", "password", hex.EncodeToString(public))
+	assert.Nil(err)
+	assert.Equal("Brave Otter 4821", user.Nickname)
+	user, err = CreateUser(ctx, "lee@gmail.com", "yuqlee", "Lee", "", "password", hex.EncodeToString(public))
+	assert.Nil(err)
+	assert.Equal("Lee", user.Nickname)
+}
+
+func TestUserProfileLength(t *testing.T) {
+	assert := assert.New(t)
+	ctx := setupTestContext()
+	defer ctx.database.Close()
+	defer teardownTestContext(ctx)
+
+	user := createTestUser(ctx, "im.yuqlee@gmail.com", "username", "password")
+	assert.NotNil(user)
+	err := user.UpdateProfile(ctx, strings.Repeat("中", MaximumNicknameSize), "", strings.Repeat("😀", MaximumBiographySize))
+	assert.Nil(err)
+	new, err := ReadUser(ctx, user.UserID)
+	assert.Nil(err)
+	assert.Equal(MaximumNicknameSize, utf8.RuneCountInString(new.Nickname))
+	assert.Equal(MaximumBiographySize, utf8.RuneCountInString(new.Biography))
+	err = user.UpdateProfile(ctx, strings.Repeat("中", MaximumNicknameSize+1), "", "")
+	assert.NotNil(err)
+	err = user.UpdateProfile(ctx, "", "", strings.Repeat("😀", MaximumBiographySize+1))
+	assert.NotNil(err)
+	new, err = ReadUser(ctx, user.UserID)
+	assert.Nil(err)
+	assert.Equal(strings.Repeat("😀", MaximumBiographySize), new.Biography)
+}
+
+func TestSessionMaxPerUser(t *testing.T) {
+	assert := assert.New(t)
+	ctx := setupTestContext()
+	defer ctx.database.Close()
+	defer teardownTestContext(ctx)
+
+	max := configs.Current().Session.MaxPerUser
+	defer func() { configs.Current().Session.MaxPerUser = max }()
+	configs.Current().Session.MaxPerUser = 2
+
+	user := createTestUser(ctx, "im.yuqlee@gmail.com", "username", "password")
+	assert.NotNil(user)
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	public, _ := x509.MarshalPKIXPublicKey(priv.Public())
+	second, err := CreateSession(ctx, "username", "password", hex.EncodeToString(public), false)
+	assert.Nil(err)
+	third, err := CreateSession(ctx, "username", "password", hex.EncodeToString(public), false)
+	assert.Nil(err)
+
+	sess, err := readTestSession(ctx, user.UserID, user.SessionID)
+	assert.Nil(err)
+	assert.Nil(sess)
+	for _, u := range []*User{second, third} {
+		sess, err = readTestSession(ctx, u.UserID, u.SessionID)
+		assert.Nil(err)
+		assert.NotNil(sess)
+
+		claims := &jwt.MapClaims{"uid": u.UserID, "sid": u.SessionID}
+		ss, err := jwt.NewWithClaims(jwt.SigningMethodES256, claims).SignedString(priv)
+		assert.Nil(err)
+		current, err := AuthenticateUser(ctx, ss)
+		assert.Nil(err)
+		assert.NotNil(current)
+	}
+
+	// sessions created at the same time keep the greater session_id
+	tied := ctx.WithClock(&fakeClock{now: time.Now().Add(time.Hour)})
+	var created []string
+	for i := 0; i < 3; i++ {
+		u, err := CreateSession(tied, "username", "password", hex.EncodeToString(public), false)
+		assert.Nil(err)
+		created = append(created, u.SessionID)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(created)))
+	var ids []string
+	rows, err := ctx.database.Query("SELECT session_id FROM sessions WHERE user_id=$1 ORDER BY session_id DESC", user.UserID)
+	assert.Nil(err)
+	for rows.Next() {
+		var id string
+		assert.Nil(rows.Scan(&id))
+		ids = append(ids, id)
+	}
+	assert.Nil(rows.Err())
+	rows.Close()
+	assert.Equal(created[:2], ids)
+}
+
+func TestSaveGithubUserConcurrently(t *testing.T) {
+	assert := assert.New(t)
+	ctx := setupTestContext()
+	defer ctx.database.Close()
+	defer teardownTestContext(ctx)
+
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	public, _ := x509.MarshalPKIXPublicKey(priv.Public())
+	newGithubUser := func(username string) *User {
+		return &User{
+			UserID:    uuid.Must(uuid.NewV4()).String(),
+			Username:  username,
+			GithubID:  sql.NullString{String: "MDQ6VXNlcjE=", Valid: true},
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+			isNew:     true,
+		}
+	}
+	// the first callback wins the insert between the read and insert of the second one
+	winner, err := saveGithubUser(ctx, newGithubUser("octocat_GH"), hex.EncodeToString(public))
+	assert.Nil(err)
+	assert.NotNil(winner)
+	loser, err := saveGithubUser(ctx, newGithubUser("octocat_gh_retry"), hex.EncodeToString(public))
+	assert.Nil(err)
+	assert.NotNil(loser)
+	assert.Equal(winner.UserID, loser.UserID)
+	assert.NotEqual(winner.SessionID, loser.SessionID)
+}
+
+type testUserRows struct {
+	ids   []string
+	index int
+}
+
+func (r *testUserRows) Next() bool {
+	r.index++
+	return r.index <= len(r.ids)
+}
+
+func (r *testUserRows) Scan(dest ...interface{}) error {
+	id := r.ids[r.index-1]
+	*dest[0].(*string) = id
+	if id == "corrupt" {
+		return errors.New("sql: Scan error on column index 1")
+	}
+	return nil
+}
+
+func (r *testUserRows) Err() error {
+	return nil
+}
+
+func TestUsersFromRowsPartial(t *testing.T) {
+	assert := assert.New(t)
+
+	rows := &testUserRows{ids: []string{"first", "corrupt", "third"}}
+	users, failures, err := usersFromRowsPartial(rows)
+	assert.Nil(err)
+	assert.Len(users, 2)
+	assert.Equal("first", users[0].UserID)
+	assert.Equal("third", users[1].UserID)
+	assert.Len(failures, 1)
+	assert.Equal("corrupt", failures[0].UserID)
+	assert.NotNil(failures[0].Err)
+}
+
+func TestFindCaseConflictingUsernames(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	// an old database without users_usernamex
+	_, err := mctx.database.Exec("DROP INDEX users_usernamex")
+	assert.Nil(err)
+	assert.NotNil(createTestUser(mctx, "bob@example.com", "Bobby", "password"))
+	assert.NotNil(createTestUser(mctx, "bob2@example.com", "bobby", "password"))
+	assert.NotNil(createTestUser(mctx, "alice@example.com", "alice", "password"))
+
+	groups, err := FindCaseConflictingUsernames(mctx)
+	assert.Nil(err)
+	assert.Len(groups, 1)
+	assert.ElementsMatch([]string{"Bobby", "bobby"}, groups[0])
+}
+
+func TestBootstrapFirstAdmin(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	bootstrap := configs.Current().System.BootstrapFirstAdmin
+	defer func() { configs.Current().System.BootstrapFirstAdmin = bootstrap }()
+
+	configs.Current().System.BootstrapFirstAdmin = false
+	user := createTestUser(mctx, "first@example.com", "first", "password")
+	assert.NotNil(user)
+	assert.Equal(userRoleMember, user.AssignedRole)
+	_, err := mctx.database.Exec("DELETE FROM users")
+	assert.Nil(err)
+
+	configs.Current().System.BootstrapFirstAdmin = true
+	admin := createTestUser(mctx, "admin@example.com", "admin", "password")
+	assert.NotNil(admin)
+	assert.Equal(userRoleAdmin, admin.AssignedRole)
+	admin, err = ReadUser(mctx, admin.UserID)
+	assert.Nil(err)
+	assert.Equal(userRoleAdmin, admin.Role())
+	member := createTestUser(mctx, "member@example.com", "member", "password")
+	assert.NotNil(member)
+	assert.Equal(userRoleMember, member.AssignedRole)
+
+	// signups after the first one don't lock the table, a concurrent writer
+	// holding ROW EXCLUSIVE doesn't block them
+	locked, release, released := make(chan struct{}), make(chan struct{}), make(chan error)
+	go func() {
+		released <- mctx.database.RunInTransaction(mctx.context, func(tx *sql.Tx) error {
+			_, err := tx.Exec("LOCK TABLE users IN ROW EXCLUSIVE MODE")
+			close(locked)
+			<-release
+			return err
+		})
+	}()
+	<-locked
+	done := make(chan *User, 1)
+	go func() {
+		done <- createTestUser(mctx, "later@example.com", "later", "password")
+	}()
+	var later *User
+	blocked := false
+	select {
+	case later = <-done:
+	case <-time.After(5 * time.Second):
+		blocked = true
+		assert.Fail("signup blocked on the users lock")
+	}
+	close(release)
+	assert.Nil(<-released)
+	if blocked {
+		later = <-done
+	}
+	assert.NotNil(later)
+	assert.Equal(userRoleMember, later.AssignedRole)
+}
+
+func TestSearchUsers(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	for i := 0; i < 25; i++ {
+		user := createTestUser(mctx, fmt.Sprintf("alice%d@example.com", i), fmt.Sprintf("Alice%02d", i), "password")
+		assert.NotNil(user)
+	}
+	assert.NotNil(createTestUser(mctx, "bob@example.com", "bobby", "password"))
+
+	seen := make(map[string]bool)
+	var cursor string
+	for pages := 0; ; pages++ {
+		page, err := SearchUsers(mctx, "alice", cursor, 10)
+		assert.Nil(err)
+		for _, u := range page.Items {
+			assert.False(seen[u.UserID])
+			seen[u.UserID] = true
+		}
+		// a concurrent signup sorted before the cursor isn't returned
+		if pages == 0 {
+			assert.NotNil(createTestUser(mctx, "alice@example.com", "alice", "password"))
+		}
+		if !page.HasMore {
+			assert.Equal(2, pages)
+			break
+		}
+		cursor = page.NextCursor
+	}
+	assert.Len(seen, 25)
+
+	page, err := SearchUsers(mctx, "ALICE_", "", 10)
+	assert.Nil(err)
+	assert.Len(page.Items, 0)
+	_, err = SearchUsers(mctx, "alice", "invalid", 10)
+	assert.NotNil(err)
+}
+
+func TestPasswordNeedsUpgrade(t *testing.T) {
+	assert := assert.New(t)
+
+	weak, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	assert.Nil(err)
+	user := &User{EncryptedPassword: sql.NullString{String: string(weak), Valid: true}}
+	assert.True(user.PasswordNeedsUpgrade(nil))
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), passwordCost())
+	assert.Nil(err)
+	user = &User{EncryptedPassword: sql.NullString{String: string(hash), Valid: true}}
+	assert.False(user.PasswordNeedsUpgrade(nil))
+
+	user = &User{}
+	assert.False(user.PasswordNeedsUpgrade(nil))
+}
+
+func TestUsernameLength(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	bounds := configs.Current().Username
+	defer func() { configs.Current().Username = bounds }()
+	configs.Current().Username.MinLength = 5
+	configs.Current().Username.MaxLength = 8
+
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	public, _ := x509.MarshalPKIXPublicKey(priv.Public())
+	secret := hex.EncodeToString(public)
+	_, err := CreateUser(mctx, "a@example.com", "abcd", "", "", "password", secret)
+	assert.NotNil(err)
+	_, err = CreateUser(mctx, "b@example.com", "abcde", "", "", "password", secret)
+	assert.Nil(err)
+	_, err = CreateUser(mctx, "c@example.com", "abcdefgh", "", "", "password", secret)
+	assert.Nil(err)
+	_, err = CreateUser(mctx, "d@example.com", "abcdefghi", "", "", "password", secret)
+	assert.NotNil(err)
+
+	configs.Current().Username.MinLength = 2
+	configs.Current().Username.MaxLength = 100
+	min, max := usernameBounds()
+	assert.Equal(MinimumUsernameSize, min)
+	assert.Equal(MaximumUsernameSize, max)
+}
+
+func TestNormalizeProfileInputs(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	policy := configs.Current().System.BiographyPolicy
+	defer func() { configs.Current().System.BiographyPolicy = policy }()
+	configs.Current().System.BiographyPolicy = BiographyPolicyMarkdown
+
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	public, _ := x509.MarshalPKIXPublicKey(priv.Public())
+	user, err := CreateUser(mctx, "im.yuqlee@gmail.com", "username", "  Jo   e\t ", "  about me \n", "password", hex.EncodeToString(public))
+	assert.Nil(err)
+	assert.Equal("Jo e", user.Nickname)
+	assert.Equal("about me", user.Biography)
+
+	// "e" followed by the combining acute accent is stored as the single "é"
+	err = user.UpdateProfile(mctx, "Jose\u0301", "", " Cafe\u0301 ")
+	assert.Nil(err)
+	user, err = ReadUser(mctx, user.UserID)
+	assert.Nil(err)
+	assert.Equal("Jos\u00e9", user.Nickname)
+	assert.Equal("Caf\u00e9", user.Biography)
+}
+
+func TestReadActiveUsers(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	recent := createTestUser(mctx, "recent@example.com", "recent", "password")
+	active := createTestUser(mctx, "active@example.com", "active", "password")
+	idle := createTestUser(mctx, "idle@example.com", "idle_user", "password")
+	assert.NotNil(idle)
+	_, err := mctx.database.Exec("UPDATE sessions SET last_seen_at=$1", time.Now().Add(-time.Hour))
+	assert.Nil(err)
+	_, err = mctx.database.Exec("UPDATE sessions SET last_seen_at=$1 WHERE user_id=$2", time.Now().Add(-2*time.Minute), recent.UserID)
+	assert.Nil(err)
+	assert.Nil(touchSession(mctx, active.UserID, active.SessionID))
+
+	users, err := ReadActiveUsers(mctx, 10*time.Minute, 10)
+	assert.Nil(err)
+	assert.Len(users, 2)
+	assert.Equal(active.UserID, users[0].UserID)
+	assert.Equal(recent.UserID, users[1].UserID)
+	users, err = ReadActiveUsers(mctx, 10*time.Minute, 1)
+	assert.Nil(err)
+	assert.Len(users, 1)
+}
+
+func TestDisplayName(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	user := createTestUser(mctx, "im.yuqlee@gmail.com", "username", "password")
+	assert.NotNil(user)
+	assert.False(user.DisplayName.Valid)
+	assert.Equal("nickname", user.DisplayedName())
+
+	err := user.UpdateProfile(mctx, "", "  Jane   Doe ", "")
+	assert.Nil(err)
+	user, err = ReadUser(mctx, user.UserID)
+	assert.Nil(err)
+	assert.Equal("Jane Doe", user.DisplayName.String)
+	assert.Equal("Jane Doe", user.DisplayedName())
+	assert.Equal("nickname", user.Nickname)
+
+	err = user.UpdateProfile(mctx, "", strings.Repeat("中", MaximumDisplayNameSize+1), "")
+	assert.NotNil(err)
+	err = user.UpdateProfile(mctx, "", strings.Repeat("中", MaximumDisplayNameSize), "")
+	assert.Nil(err)
+}
+
+func TestVerifyPassword(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	user := createTestUser(mctx, "im.yuqlee@gmail.com", "username", "password")
+	assert.NotNil(user)
+	assert.Nil(user.VerifyPassword(mctx, "password"))
+	err := user.VerifyPassword(mctx, "wrong password")
+	assert.True(errors.Is(err, session.InvalidPasswordError(mctx.context)))
+	count, err := user.FailedLoginCount(mctx, time.Now().Add(-time.Hour))
+	assert.Nil(err)
+	assert.Equal(int64(1), count)
+
+	limits := configs.Current().System.RateLimits
+	defer func() { configs.Current().System.RateLimits = limits }()
+	configs.Current().System.RateLimits.FailedLoginsPerHour = 2
+	err = user.VerifyPassword(mctx, "wrong password")
+	assert.True(errors.Is(err, session.InvalidPasswordError(mctx.context)))
+	err = user.VerifyPassword(mctx, "password")
+	assert.True(errors.Is(err, session.TooManyRequestsError(mctx.context)))
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	public, _ := x509.MarshalPKIXPublicKey(priv.Public())
+	_, err = CreateSession(mctx, "username", "password", hex.EncodeToString(public), false)
+	assert.True(errors.Is(err, session.TooManyRequestsError(mctx.context)))
+
+	user.EncryptedPassword = sql.NullString{}
+	err = user.VerifyPassword(mctx, "password")
+	assert.True(errors.Is(err, session.PasswordNotSetError(mctx.context)))
+}
+
+func TestCreateUserSession(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	public, _ := x509.MarshalPKIXPublicKey(priv.Public())
+	user, err := CreateUser(mctx, "im.yuqlee@gmail.com", "username", "nickname", "", "password", hex.EncodeToString(public))
+	assert.Nil(err)
+	assert.NotEmpty(user.SessionID)
+
+	claims := jwt.MapClaims{"uid": user.UserID, "sid": user.SessionID}
+	ss, err := jwt.NewWithClaims(jwt.SigningMethodES256, claims).SignedString(priv)
+	assert.Nil(err)
+	current, err := AuthenticateUser(mctx, ss)
+	assert.Nil(err)
+	assert.NotNil(current)
+	assert.Equal(user.UserID, current.UserID)
+	assert.Equal(user.SessionID, current.SessionID)
+}
+
+func TestSetProfileLocked(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	locked := session.ProfileLockedError(mctx.context)
+	user := createTestUser(mctx, "im.yuqlee@gmail.com", "username", "password")
+	admin := createTestUser(mctx, "admin@example.com", "admin", "password")
+	_, err := mctx.database.Exec("UPDATE users SET role=$1 WHERE user_id=$2", userRoleAdmin, admin.UserID)
+	assert.Nil(err)
+	admin.AssignedRole = userRoleAdmin
+
+	assert.NotNil(user.SetProfileLocked(mctx, user, true))
+	assert.Nil(user.SetProfileLocked(mctx, admin, true))
+	err = user.UpdateProfile(mctx, "spam", "", "spam")
+	assert.True(errors.Is(err, locked))
+	stale, err := ReadUser(mctx, user.UserID)
+	assert.Nil(err)
+	assert.True(stale.ProfileLocked)
+	stale.ProfileLocked = false
+	err = stale.UpdateProfile(mctx, "spam", "", "spam")
+	assert.True(errors.Is(err, locked))
+
+	assert.Nil(user.UpdateProfileAs(mctx, admin, "cleaned", "", ""))
+	user, err = ReadUser(mctx, user.UserID)
+	assert.Nil(err)
+	assert.Equal("cleaned", user.Nickname)
+
+	assert.Nil(user.SetProfileLocked(mctx, admin, false))
+	assert.Nil(user.UpdateProfile(mctx, "mine", "", ""))
+	assert.Equal("mine", user.Nickname)
+}
+
+func TestEmptyProfileUpdate(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	reject := configs.Current().System.RejectEmptyProfileUpdate
+	defer func() { configs.Current().System.RejectEmptyProfileUpdate = reject }()
+
+	user := createTestUser(mctx, "im.yuqlee@gmail.com", "username", "password")
+	assert.NotNil(user)
+	configs.Current().System.RejectEmptyProfileUpdate = false
+	assert.Nil(user.UpdateProfile(mctx, "  ", "\t", " \n "))
+	configs.Current().System.RejectEmptyProfileUpdate = true
+	err := user.UpdateProfile(mctx, "  ", "\t", " \n ")
+	assert.True(errors.Is(err, session.BadDataError(mctx.context)))
+	assert.Nil(user.UpdateProfile(mctx, "nickname", "", ""))
+}
+
+func createTestUser(mctx *Context, email, username, password string) *User {
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	public, _ := x509.MarshalPKIXPublicKey(priv.Public())
+	user, _ := CreateUser(mctx, email, username, "nickname", "", password, hex.EncodeToString(public))
+	return user
+}
+
+func readTestSession(mctx *Context, uid, sid string) (*Session, error) {
+	ctx := mctx.context
+	var s *Session
+	err := mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
+		var err error
+		s, err = readSession(ctx, tx, uid, sid)
+		if errors.Is(err, session.ErrNotFound) {
+			return nil
+		}
+		return err
+	})
+	return s, err
+}
+
+func TestReadUnmatchedOperators(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	operators := configs.Current().OperatorSet
+	defer func() { configs.Current().OperatorSet = operators }()
+	registered := createTestUser(mctx, "im.yuqlee@gmail.com", "username", "password")
+	assert.NotNil(registered)
+	member := createTestUser(mctx, "validfake@gmail.com", "usernamex", "password")
+	assert.NotNil(member)
+	configs.Current().OperatorSet = map[string]bool{registered.Email.String: true, "pending@gmail.com": true}
+
+	emails, err := ReadUnmatchedOperators(mctx, registered)
+	assert.Nil(err)
+	assert.Equal([]string{"pending@gmail.com"}, emails)
+	_, err = ReadUnmatchedOperators(mctx, member)
+	assert.NotNil(err)
+}
+
+func TestUpdateProfileCooldown(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	durations := configs.Current().Durations
+	defer func() { configs.Current().Durations = durations }()
+	configs.Current().Durations.ProfileUpdateCooldown = 10 * time.Second
+	clock := &fakeClock{now: time.Now()}
+	mctx = mctx.WithClock(clock)
+
+	user := createTestUser(mctx, "im.yuqlee@gmail.com", "username", "password")
+	assert.NotNil(user)
+	assert.Nil(user.UpdateProfile(mctx, "first", "", ""))
+	err := user.UpdateProfile(mctx, "second", "", "")
+	assert.Equal(http.StatusTooManyRequests, err.(session.Error).Code)
+
+	admin := &User{UserID: "admin", AssignedRole: userRoleAdmin}
+	assert.Nil(user.UpdateProfileAs(mctx, admin, "cleaned", "", ""))
+
+	clock.now = clock.now.Add(11 * time.Second)
+	assert.Nil(user.UpdateProfile(mctx, "third", "", ""))
+	user, err = ReadUser(mctx, user.UserID)
+	assert.Nil(err)
+	assert.Equal("third", user.Nickname)
+}
+
+func TestDefaultAvatarURL(t *testing.T) {
+	assert := assert.New(t)
+
+	user := &User{UserID: uuid.Must(uuid.NewV4()).String()}
+	same := &User{UserID: user.UserID, Nickname: "nickname"}
+	other := &User{UserID: uuid.Must(uuid.NewV4()).String()}
+	assert.Equal(user.DefaultAvatarURL(), user.DefaultAvatarURL())
+	assert.Equal(user.DefaultAvatarURL(), same.DefaultAvatarURL())
+	assert.NotEqual(user.DefaultAvatarURL(), other.DefaultAvatarURL())
+	assert.True(strings.HasPrefix(user.DefaultAvatarURL(), "https://www.gravatar.com/avatar/"))
+}
+
+func TestReadUsersUpdatedSince(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	first := createTestUser(mctx, "im.yuqlee@gmail.com", "username", "password")
+	assert.NotNil(first)
+	second := createTestUser(mctx, "validfake@gmail.com", "usernamex", "password")
+	assert.NotNil(second)
+	stale := createTestUser(mctx, "validfake02@gmail.com", "usernamexx", "password")
+	assert.NotNil(stale)
+	since := time.Now().Add(-time.Hour)
+	_, err := mctx.database.Exec("UPDATE users SET updated_at=$1", since.Add(-time.Hour))
+	assert.Nil(err)
+	_, err = mctx.database.Exec("UPDATE users SET updated_at=$1 WHERE user_id=$2", since, second.UserID)
+	assert.Nil(err)
+	_, err = mctx.database.Exec("UPDATE users SET updated_at=$1 WHERE user_id=$2", since.Add(time.Minute), first.UserID)
+	assert.Nil(err)
+
+	users, err := ReadUsersUpdatedSince(mctx, since, "", 10)
+	assert.Nil(err)
+	assert.Len(users, 2)
+	assert.Equal(second.UserID, users[0].UserID)
+	assert.Equal(first.UserID, users[1].UserID)
+	users, err = ReadUsersUpdatedSince(mctx, since, "", 1)
+	assert.Nil(err)
+	assert.Len(users, 1)
+	assert.Equal(second.UserID, users[0].UserID)
+	users, err = ReadUsersUpdatedSince(mctx, time.Now(), "", 10)
+	assert.Nil(err)
+	assert.Len(users, 0)
+
+	// a bulk update stamps the same updated_at, syncs page through it by user_id
+	_, err = mctx.database.Exec("UPDATE users SET updated_at=$1", since)
+	assert.Nil(err)
+	seen := make(map[string]bool)
+	after := ""
+	for {
+		users, err = ReadUsersUpdatedSince(mctx, since, after, 1)
+		assert.Nil(err)
+		if len(users) == 0 {
+			break
+		}
+		assert.False(seen[users[0].UserID])
+		seen[users[0].UserID] = true
+		after = users[0].UserID
+	}
+	assert.Len(seen, 3)
+}
+
+func TestOnUserUpdated(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	hooks := &testHooks{}
+	hctx := mctx.WithHooks(hooks)
+	user := createTestUser(mctx, "im.yuqlee@gmail.com", "username", "password")
+	assert.NotNil(user)
+	assert.Nil(user.UpdateProfile(hctx, user.Nickname, "", "new biography"))
+	assert.Len(hooks.updated, 1)
+	assert.Equal([]FieldChange{{Field: "biography", Old: "", New: "new biography"}}, hooks.updated[0])
+
+	assert.Nil(user.UpdateProfile(hctx, user.Nickname, "", "new biography"))
+	assert.Len(hooks.updated, 1)
+	admin := &User{UserID: "admin", AssignedRole: userRoleAdmin}
+	assert.Nil(user.SetProfileLocked(hctx, admin, true))
+	assert.Len(hooks.updated, 2)
+	assert.Equal([]FieldChange{{Field: "profile_locked", Old: "false", New: "true"}}, hooks.updated[1])
+}
+
+func TestReadUsersPageMaxDepth(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	system := configs.Current().System
+	defer func() { configs.Current().System = system }()
+	configs.Current().System.UsersListMaxDepth = 2
+
+	var member *User
+	for i := 0; i < 5; i++ {
+		member = createTestUser(mctx, fmt.Sprintf("validfake%02d@gmail.com", i), fmt.Sprintf("usernamex%02d", i), "password")
+		assert.NotNil(member)
+	}
+	page, err := ReadUsersPage(mctx, "", "", 2)
+	assert.Nil(err)
+	page, err = ReadUsersPage(mctx, page.NextCursor, "", 2)
+	assert.Nil(err)
+	assert.Len(page.Items, 2)
+	deep := page.NextCursor
+
+	page, err = ReadUsersPage(mctx, deep, "", 2)
+	assert.NotNil(err)
+	assert.Equal(401, err.(session.Error).Code)
+	assert.Nil(page)
+	page, err = ReadUsersPageAs(mctx, member, deep, "", 2)
+	assert.Nil(err)
+	assert.Len(page.Items, 1)
+
+	offset, _, err := decodeCursor(deep, time.Now())
+	assert.Nil(err)
+	users, err := ReadUsers(mctx, offset)
+	assert.NotNil(err)
+	assert.Equal(401, err.(session.Error).Code)
+	assert.Nil(users)
+
+	configs.Current().System.UsersListMaxDepth = 0
+	users, err = ReadUsers(mctx, offset)
+	assert.Nil(err)
+	assert.Len(users, 1)
+	page, err = ReadUsersPage(mctx, deep, "", 2)
+	assert.Nil(err)
+	assert.Len(page.Items, 1)
+}
+
+func TestCreateUserWithoutEmail(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	system := configs.Current().System
+	defer func() { configs.Current().System = system }()
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	public, _ := x509.MarshalPKIXPublicKey(priv.Public())
+
+	required := true
+	configs.Current().System.EmailRequired = &required
+	_, err := CreateUser(mctx, "", "username", "nickname", "", "password", hex.EncodeToString(public))
+	assert.NotNil(err)
+
+	optional := false
+	configs.Current().System.EmailRequired = &optional
+	user, err := CreateUser(mctx, "", "username", "nickname", "", "password", hex.EncodeToString(public))
+	assert.Nil(err)
+	assert.False(user.Email.Valid)
+	_, err = CreateUser(mctx, "", "usernamex", "nickname", "", "password", hex.EncodeToString(public))
+	assert.Nil(err)
+	_, err = CreateUser(mctx, "invalid email", "usernamexx", "nickname", "", "password", hex.EncodeToString(public))
+	assert.NotNil(err)
+
+	signedIn, err := CreateSession(mctx, "username", "password", hex.EncodeToString(public), false)
+	assert.Nil(err)
+	assert.Equal(user.UserID, signedIn.UserID)
+	assert.False(signedIn.Email.Valid)
+
+	_, err = mctx.database.Exec("UPDATE users SET created_at=$1 WHERE user_id=$2", time.Now().Add(-48*time.Hour), user.UserID)
+	assert.Nil(err)
+	count, err := DeleteUnverifiedUsersOlderThan(mctx, time.Now().Add(-24*time.Hour))
+	assert.Nil(err)
+	assert.Equal(int64(0), count)
+	_, err = ReadUser(mctx, user.UserID)
+	assert.Nil(err)
+}
+
+func TestFindUsersByEmailFragment(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	user := createTestUser(mctx, "im.yuqlee@gmail.com", "username", "password")
+	assert.NotNil(user)
+	other := createTestUser(mctx, "validfake@example.com", "usernamex", "password")
+	assert.NotNil(other)
+	admin := &User{UserID: "admin", AssignedRole: userRoleAdmin}
+
+	users, err := FindUsersByEmailFragment(mctx, admin, "YUQ")
+	assert.Nil(err)
+	assert.Len(users, 1)
+	assert.Equal(user.UserID, users[0].UserID)
+	users, err = FindUsersByEmailFragment(mctx, admin, "e_m")
+	assert.Nil(err)
+	assert.Len(users, 0)
+	_, err = FindUsersByEmailFragment(mctx, admin, "yu")
+	assert.NotNil(err)
+	_, err = FindUsersByEmailFragment(mctx, user, "yuq")
+	assert.NotNil(err)
+}
+
+func TestReadAllAdmins(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	operators := configs.Current().OperatorSet
+	defer func() { configs.Current().OperatorSet = operators }()
+	operator := createTestUser(mctx, "im.yuqlee@gmail.com", "username", "password")
+	assert.NotNil(operator)
+	assigned := createTestUser(mctx, "validfake@gmail.com", "usernamex", "password")
+	assert.NotNil(assigned)
+	both := createTestUser(mctx, "validfake02@gmail.com", "usernamexx", "password")
+	assert.NotNil(both)
+	member := createTestUser(mctx, "validfake03@gmail.com", "usernamexxx", "password")
+	assert.NotNil(member)
+	configs.Current().OperatorSet = map[string]bool{operator.Email.String: true, both.Email.String: true, "pending@gmail.com": true}
+	_, err := mctx.database.Exec("UPDATE users SET role=$1 WHERE user_id IN ($2, $3)", userRoleAdmin, assigned.UserID, both.UserID)
+	assert.Nil(err)
+
+	admins, err := ReadAllAdmins(mctx, operator)
+	assert.Nil(err)
+	assert.Len(admins, 3)
+	assert.Equal(operator.UserID, admins[0].UserID)
+	assert.Equal(assigned.UserID, admins[1].UserID)
+	assert.Equal(both.UserID, admins[2].UserID)
+	_, err = ReadAllAdmins(mctx, member)
+	assert.NotNil(err)
+}
+
+func TestAnonymizeUserReauth(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	user := createTestUser(mctx, "im.yuqlee@gmail.com", "username", "password")
+	assert.NotNil(user)
+	oauth := createTestUser(mctx, "validfake@gmail.com", "usernamex", "password")
+	assert.NotNil(oauth)
+	_, err := mctx.database.Exec("UPDATE users SET encrypted_password=NULL WHERE user_id=$1", oauth.UserID)
+	assert.Nil(err)
+	oauth.EncryptedPassword = sql.NullString{}
+	admin := &User{UserID: "admin", AssignedRole: userRoleAdmin}
+
+	assert.Nil(AnonymizeUser(mctx, admin, user.UserID, ""))
+
+	clock := &fakeClock{now: time.Now().Add(reauthWindow + time.Minute)}
+	err = AnonymizeUser(mctx.WithClock(clock), oauth, oauth.UserID, "")
+	assert.Equal(10023, err.(session.Error).Code)
+	assert.Nil(AnonymizeUser(mctx, oauth, oauth.UserID, ""))
+}
+
+func TestConfusableUsernames(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	system := configs.Current().System
+	defer func() { configs.Current().System = system }()
+	configs.Current().System.RejectConfusableUsernames = true
+
+	assert.Equal("hello_world", usernameSkeleton("He11o_WorId"))
+	assert.Equal("moon", usernameSkeleton("rnoon"))
+	assert.Equal("wow", usernameSkeleton("vvow"))
+
+	user := createTestUser(mctx, "im.yuqlee@gmail.com", "hello_world", "password")
+	assert.NotNil(user)
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	public, _ := x509.MarshalPKIXPublicKey(priv.Public())
+	for _, username := range []string{"he11o_world", "heIlo_world", "hell0_vvorld", "hello_wor1d"} {
+		_, err := CreateUser(mctx, "validfake@gmail.com", username, "nickname", "", "password", hex.EncodeToString(public))
+		assert.NotNil(err, username)
+		assert.Equal(10020, err.(session.Error).Code, username)
+	}
+	_, err := CreateUser(mctx, "validfake@gmail.com", "hеllo_world", "nickname", "", "password", hex.EncodeToString(public))
+	assert.NotNil(err)
+
+	configs.Current().System.RejectConfusableUsernames = false
+	_, err = CreateUser(mctx, "validfake@gmail.com", "he11o_world", "nickname", "", "password", hex.EncodeToString(public))
+	assert.Nil(err)
+}
+
+func TestUsernameCaseInsensitive(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	user := createTestUser(mctx, "bob@example.com", "CamelBob", "password")
+	assert.NotNil(user)
+	assert.Equal("CamelBob", user.Username)
+	found, err := ReadUserByUsernameOrEmail(mctx, "camelbob")
+	assert.Nil(err)
+	assert.Equal(user.UserID, found.UserID)
+	_, err = CreateUser(mctx, "bob2@example.com", "camelbob", "nickname", "", "password", "")
+	assert.NotNil(err)
+}
+
+func TestUsernameCaseSensitive(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	caseInsensitive := false
+	configs.Current().Username.CaseInsensitive = &caseInsensitive
+	assert.Nil(ApplyUsernameCaseMode(mctx))
+
+	upper := createTestUser(mctx, "bob@example.com", "CamelBob", "password")
+	assert.NotNil(upper)
+	lower := createTestUser(mctx, "bob2@example.com", "camelbob", "password")
+	assert.NotNil(lower)
+	assert.NotEqual(upper.UserID, lower.UserID)
+	found, err := ReadUserByUsernameOrEmail(mctx, "CamelBob")
+	assert.Nil(err)
+	assert.Equal(upper.UserID, found.UserID)
+	found, err = ReadUserByUsernameOrEmail(mctx, "camelbob")
+	assert.Nil(err)
+	assert.Equal(lower.UserID, found.UserID)
+	_, err = ReadUserByUsernameOrEmail(mctx, "CAMELBOB")
+	assert.NotNil(err)
+	_, err = CreateUser(mctx, "bob3@example.com", "camelbob", "nickname", "", "password", "")
+	assert.NotNil(err)
+	_, err = ReserveUsername(mctx, "camelbob")
+	assert.NotNil(err)
+	_, err = ReserveUsername(mctx, "Alice")
+	assert.Nil(err)
+	_, err = ReserveUsername(mctx, "alice")
+	assert.Nil(err)
+	assert.Nil(createTestUser(mctx, "alice@example.com", "alice", "password"))
+	assert.NotNil(createTestUser(mctx, "alice2@example.com", "ALICE", "password"))
+
+	configs.Current().Maintenance.ReadOnly = true
+	assert.True(errors.Is(ApplyUsernameCaseMode(mctx), session.ReadOnlyModeError(mctx.context)))
+	configs.Current().Maintenance.ReadOnly = false
+
+	caseInsensitive = true
+	assert.NotNil(ApplyUsernameCaseMode(mctx))
+	groups, err := FindCaseConflictingUsernames(mctx)
+	assert.Nil(err)
+	assert.Equal([][]string{{"CamelBob", "camelbob"}}, groups)
+}
+
+func TestUpdateProfileAudit(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	user := createTestUser(mctx, "im.yuqlee@gmail.com", "username", "password")
+	assert.Nil(user.UpdateProfile(mctx, "Jason", "", "hello"))
+	audits, err := user.ReadProfileAudits(mctx, user)
+	assert.Nil(err)
+	assert.Len(audits, 2)
+	assert.Equal(FieldChange{Field: "biography", Old: "", New: "hello"}, audits[0].Change)
+	assert.Equal(FieldChange{Field: "nickname", Old: "nickname", New: "Jason"}, audits[1].Change)
+	assert.Equal(user.UserID, audits[0].ActorID)
+	other := createTestUser(mctx, "other@gmail.com", "otheruser", "password")
+	_, err = user.ReadProfileAudits(mctx, other)
+	assert.NotNil(err)
+
+	_, err = mctx.database.Exec(dropProfileAuditsDDL)
+	assert.Nil(err)
+	assert.NotNil(user.UpdateProfile(mctx, "Failed", "", ""))
+	assert.Equal("Jason", user.Nickname)
+	user, err = ReadUser(mctx, user.UserID)
+	assert.Nil(err)
+	assert.Equal("Jason", user.Nickname)
 }
@@ -0,0 +1,143 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"satellity/internal/durable"
+	"satellity/internal/session"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+const usernameReservationsDDL = `
+CREATE TABLE IF NOT EXISTS username_reservations (
+	reservation_id        VARCHAR(36) PRIMARY KEY,
+	username              VARCHAR(64) NOT NULL,
+	expired_at            TIMESTAMP WITH TIME ZONE NOT NULL,
+	created_at            TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS username_reservations_usernamex ON username_reservations ((LOWER(username)));
+CREATE UNIQUE INDEX IF NOT EXISTS username_reservations_username_exactx ON username_reservations (username);
+`
+
+// usernameReservationTTL is how long a reserved username is held
+const usernameReservationTTL = 5 * time.Minute
+
+// ReserveUsername holds the username for a few minutes during a multi-step
+// signup, e.g. picking a username after the github authorization, others
+// can't take it until it's claimed by ClaimReservation or expired.
+func ReserveUsername(mctx *Context, username string) (string, error) {
+	ctx := mctx.context
+	if err := checkWritable(ctx); err != nil {
+		return "", err
+	}
+	username = strings.TrimSpace(username)
+	if !usernameRegexp().MatchString(username) {
+		return "", session.BadDataError(ctx)
+	}
+
+	id := uuid.Must(uuid.NewV4()).String()
+	err := mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
+		t := mctx.now()
+		_, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM username_reservations WHERE %s=$1 AND expired_at<=$2", usernameKeySQL()), usernameKey(username), t)
+		if err != nil {
+			return err
+		}
+		var exist bool
+		err = tx.QueryRowContext(ctx, fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM users WHERE %s=$1)", usernameKeySQL()), usernameKey(username)).Scan(&exist)
+		if err != nil {
+			return err
+		} else if exist {
+			return session.UsernameUnavailableError(ctx)
+		}
+		_, err = tx.ExecContext(ctx, "INSERT INTO username_reservations(reservation_id,username,expired_at,created_at) VALUES ($1,$2,$3,$4)", id, username, t.Add(usernameReservationTTL), t)
+		return err
+	})
+	if err != nil {
+		if durable.ClassifyError(err).Kind == durable.ErrorUniqueViolation {
+			return "", session.UsernameUnavailableError(ctx)
+		}
+		if _, ok := err.(session.Error); ok {
+			return "", err
+		}
+		return "", session.TransactionError(ctx, err)
+	}
+	return id, nil
+}
+
+// ClaimReservation sets the reserved username to the user and releases the
+// reservation, it fails if the reservation is expired, the profile of the
+// user is locked, or the username looks like the username of another user.
+func (u *User) ClaimReservation(mctx *Context, reservationID string) error {
+	ctx := mctx.context
+	if err := checkWritable(ctx); err != nil {
+		return err
+	}
+	if _, err := uuid.FromString(reservationID); err != nil {
+		return session.NotFoundError(ctx)
+	}
+
+	var username string
+	err := mctx.database.RunInTransaction(ctx, func(tx *sql.Tx) error {
+		var locked bool
+		err := tx.QueryRowContext(ctx, "SELECT profile_locked FROM users WHERE user_id=$1 FOR UPDATE", u.UserID).Scan(&locked)
+		if err == sql.ErrNoRows {
+			return session.NotFoundError(ctx)
+		} else if err != nil {
+			return err
+		} else if locked {
+			return session.ProfileLockedError(ctx)
+		}
+		var expiredAt time.Time
+		err = tx.QueryRowContext(ctx, "DELETE FROM username_reservations WHERE reservation_id=$1 RETURNING username,expired_at", reservationID).Scan(&username, &expiredAt)
+		if err == sql.ErrNoRows {
+			return session.NotFoundError(ctx)
+		} else if err != nil {
+			return err
+		}
+		if !expiredAt.After(mctx.now()) {
+			return session.NotFoundError(ctx)
+		}
+		if err := checkConfusableUsername(ctx, tx, username, u.UserID); err != nil {
+			return err
+		}
+		t, err := touchUpdatedAt(ctx, tx, "users", "user_id", u.UserID, mctx.now())
+		if err != nil {
+			return err
+		}
+		u.UpdatedAt = t
+		_, err = tx.ExecContext(ctx, "UPDATE users SET username=$1 WHERE user_id=$2", username, u.UserID)
+		return err
+	})
+	if err != nil {
+		if durable.ClassifyError(err).Kind == durable.ErrorUniqueViolation {
+			return session.UsernameUnavailableError(ctx)
+		}
+		if _, ok := err.(session.Error); ok {
+			return err
+		}
+		return session.TransactionError(ctx, err)
+	}
+	u.Username = username
+	authenticatedSessions.invalidateUser(u.UserID)
+	return nil
+}
+
+// checkUsernameReserved rejects a username reserved and not expired at now,
+// compared by usernameKeySQL like the usernames of users.
+func checkUsernameReserved(ctx context.Context, tx *sql.Tx, username string, now time.Time) error {
+	var reserved bool
+	query := fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM username_reservations WHERE %s=$1 AND expired_at>$2)", usernameKeySQL())
+	err := tx.QueryRowContext(ctx, query, usernameKey(username), now).Scan(&reserved)
+	if err != nil {
+		return err
+	}
+	if reserved {
+		return session.UsernameUnavailableError(ctx)
+	}
+	return nil
+}
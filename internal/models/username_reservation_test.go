@@ -0,0 +1,99 @@
+package models
+
+import (
+	"errors"
+	"satellity/internal/configs"
+	"satellity/internal/session"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsernameReservation(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	unavailable := session.UsernameUnavailableError(mctx.context)
+	id, err := ReserveUsername(mctx, "reserved")
+	assert.Nil(err)
+	_, err = ReserveUsername(mctx, "Reserved")
+	assert.True(errors.Is(err, unavailable))
+	assert.Nil(createTestUser(mctx, "other@example.com", "reserved", "password"))
+
+	_, err = mctx.database.Exec("UPDATE username_reservations SET expired_at=$1 WHERE reservation_id=$2", time.Now().Add(-time.Second), id)
+	assert.Nil(err)
+	other, err := ReserveUsername(mctx, "reserved")
+	assert.Nil(err)
+	user := createTestUser(mctx, "im.yuqlee@gmail.com", "username", "password")
+	assert.NotNil(user)
+	assert.NotNil(user.ClaimReservation(mctx, id))
+	assert.Nil(user.ClaimReservation(mctx, other))
+	assert.Equal("reserved", user.Username)
+	user, err = ReadUser(mctx, user.UserID)
+	assert.Nil(err)
+	assert.Equal("reserved", user.Username)
+	assert.NotNil(user.ClaimReservation(mctx, other))
+	_, err = ReserveUsername(mctx, "reserved")
+	assert.True(errors.Is(err, unavailable))
+}
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func TestUsernameReservationExpiry(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	clock := &fakeClock{now: time.Now()}
+	mctx = mctx.WithClock(clock)
+	id, err := ReserveUsername(mctx, "reserved")
+	assert.Nil(err)
+	_, err = ReserveUsername(mctx, "reserved")
+	assert.NotNil(err)
+
+	clock.now = clock.now.Add(usernameReservationTTL + time.Second)
+	_, err = ReserveUsername(mctx, "reserved")
+	assert.Nil(err)
+	user := createTestUser(mctx, "im.yuqlee@gmail.com", "username", "password")
+	assert.NotNil(user)
+	assert.NotNil(user.ClaimReservation(mctx, id))
+}
+
+func TestClaimReservationChecks(t *testing.T) {
+	assert := assert.New(t)
+	mctx := setupTestContext()
+	defer mctx.database.Close()
+	defer teardownTestContext(mctx)
+
+	system := configs.Current().System
+	defer func() { configs.Current().System = system }()
+	configs.Current().System.RejectConfusableUsernames = true
+
+	user := createTestUser(mctx, "im.yuqlee@gmail.com", "username", "password")
+	assert.NotNil(user)
+	assert.NotNil(createTestUser(mctx, "hello@example.com", "hello", "password"))
+
+	id, err := ReserveUsername(mctx, "he11o")
+	assert.Nil(err)
+	err = user.ClaimReservation(mctx, id)
+	assert.True(errors.Is(err, session.UsernameUnavailableError(mctx.context)))
+
+	id, err = ReserveUsername(mctx, "userrname")
+	assert.Nil(err)
+	_, err = mctx.database.Exec("UPDATE users SET profile_locked=true WHERE user_id=$1", user.UserID)
+	assert.Nil(err)
+	err = user.ClaimReservation(mctx, id)
+	assert.True(errors.Is(err, session.ProfileLockedError(mctx.context)))
+	_, err = mctx.database.Exec("UPDATE users SET profile_locked=false WHERE user_id=$1", user.UserID)
+	assert.Nil(err)
+	assert.Nil(user.ClaimReservation(mctx, id))
+	assert.Equal("userrname", user.Username)
+}
@@ -2,30 +2,305 @@ package models
 
 import (
 	"context"
+	"fmt"
 	"net"
 	"regexp"
+	"satellity/internal/configs"
 	"satellity/internal/session"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/text/unicode/norm"
+)
+
+// IdentityKind tells how an identity will be interpreted
+type IdentityKind int
+
+// IdentityKind values
+const (
+	IdentityAmbiguous IdentityKind = iota
+	IdentityEmail
+	IdentityUsername
+)
+
+// Biography sanitize policies
+const (
+	BiographyPolicyStrict   = "strict"
+	BiographyPolicyMarkdown = "markdown"
 )
 
 var (
 	emailRegexp = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+
+	// unsafeBiographyTags are dropped together with their content
+	unsafeBiographyTags = map[string]bool{"script": true, "style": true, "iframe": true, "object": true}
+	// markdownBiographyTags are the html tags kept by the markdown policy,
+	// all attributes are dropped except a http(s) href of a
+	markdownBiographyTags = map[string]bool{
+		"a": true, "b": true, "blockquote": true, "br": true, "code": true, "em": true,
+		"i": true, "li": true, "ol": true, "p": true, "pre": true, "strong": true, "ul": true,
+	}
+)
+
+const (
+	mxLookupTimeout = 3 * time.Second
+	mxCacheTTL      = 10 * time.Minute
 )
 
+type mxLookuper interface {
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+}
+
+// mxResolver is replaceable for tests
+var mxResolver mxLookuper = net.DefaultResolver
+
+var mxCache = struct {
+	sync.Mutex
+	domains map[string]time.Time
+}{domains: make(map[string]time.Time)}
+
+// Usernames are ASCII only, so lookalikes of other scripts are rejected by
+// the format already, the skeleton folds the ASCII ones: "0" and "o", "1",
+// "i", "I" and "l", "rn" and "m", "vv" and "w". usernameSkeletonSQL is the
+// same expression in SQL, indexed by users_username_skeletonx, keep them in
+// sync.
+const usernameSkeletonSQL = "replace(replace(translate(LOWER(username), '01i', 'oll'), 'rn', 'm'), 'vv', 'w')"
+
+var (
+	usernameSkeletonChars    = strings.NewReplacer("0", "o", "1", "l", "i", "l")
+	usernameSkeletonReplacer = strings.NewReplacer("rn", "m", "vv", "w")
+)
+
+// usernameSkeleton is the form of the username which its lookalikes share
+func usernameSkeleton(username string) string {
+	return usernameSkeletonReplacer.Replace(usernameSkeletonChars.Replace(strings.ToLower(username)))
+}
+
+// usernameCaseInsensitive is username.case_insensitive, true if unset
+func usernameCaseInsensitive() bool {
+	if configs.Current() == nil || configs.Current().Username.CaseInsensitive == nil {
+		return true
+	}
+	return *configs.Current().Username.CaseInsensitive
+}
+
+// usernameKeySQL is the expression usernames are unique by, it matches
+// users_usernamex if case insensitive, users_username_exactx otherwise.
+func usernameKeySQL() string {
+	if usernameCaseInsensitive() {
+		return "LOWER(username)"
+	}
+	return "username"
+}
+
+// usernameKey is the username in the form of usernameKeySQL
+func usernameKey(username string) string {
+	if usernameCaseInsensitive() {
+		return strings.ToLower(username)
+	}
+	return username
+}
+
+// emailRequired is system.email_required, true if unset
+func emailRequired() bool {
+	if configs.Current() == nil || configs.Current().System.EmailRequired == nil {
+		return true
+	}
+	return *configs.Current().System.EmailRequired
+}
+
+// validateEmailMX is system.validate_email_mx, true if unset
+func validateEmailMX() bool {
+	if configs.Current() == nil || configs.Current().System.ValidateEmailMX == nil {
+		return true
+	}
+	return *configs.Current().System.ValidateEmailMX
+}
+
 func validateEmailFormat(ctx context.Context, email string) error {
 	if !emailRegexp.MatchString(email) {
 		return session.InvalidEmailFormatError(ctx, email)
 	}
+	if !validateEmailMX() {
+		return nil
+	}
 	i := strings.LastIndexByte(email, '@')
-	if _, err := net.LookupMX(email[i+1:]); err != nil {
+	if !hasMXRecords(ctx, strings.ToLower(email[i+1:])) {
 		return session.InvalidEmailFormatError(ctx, email)
 	}
 	return nil
 }
 
+// hasMXRecords looks up MX of the domain with a short timeout, domains
+// have MX are cached for a while to avoid hammering DNS.
+func hasMXRecords(ctx context.Context, domain string) bool {
+	mxCache.Lock()
+	expiredAt, found := mxCache.domains[domain]
+	mxCache.Unlock()
+	if found && time.Now().Before(expiredAt) {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, mxLookupTimeout)
+	defer cancel()
+	records, err := mxResolver.LookupMX(ctx, domain)
+	if err != nil || len(records) == 0 {
+		return false
+	}
+	mxCache.Lock()
+	mxCache.domains[domain] = time.Now().Add(mxCacheTTL)
+	mxCache.Unlock()
+	return true
+}
+
+// ClassifyIdentity tells whether the identity is an email or a username, a
+// username never contains @, so an identity matches at most one of them,
+// IdentityAmbiguous is returned when neither matches.
+func ClassifyIdentity(identity string) IdentityKind {
+	identity = strings.TrimSpace(identity)
+	if strings.Contains(identity, "@") && emailRegexp.MatchString(identity) {
+		return IdentityEmail
+	}
+	if usernameRegexp().MatchString(identity) {
+		return IdentityUsername
+	}
+	return IdentityAmbiguous
+}
+
+// Username length bounds of the users table check
+const (
+	MinimumUsernameSize = 4
+	MaximumUsernameSize = 64
+)
+
+var usernameRegexps = struct {
+	sync.Mutex
+	compiled map[[2]int]*regexp.Regexp
+}{compiled: make(map[[2]int]*regexp.Regexp)}
+
+// usernameBounds are username.min_length and username.max_length, bounds the
+// database rejects fall back to the defaults.
+func usernameBounds() (int, int) {
+	min, max := MinimumUsernameSize, MaximumUsernameSize
+	if config := configs.Current(); config != nil {
+		if l := config.Username.MinLength; l >= MinimumUsernameSize && l <= MaximumUsernameSize {
+			min = l
+		}
+		if l := config.Username.MaxLength; l >= min && l <= MaximumUsernameSize {
+			max = l
+		}
+	}
+	return min, max
+}
+
+// usernameRegexp matches usernames of the configured length bounds
+func usernameRegexp() *regexp.Regexp {
+	min, max := usernameBounds()
+	usernameRegexps.Lock()
+	defer usernameRegexps.Unlock()
+	key := [2]int{min, max}
+	re, ok := usernameRegexps.compiled[key]
+	if !ok {
+		re = regexp.MustCompile(fmt.Sprintf(`(?i)^[a-z0-9][a-z0-9_]{%d,%d}$`, min-1, max-1))
+		usernameRegexps.compiled[key] = re
+	}
+	return re
+}
+
 func validateGroupFields(name string) bool {
 	if len(name) < MaximumGroupNameSize {
 		return false
 	}
 	return true
 }
+
+func validateDisplayName(displayName string) bool {
+	return utf8.RuneCountInString(displayName) <= MaximumDisplayNameSize
+}
+
+func validateProfileFields(nickname, biography string) bool {
+	return utf8.RuneCountInString(nickname) <= MaximumNicknameSize &&
+		utf8.RuneCountInString(biography) <= MaximumBiographySize
+}
+
+// normalizeString trims s and normalizes it to NFC
+func normalizeString(s string) string {
+	return norm.NFC.String(strings.TrimSpace(s))
+}
+
+// normalizeNickname is normalizeString with the internal whitespace collapsed
+func normalizeNickname(s string) string {
+	return norm.NFC.String(strings.Join(strings.Fields(s), " "))
+}
+
+// sanitizeBiography removes unsafe html from biography before it's stored,
+// the result is text, not entities, so "1 < 2" is kept as is. strict (default)
+// drops all tags, markdown keeps the markdown syntax and a safe subset of tags.
+func sanitizeBiography(biography string) string {
+	policy := BiographyPolicyStrict
+	if configs.Current() != nil && configs.Current().System.BiographyPolicy != "" {
+		policy = configs.Current().System.BiographyPolicy
+	}
+	markdown := policy == BiographyPolicyMarkdown
+
+	var b strings.Builder
+	var skip string
+	z := html.NewTokenizer(strings.NewReader(biography))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		// Token unescapes the text in place, so take the raw text first
+		raw := string(z.Raw())
+		token := z.Token()
+		if skip != "" {
+			if tt == html.EndTagToken && token.Data == skip {
+				skip = ""
+			}
+			continue
+		}
+		switch tt {
+		case html.TextToken:
+			if markdown {
+				// keep the entities typed by the user, they are rendered as text
+				b.WriteString(raw)
+			} else {
+				b.WriteString(token.Data)
+			}
+		case html.StartTagToken, html.SelfClosingTagToken, html.EndTagToken:
+			if unsafeBiographyTags[token.Data] {
+				if tt == html.StartTagToken {
+					skip = token.Data
+				}
+				continue
+			}
+			if markdown && markdownBiographyTags[token.Data] {
+				b.WriteString(safeBiographyTag(token).String())
+			}
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// safeBiographyTag drops all attributes of token but a http(s) href of a
+func safeBiographyTag(token html.Token) html.Token {
+	attrs := token.Attr
+	token.Attr = nil
+	if token.Data != "a" {
+		return token
+	}
+	for _, attr := range attrs {
+		if attr.Key != "href" {
+			continue
+		}
+		href := strings.ToLower(strings.TrimSpace(attr.Val))
+		if strings.HasPrefix(href, "https://") || strings.HasPrefix(href, "http://") {
+			token.Attr = []html.Attribute{{Key: "href", Val: attr.Val}}
+		}
+	}
+	return token
+}
@@ -16,6 +16,7 @@ const (
 	keyRemoteAddress     contextValueKey = 11
 	keyAuthorizationInfo contextValueKey = 12
 	keyRequestBody       contextValueKey = 13
+	keyUserAgent         contextValueKey = 14
 )
 
 // Logger read logger from context
@@ -40,6 +41,28 @@ func WithRender(ctx context.Context, r *render.Render) context.Context {
 	return context.WithValue(ctx, keyRender, r)
 }
 
+// RemoteAddress read the client ip from context
+func RemoteAddress(ctx context.Context) string {
+	v, _ := ctx.Value(keyRemoteAddress).(string)
+	return v
+}
+
+// WithRemoteAddress put the client ip into context
+func WithRemoteAddress(ctx context.Context, address string) context.Context {
+	return context.WithValue(ctx, keyRemoteAddress, address)
+}
+
+// UserAgent read the client user agent from context
+func UserAgent(ctx context.Context) string {
+	v, _ := ctx.Value(keyUserAgent).(string)
+	return v
+}
+
+// WithUserAgent put the client user agent into context
+func WithUserAgent(ctx context.Context, userAgent string) context.Context {
+	return context.WithValue(ctx, keyUserAgent, userAgent)
+}
+
 // RequestBody read request body from context
 func RequestBody(ctx context.Context) string {
 	v, _ := ctx.Value(keyRequestBody).(string)
@@ -17,6 +17,26 @@ type Error struct {
 	Code        int    `json:"code"`
 	Description string `json:"description"`
 	trace       string
+	cause       error
+}
+
+// ErrNotFound is the sentinel of NotFoundError, match it with errors.Is
+var ErrNotFound = Error{
+	Status:      http.StatusAccepted,
+	Code:        http.StatusNotFound,
+	Description: http.StatusText(http.StatusNotFound),
+}
+
+// Is reports whether target is an Error with the same status and code
+func (sessionError Error) Is(target error) bool {
+	t, ok := target.(Error)
+	return ok && t.Status == sessionError.Status && t.Code == sessionError.Code
+}
+
+// Unwrap returns the wrapped error, e.g. the driver error of TransactionError,
+// so errors.As could reach it.
+func (sessionError Error) Unwrap() error {
+	return sessionError.cause
 }
 
 func (sessionError Error) Error() string {
@@ -57,6 +77,12 @@ func BadDataError(ctx context.Context) Error {
 	return createError(ctx, http.StatusAccepted, 10002, description, nil)
 }
 
+// MissingFieldError is BadDataError naming the required field which is blank.
+func MissingFieldError(ctx context.Context, field string) Error {
+	description := fmt.Sprintf("The %s is required.", field)
+	return createError(ctx, http.StatusAccepted, 10002, description, nil)
+}
+
 // InvalidEmailFormatError means the email is invalid.
 func InvalidEmailFormatError(ctx context.Context, email string) Error {
 	description := fmt.Sprintf("Invalid email format %s.", email)
@@ -105,6 +131,60 @@ func InvalidGroupInvitationCodeError(ctx context.Context) Error {
 	return createError(ctx, http.StatusAccepted, 10017, description, nil)
 }
 
+// NicknameImpersonationError means the nickname is the username of another user.
+func NicknameImpersonationError(ctx context.Context) Error {
+	description := "Nickname is the username of another user."
+	return createError(ctx, http.StatusAccepted, 10018, description, nil)
+}
+
+// ReadOnlyModeError means writes are rejected during maintenance.
+func ReadOnlyModeError(ctx context.Context) Error {
+	description := "The site is in read only mode for maintenance."
+	return createError(ctx, http.StatusAccepted, 10019, description, nil)
+}
+
+// UsernameUnavailableError means the username is taken or reserved.
+func UsernameUnavailableError(ctx context.Context) Error {
+	description := "Username is taken or reserved."
+	return createError(ctx, http.StatusAccepted, 10020, description, nil)
+}
+
+// PasswordNotSetError means the user signs in by oauth only, without password.
+func PasswordNotSetError(ctx context.Context) Error {
+	description := "Password is not set."
+	return createError(ctx, http.StatusAccepted, 10021, description, nil)
+}
+
+// ProfileLockedError means the profile is locked by an admin.
+func ProfileLockedError(ctx context.Context) Error {
+	description := "Profile is locked."
+	return createError(ctx, http.StatusAccepted, 10022, description, nil)
+}
+
+// ReauthRequiredError means the action requires signing in again or the password.
+func ReauthRequiredError(ctx context.Context) Error {
+	description := "Re-authentication required."
+	return createError(ctx, http.StatusAccepted, 10023, description, nil)
+}
+
+// InvalidCursorError means the pagination cursor is malformed or tampered.
+func InvalidCursorError(ctx context.Context) Error {
+	description := "Invalid pagination cursor."
+	return createError(ctx, http.StatusAccepted, 10024, description, nil)
+}
+
+// TooManyRequestsError means the request is throttled, try it later.
+func TooManyRequestsError(ctx context.Context) Error {
+	description := http.StatusText(http.StatusTooManyRequests)
+	return createError(ctx, http.StatusAccepted, http.StatusTooManyRequests, description, nil)
+}
+
+// PayloadTooLargeError means the uploaded data exceeds the size limit.
+func PayloadTooLargeError(ctx context.Context) Error {
+	description := http.StatusText(http.StatusRequestEntityTooLarge)
+	return createError(ctx, http.StatusAccepted, http.StatusRequestEntityTooLarge, description, nil)
+}
+
 // ServerError means some server error are occurred.
 func ServerError(ctx context.Context, err error) Error {
 	description := http.StatusText(http.StatusInternalServerError)
@@ -141,5 +221,6 @@ func createError(ctx context.Context, status, code int, description string, err
 		Code:        code,
 		Description: description,
 		trace:       trace,
+		cause:       err,
 	}
 }
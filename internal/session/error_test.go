@@ -0,0 +1,37 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorUnwrap(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	err := error(TransactionError(ctx, &pq.Error{Code: "23505", Constraint: "users_usernamex"}))
+	var pqErr *pq.Error
+	assert.True(errors.As(err, &pqErr))
+	assert.Equal(pq.ErrorCode("23505"), pqErr.Code)
+	assert.Equal("users_usernamex", pqErr.Constraint)
+
+	err = ServerError(ctx, TransactionError(ctx, &pq.Error{Code: "40001"}))
+	assert.True(errors.As(err, &pqErr))
+	assert.Equal(pq.ErrorCode("40001"), pqErr.Code)
+	assert.Nil(errors.Unwrap(NotFoundError(ctx)))
+}
+
+func TestErrorIs(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	assert.True(errors.Is(NotFoundError(ctx), ErrNotFound))
+	assert.True(errors.Is(fmt.Errorf("read: %w", NotFoundError(ctx)), ErrNotFound))
+	assert.False(errors.Is(ForbiddenError(ctx), ErrNotFound))
+	assert.False(errors.Is(Error{Status: 404, Code: 404}, ErrNotFound))
+}
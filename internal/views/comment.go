@@ -39,11 +39,18 @@ func RenderComment(w http.ResponseWriter, r *http.Request, comment *models.Comme
 	RenderResponse(w, r, buildComment(comment))
 }
 
-// RenderComments response a bundle of comments
-func RenderComments(w http.ResponseWriter, r *http.Request, comments []*models.Comment) {
-	views := make([]CommentView, len(comments))
-	for i, comment := range comments {
+// CommentsPageView is the response body of a page of comments
+type CommentsPageView struct {
+	Comments   []CommentView `json:"comments"`
+	NextCursor string        `json:"next_cursor"`
+	HasMore    bool          `json:"has_more"`
+}
+
+// RenderCommentsPage response a page of comments with the cursor of the next
+func RenderCommentsPage(w http.ResponseWriter, r *http.Request, page *models.Page[*models.Comment]) {
+	views := make([]CommentView, len(page.Items))
+	for i, comment := range page.Items {
 		views[i] = buildComment(comment)
 	}
-	RenderResponse(w, r, views)
+	RenderResponse(w, r, CommentsPageView{Comments: views, NextCursor: page.NextCursor, HasMore: page.HasMore})
 }
@@ -0,0 +1,21 @@
+package views
+
+import (
+	"net/http"
+	"satellity/internal/models"
+	"satellity/internal/session"
+)
+
+// RenderHealth response the health, with the error status if any check fails,
+// so the probes see which check it is.
+func RenderHealth(w http.ResponseWriter, r *http.Request, health *models.Health, err error) {
+	if err == nil {
+		RenderResponse(w, r, health)
+		return
+	}
+	sessionError, ok := err.(session.Error)
+	if !ok {
+		sessionError = session.ServerError(r.Context(), err)
+	}
+	session.Render(r.Context()).JSON(w, sessionError.Status, ResponseView{Data: health, Error: sessionError})
+}
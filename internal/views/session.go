@@ -0,0 +1,39 @@
+package views
+
+import (
+	"net/http"
+	"satellity/internal/models"
+	"time"
+)
+
+// SessionView is the response body of session, the secret is never rendered
+type SessionView struct {
+	Type       string    `json:"type"`
+	SessionID  string    `json:"session_id"`
+	DeviceName string    `json:"device_name"`
+	UserAgent  string    `json:"user_agent"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	CreatedAt  time.Time `json:"created_at"`
+	IsCurrent  bool      `json:"is_current"`
+}
+
+func buildSession(s *models.Session, current *models.User) SessionView {
+	return SessionView{
+		Type:       "session",
+		SessionID:  s.SessionID,
+		DeviceName: s.DeviceName.String,
+		UserAgent:  s.UserAgent.String,
+		LastSeenAt: s.LastSeenAt,
+		CreatedAt:  s.CreatedAt,
+		IsCurrent:  s.IsCurrent(current),
+	}
+}
+
+// RenderSessions response sessions of the current user
+func RenderSessions(w http.ResponseWriter, r *http.Request, sessions []*models.Session, current *models.User) {
+	sessionViews := make([]SessionView, len(sessions))
+	for i, s := range sessions {
+		sessionViews[i] = buildSession(s, current)
+	}
+	RenderResponse(w, r, sessionViews)
+}
@@ -14,6 +14,7 @@ type UserView struct {
 	Type        string    `json:"type"`
 	UserID      string    `json:"user_id"`
 	Nickname    string    `json:"nickname"`
+	DisplayName string    `json:"display_name"`
 	Biography   string    `json:"biography"`
 	AvatarURL   string    `json:"avatar_url"`
 	GroupsCount int64     `json:"groups_count"`
@@ -31,12 +32,17 @@ type AccountView struct {
 }
 
 func buildUser(user *models.User) UserView {
+	avatarURL := user.DefaultAvatarURL()
+	if user.Email.Valid && user.Email.String != "" {
+		avatarURL = fmt.Sprintf("https://www.gravatar.com/avatar/%x?s=180&d=wavatar", md5.Sum([]byte(strings.ToLower(user.Email.String))))
+	}
 	return UserView{
 		Type:        "user",
 		UserID:      user.UserID,
 		Nickname:    user.Name(),
+		DisplayName: user.DisplayedName(),
 		Biography:   user.Biography,
-		AvatarURL:   fmt.Sprintf("https://www.gravatar.com/avatar/%x?s=180&d=wavatar", md5.Sum([]byte(strings.ToLower(user.Email.String)))),
+		AvatarURL:   avatarURL,
 		GroupsCount: user.GroupsCount,
 		CreatedAt:   user.CreatedAt,
 		UpdatedAt:   user.UpdatedAt,
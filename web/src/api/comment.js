@@ -5,7 +5,7 @@ class Comment {
 
   index(id) {
     return this.api.axios.get(`/topics/${id}/comments`).then((resp) => {
-      return resp.data;
+      return resp.data.comments;
     });
   }
 
@@ -112,6 +112,8 @@ function sign(uid, sid, privateKey, method, uri, body) {
     jti: uuid(),
     sig: md.digest().toHex()
   };
+  if (Config.JwtIssuer) { oPayload.iss = Config.JwtIssuer; }
+  if (Config.JwtAudience) { oPayload.aud = Config.JwtAudience; }
   let sHeader = JSON.stringify(oHeader);
   let sPayload = JSON.stringify(oPayload);
   let pwd = Cookies.get('sid');
@@ -2,6 +2,8 @@ const Config = {
   Name: process.env.SITE_NAME,
   GithubClientId: process.env.GITHUB_CLIENT_ID,
   ApiHost: process.env.API_HOST,
+  JwtIssuer: process.env.JWT_ISSUER,
+  JwtAudience: process.env.JWT_AUDIENCE,
 }
 
 export default Config;